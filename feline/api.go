@@ -0,0 +1,1292 @@
+package feline
+
+import (
+    "database/sql"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/ruuzia/lynx/linefile"
+)
+
+/******************************
+ ********* API v1 *************
+ ******************************/
+
+// The /api/v1 endpoints are documented in API.md. Every endpoint
+// requires the session_token cookie from /login and responds with
+// JSON. Errors are reported as {"error": "message"}.
+
+func registerAPI(mux *http.ServeMux) {
+    mux.HandleFunc("GET /api/v1/linesets", apiListLineSets)
+    mux.HandleFunc("POST /api/v1/linesets", apiCreateLineSet)
+    mux.HandleFunc("GET /api/v1/linesets/{set}", apiGetLineSet)
+    mux.HandleFunc("PUT /api/v1/linesets/{set}", apiUpdateLineSet)
+    mux.HandleFunc("PATCH /api/v1/linesets/{set}", apiPatchLineSet)
+    mux.HandleFunc("DELETE /api/v1/linesets/{set}", apiDeleteLineSet)
+    mux.HandleFunc("GET /api/v1/linesets/deleted", apiListDeletedLineSets)
+    mux.HandleFunc("POST /api/v1/linesets/{set}/restore", apiRestoreLineSet)
+    mux.HandleFunc("POST /api/v1/linesets/{set}/duplicate", apiDuplicateLineSet)
+    mux.HandleFunc("GET /api/v1/linesets/{set}/lines", apiListLines)
+    mux.HandleFunc("GET /api/v1/linesets/{set}/revisions", apiListRevisions)
+    mux.HandleFunc("GET /api/v1/linesets/{set}/revisions/{revision}", apiGetRevision)
+    mux.HandleFunc("POST /api/v1/linesets/{set}/revisions/{revision}/restore", apiRestoreRevision)
+    mux.HandleFunc("GET /api/v1/linesets/{set}/outline", apiLineSetOutline)
+    mux.HandleFunc("GET /api/v1/linesets/{set}/export", apiExportLineSet)
+    mux.HandleFunc("PATCH /api/v1/linesets/{set}/lines/{line}", apiPatchLine)
+    mux.HandleFunc("GET /api/v1/linesets/{set}/lines/{line}/audio/{kind}", apiLineAudio)
+    mux.HandleFunc("PUT /api/v1/linesets/{set}/lines/{line}/audio/{kind}", apiLineAudio)
+    mux.HandleFunc("DELETE /api/v1/linesets/{set}/lines/{line}/audio/{kind}", apiLineAudio)
+    mux.HandleFunc("GET /api/v1/linesets/{set}/lines/{line}/speech/{part}", apiLineSpeech)
+    mux.HandleFunc("GET /api/v1/linesets/{set}/voices", apiListVoices)
+    mux.HandleFunc("GET /api/v1/linesets/{set}/rehearsal", apiRehearsal)
+    mux.HandleFunc("PUT /api/v1/linesets/{set}/voices", apiSetVoices)
+    mux.HandleFunc("POST /api/v1/linesets/{set}/lines/{line}/grade", apiGradeLine)
+    mux.HandleFunc("GET /api/v1/linesets/{set}/due", apiListDueLines)
+    mux.HandleFunc("POST /api/v1/linesets/{set}/lines/{line}/attempts", apiRecordAttempt)
+    mux.HandleFunc("GET /api/v1/linesets/{set}/stats", apiLineSetStats)
+    mux.HandleFunc("POST /api/v1/linesets/{set}/lines/{line}/check", apiCheckLine)
+    mux.HandleFunc("GET /api/v1/linesets/{set}/lines/{line}/hints", apiLineHints)
+    mux.HandleFunc("GET /api/v1/linesets/{set}/lines/{line}/monologue", apiMonologueDrill)
+    mux.HandleFunc("POST /api/v1/roles", apiListRoles)
+    mux.HandleFunc("POST /api/v1/cards/preview", apiPreviewCards)
+    mux.HandleFunc("GET /api/v1/silence", apiSilence)
+    mux.HandleFunc("GET /api/v1/public", apiSearchPublic)
+    mux.HandleFunc("GET /api/v1/public/{set}", apiGetPublic)
+    mux.HandleFunc("GET /api/v1/shared/{token}", apiGetShared)
+    mux.HandleFunc("POST /api/v1/public/{set}/copy", apiCopyLineSet)
+    mux.HandleFunc("GET /api/v1/productions", apiListProductions)
+    mux.HandleFunc("POST /api/v1/productions", apiCreateProduction)
+    mux.HandleFunc("GET /api/v1/productions/{production}", apiGetProduction)
+    mux.HandleFunc("POST /api/v1/productions/{production}/members", apiInviteMember)
+    mux.HandleFunc("POST /api/v1/productions/{production}/invitation", apiRespondToInvitation)
+    mux.HandleFunc("POST /api/v1/productions/{production}/scripts", apiAddScript)
+    mux.HandleFunc("GET /api/v1/productions/{production}/progress", apiProductionProgress)
+    mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+        writeJSONError(w, http.StatusNotFound, "No such endpoint")
+    })
+}
+
+type apiError struct {
+    Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(data)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+    writeJSON(w, status, apiError{Error: message})
+}
+
+// Reports a database error, treating sql.ErrNoRows as not found.
+func writeDatabaseError(w http.ResponseWriter, err error) {
+    if errors.Is(err, sql.ErrNoRows) {
+        writeJSONError(w, http.StatusNotFound, "Not found")
+        return
+    }
+    debug.Println("[api]", err)
+    writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, payload any) bool {
+    if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+        writeJSONError(w, http.StatusBadRequest, "Invalid JSON: " + err.Error())
+        return false
+    }
+    return true
+}
+
+func apiUser(w http.ResponseWriter, r *http.Request) (UserId, bool) {
+    userId, err := CheckAuth(w, r)
+    if err != nil {
+        writeJSONError(w, http.StatusUnauthorized, "Not logged in")
+        return -1, false
+    }
+    return userId, true
+}
+
+// Looks up the line set named by the {set} path parameter, making sure
+// it belongs to the logged in user.
+func apiLineSet(w http.ResponseWriter, r *http.Request) (UserId, LineSet, bool) {
+    userId, ok := apiUser(w, r)
+    if !ok {
+        return -1, LineSet{}, false
+    }
+    id, err := strconv.Atoi(r.PathValue("set"))
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, "Invalid line set id")
+        return -1, LineSet{}, false
+    }
+    set, err := GetLineSet(userId, LineSetId(id))
+    if err != nil {
+        writeDatabaseError(w, err)
+        return -1, LineSet{}, false
+    }
+    return userId, set, true
+}
+
+// Client errors from parsing or validating line set text are returned
+// as 400 or 409 rather than 500.
+func writeLineSetError(w http.ResponseWriter, err error) {
+    var parseErr *linefile.ParseError
+    var csvErr *csv.ParseError
+    switch {
+    case errors.As(err, &parseErr), errors.As(err, &csvErr):
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+    case errors.Is(err, ErrInvalidTitle), errors.Is(err, ErrTitleCharacters), errors.Is(err, ErrNoRoles), errors.Is(err, ErrInvalidVisibility),
+        errors.Is(err, ErrNoSpeeches), errors.Is(err, ErrSpeechFormat), errors.Is(err, linefile.ErrUnknownFormat),
+        errors.Is(err, ErrNoCards), errors.Is(err, ErrInvalidColumns):
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+    case errors.Is(err, ErrDuplicateTitle):
+        writeJSONError(w, http.StatusConflict, err.Error())
+    case errors.Is(err, ErrUndoExpired):
+        writeJSONError(w, http.StatusNotFound, err.Error())
+    default:
+        writeDatabaseError(w, err)
+    }
+}
+
+func apiListLineSets(w http.ResponseWriter, r *http.Request) {
+    userId, ok := apiUser(w, r)
+    if !ok {
+        return
+    }
+    sets, err := GetLineSets(userId)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    if sets == nil {
+        sets = []LineSet{}
+    }
+    writeJSON(w, http.StatusOK, sets)
+}
+
+func apiCreateLineSet(w http.ResponseWriter, r *http.Request) {
+    userId, ok := apiUser(w, r)
+    if !ok {
+        return
+    }
+    var payload struct {
+        Title string `json:"title"`
+        Text string `json:"text"`
+        // If given, text is a whole script to pick these roles' lines from
+        Roles []string `json:"roles"`
+        Format string `json:"format"`
+        Alternatives []int `json:"alternatives"`
+        // If set, text is a flashcard deck with cards in these columns,
+        // or in guessed ones if columns is left out
+        Cards bool `json:"cards"`
+        Columns *CardColumns `json:"columns"`
+    }
+    if !decodeJSON(w, r, &payload) {
+        return
+    }
+    var id LineSetId
+    var err error
+    if payload.Cards {
+        id, err = CreateLineSetFromCards(userId, payload.Title, payload.Text, payload.Columns)
+    } else if payload.Roles != nil {
+        script := Script{Text: payload.Text, Format: payload.Format, Alternatives: payload.Alternatives}
+        if script.Format == "" {
+            script.Format = linefile.FormatScene
+        }
+        id, err = CreateLineSetFromScene(userId, payload.Title, script, payload.Roles)
+    } else {
+        id, err = CreateLineSet(userId, payload.Title, payload.Text)
+    }
+    if err != nil {
+        writeLineSetError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusCreated, LineSet{Id: id, Title: strings.TrimSpace(payload.Title), Visibility: VisibilityPrivate})
+}
+
+// Shows how a flashcard deck would be imported, guessing the columns
+// unless they are given.
+func apiPreviewCards(w http.ResponseWriter, r *http.Request) {
+    if _, ok := apiUser(w, r); !ok {
+        return
+    }
+    var payload struct {
+        Text string `json:"text"`
+        Columns *CardColumns `json:"columns"`
+    }
+    if !decodeJSON(w, r, &payload) {
+        return
+    }
+    preview, err := PreviewCards(payload.Text, payload.Columns)
+    if err != nil {
+        writeLineSetError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, preview)
+}
+
+// Lists the roles with speeches in a script, along with the speeches
+// and any lines the importer was unsure about.
+func apiListRoles(w http.ResponseWriter, r *http.Request) {
+    if _, ok := apiUser(w, r); !ok {
+        return
+    }
+    var payload struct {
+        Text string `json:"text"`
+        Format string `json:"format"`
+        Alternatives []int `json:"alternatives"`
+    }
+    if !decodeJSON(w, r, &payload) {
+        return
+    }
+    if payload.Format == "" {
+        payload.Format = linefile.FormatScene
+    }
+    result, format, err := ImportScript(Script{Text: payload.Text, Format: payload.Format, Alternatives: payload.Alternatives})
+    if err != nil {
+        writeLineSetError(w, err)
+        return
+    }
+    roles := linefile.Roles(result.Speeches)
+    if roles == nil {
+        roles = []string{}
+    }
+    if result.Speeches == nil {
+        result.Speeches = []linefile.Speech{}
+    }
+    if result.Ambiguous == nil {
+        result.Ambiguous = []linefile.Ambiguity{}
+    }
+    writeJSON(w, http.StatusOK, struct {
+        Roles []string `json:"roles"`
+        Format string `json:"format"`
+        linefile.ImportResult
+    }{roles, format, result})
+}
+
+func apiGetLineSet(w http.ResponseWriter, r *http.Request) {
+    _, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    lines, err := GetLines(set.Id)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    if lines == nil {
+        lines = []LineData{}
+    }
+    writeJSON(w, http.StatusOK, struct {
+        LineSet
+        Lines []LineData `json:"lines"`
+    }{set, lines})
+}
+
+// Replaces every line of a line set with newly parsed text.
+func apiUpdateLineSet(w http.ResponseWriter, r *http.Request) {
+    userId, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    var payload struct {
+        Text string `json:"text"`
+    }
+    if !decodeJSON(w, r, &payload) {
+        return
+    }
+    lines, err := linefile.ParseString(payload.Text)
+    if err != nil {
+        writeLineSetError(w, err)
+        return
+    }
+    if err := EditLineSet(userId, set, set.Title, lines); err != nil {
+        writeLineSetError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, set)
+}
+
+// Renames a line set or changes its visibility, depending on which
+// fields are present.
+func apiPatchLineSet(w http.ResponseWriter, r *http.Request) {
+    userId, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    var payload struct {
+        Title *string `json:"title"`
+        Visibility *string `json:"visibility"`
+    }
+    if !decodeJSON(w, r, &payload) {
+        return
+    }
+    if payload.Title != nil {
+        if err := RenameLineSet(userId, set.Id, *payload.Title); err != nil {
+            writeLineSetError(w, err)
+            return
+        }
+        set.Title = strings.TrimSpace(*payload.Title)
+    }
+    if payload.Visibility != nil {
+        var err error
+        set, err = ChangeVisibility(userId, set.Id, *payload.Visibility)
+        if err != nil {
+            writeLineSetError(w, err)
+            return
+        }
+    }
+    writeJSON(w, http.StatusOK, set)
+}
+
+func apiDeleteLineSet(w http.ResponseWriter, r *http.Request) {
+    userId, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    if err := DeleteLineSet(userId, set.Id); err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func apiListDeletedLineSets(w http.ResponseWriter, r *http.Request) {
+    userId, ok := apiUser(w, r)
+    if !ok {
+        return
+    }
+    sets, err := RecentlyDeletedLineSets(userId)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    if sets == nil {
+        sets = []DeletedLineSet{}
+    }
+    writeJSON(w, http.StatusOK, sets)
+}
+
+// Undoes a delete. The line set is looked up by hand since
+// apiLineSet only finds line sets that have not been deleted.
+func apiRestoreLineSet(w http.ResponseWriter, r *http.Request) {
+    userId, ok := apiUser(w, r)
+    if !ok {
+        return
+    }
+    id, err := strconv.Atoi(r.PathValue("set"))
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, "Invalid line set id")
+        return
+    }
+    set, err := UndoDeleteLineSet(userId, LineSetId(id))
+    if err != nil {
+        writeLineSetError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, set)
+}
+
+func apiDuplicateLineSet(w http.ResponseWriter, r *http.Request) {
+    userId, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    duplicate, err := DuplicateLineSet(userId, set.Id)
+    if err != nil {
+        writeLineSetError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusCreated, duplicate)
+}
+
+func apiListRevisions(w http.ResponseWriter, r *http.Request) {
+    _, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    revisions, err := GetRevisions(set.Id)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    if revisions == nil {
+        revisions = []Revision{}
+    }
+    writeJSON(w, http.StatusOK, revisions)
+}
+
+// Returns a revision with its text and the changes from the revision
+// before it.
+func apiGetRevision(w http.ResponseWriter, r *http.Request) {
+    _, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    revision, err := strconv.Atoi(r.PathValue("revision"))
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, "Invalid revision id")
+        return
+    }
+    diff, err := GetRevisionDiff(set.Id, RevisionId(revision))
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, diff)
+}
+
+func apiRestoreRevision(w http.ResponseWriter, r *http.Request) {
+    userId, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    revision, err := strconv.Atoi(r.PathValue("revision"))
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, "Invalid revision id")
+        return
+    }
+    if err := RestoreRevision(userId, set.Id, RevisionId(revision)); err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    lines, err := GetLines(set.Id)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    if lines == nil {
+        lines = []LineData{}
+    }
+    writeJSON(w, http.StatusOK, lines)
+}
+
+// Reads the scenes to restrict a listing to from the act and scene
+// query parameters.
+func apiSceneFilter(w http.ResponseWriter, r *http.Request) ([]SceneRef, bool) {
+    query := r.URL.Query()
+    scenes, err := parseSceneRefs(query["act"], query["scene"])
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+        return nil, false
+    }
+    return scenes, true
+}
+
+// Reads the filter query parameter described in filter.go.
+func apiLineFilter(w http.ResponseWriter, r *http.Request) (LineFilter, bool) {
+    filter, err := ParseLineFilter(r.URL.Query().Get("filter"))
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+        return LineFilter{}, false
+    }
+    return filter, true
+}
+
+func apiListLines(w http.ResponseWriter, r *http.Request) {
+    _, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    scenes, ok := apiSceneFilter(w, r)
+    if !ok {
+        return
+    }
+    filter, ok := apiLineFilter(w, r)
+    if !ok {
+        return
+    }
+    lines, err := GetLines(set.Id)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    lines = filterScenes(lines, scenes)
+    lines, err = FilterLines(set.Id, lines, filter)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    if lines == nil {
+        lines = []LineData{}
+    }
+    writeJSON(w, http.StatusOK, lines)
+}
+
+// Exports a line set in the format given by ?format=, text by default.
+// The response is the export itself rather than JSON.
+func apiExportLineSet(w http.ResponseWriter, r *http.Request) {
+    _, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    format := r.FormValue("format")
+    if format == "" {
+        format = "text"
+    }
+    if _, exists := exportFormats[format]; !exists {
+        writeJSONError(w, http.StatusBadRequest, ErrUnknownExportFormat.Error())
+        return
+    }
+    lines, err := GetLines(set.Id)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    writeExport(w, format, set.Title, lines, exportOptions(r))
+}
+
+// Returns the lines of a line set grouped by act and scene.
+func apiLineSetOutline(w http.ResponseWriter, r *http.Request) {
+    _, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    lines, err := GetLines(set.Id)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    acts := linefile.Outline(lines)
+    if acts == nil {
+        acts = []linefile.Act{}
+    }
+    writeJSON(w, http.StatusOK, acts)
+}
+
+// Plays, replaces or removes the audio recorded for a line. A clip is
+// uploaded as the raw request body with its Content-Type, and played
+// back as it was uploaded rather than as JSON.
+func apiLineAudio(w http.ResponseWriter, r *http.Request) {
+    _, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    lineNumber, err := strconv.Atoi(r.PathValue("line"))
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, "Invalid line number")
+        return
+    }
+    kind := r.PathValue("kind")
+    switch r.Method {
+    case http.MethodGet:
+        err = serveLineAudio(w, r, set.Id, lineNumber, kind)
+    case http.MethodPut:
+        var audio LineAudio
+        audio, err = SaveLineAudio(set.Id, lineNumber, kind, r.Header.Get("Content-Type"), r.Body)
+        if err == nil {
+            writeJSON(w, http.StatusOK, audio)
+        }
+    case http.MethodDelete:
+        err = RemoveLineAudio(set.Id, lineNumber, kind)
+        if err == nil {
+            w.WriteHeader(http.StatusNoContent)
+        }
+    }
+    switch {
+    case err == nil:
+    case errors.Is(err, ErrNoAudio):
+        writeJSONError(w, http.StatusNotFound, err.Error())
+    case errors.Is(err, ErrInvalidAudioKind), errors.Is(err, ErrUnsupportedAudio):
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+    case errors.Is(err, ErrAudioTooLarge):
+        writeJSONError(w, http.StatusRequestEntityTooLarge, err.Error())
+    default:
+        writeDatabaseError(w, err)
+    }
+}
+
+// Plays a cue or line as recorded, or read aloud if there is no
+// recording.
+func apiLineSpeech(w http.ResponseWriter, r *http.Request) {
+    _, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    lineNumber, err := strconv.Atoi(r.PathValue("line"))
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, "Invalid line number")
+        return
+    }
+    err = serveLineSpeech(w, r, set.Id, lineNumber, r.PathValue("part"))
+    switch {
+    case err == nil:
+    case errors.Is(err, ErrNoAudio):
+        writeJSONError(w, http.StatusNotFound, err.Error())
+    case errors.Is(err, ErrNoSynthesizer):
+        writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+    case errors.Is(err, ErrInvalidAudioKind):
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+    default:
+        writeDatabaseError(w, err)
+    }
+}
+
+// Builds the hands-free rehearsal playlist for the lines picked by the
+// act, scene and filter parameters, paced by self_check and pace.
+func apiRehearsal(w http.ResponseWriter, r *http.Request) {
+    _, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    scenes, ok := apiSceneFilter(w, r)
+    if !ok {
+        return
+    }
+    filter, ok := apiLineFilter(w, r)
+    if !ok {
+        return
+    }
+    query := r.URL.Query()
+    options, err := parseRehearsalOptions(query.Get("self_check"), query.Get("pace"))
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    lines, err := GetLines(set.Id)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    lines = filterScenes(lines, scenes)
+    lines, err = FilterLines(set.Id, lines, filter)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, NewRehearsal(lines, options))
+}
+
+// Plays a pause of a rehearsal playlist, ?ms= long.
+func apiSilence(w http.ResponseWriter, r *http.Request) {
+    if _, ok := apiUser(w, r); !ok {
+        return
+    }
+    if err := serveSilence(w, r); err != nil {
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+    }
+}
+
+// Lists the roles in a line set with the voices they are read in.
+func apiListVoices(w http.ResponseWriter, r *http.Request) {
+    _, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    voices, err := GetVoices(set.Id)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, voices)
+}
+
+func apiSetVoices(w http.ResponseWriter, r *http.Request) {
+    _, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    var voices []RoleVoice
+    if !decodeJSON(w, r, &voices) {
+        return
+    }
+    if err := SetVoices(set.Id, voices); errors.Is(err, ErrInvalidVoice) {
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+        return
+    } else if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    apiListVoices(w, r)
+}
+
+// Updates only the fields present in the request body.
+func apiPatchLine(w http.ResponseWriter, r *http.Request) {
+    userId, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    lineNumber, err := strconv.Atoi(r.PathValue("line"))
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, "Invalid line number")
+        return
+    }
+    var payload struct {
+        Starred *bool `json:"starred"`
+        Notes *string `json:"notes"`
+        Cue *string `json:"cue"`
+        Line *string `json:"line"`
+        Act *string `json:"act"`
+        Scene *string `json:"scene"`
+        Blocking *string `json:"blocking"`
+        Directions *string `json:"directions"`
+        Page *int `json:"page"`
+        Tags *[]string `json:"tags"`
+        Pronunciation *string `json:"pronunciation"`
+        Custom *map[string]string `json:"custom"`
+    }
+    if !decodeJSON(w, r, &payload) {
+        return
+    }
+
+    line, err := GetLine(set.Id, lineNumber)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    original := line
+    if payload.Starred != nil {
+        line.Starred = *payload.Starred
+    }
+    if payload.Notes != nil {
+        line.Notes = *payload.Notes
+    }
+    if payload.Cue != nil {
+        line.Cue = *payload.Cue
+    }
+    if payload.Line != nil {
+        line.Line = *payload.Line
+    }
+    if payload.Act != nil {
+        line.Act = strings.TrimSpace(*payload.Act)
+    }
+    if payload.Scene != nil {
+        line.Scene = strings.TrimSpace(*payload.Scene)
+    }
+    if payload.Blocking != nil {
+        line.Blocking = *payload.Blocking
+    }
+    if payload.Directions != nil {
+        line.Directions = *payload.Directions
+    }
+    if payload.Page != nil {
+        line.Page = *payload.Page
+    }
+    if payload.Tags != nil {
+        line.Tags = *payload.Tags
+    }
+    if payload.Pronunciation != nil {
+        line.Pronunciation = *payload.Pronunciation
+    }
+    if payload.Custom != nil {
+        line.Custom = *payload.Custom
+    }
+    if err := linefile.CheckMetadata(line.Metadata); err != nil {
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    if !isSingleLine(line.Cue) || !isSingleLine(line.Line) {
+        writeJSONError(w, http.StatusBadRequest, "Cue and line should have format `ROLE: the line`")
+        return
+    }
+    if strings.ContainsAny(line.Act + line.Scene, "\r\n") {
+        writeJSONError(w, http.StatusBadRequest, "Act and scene should be a single line")
+        return
+    }
+
+    // Stars, notes and tags are not part of the text, so changing only
+    // them makes no revision and leaves production copies alone
+    textChanged := !sameScriptText(original, line)
+    if textChanged {
+        err = withRevision(userId, set.Id, 0, func() error {
+            return UpdateLine(set.Id, line)
+        })
+    } else {
+        err = UpdateLine(set.Id, line)
+    }
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    if payload.Tags != nil {
+        if _, err := SetLineTags(set.Id, lineNumber, line.Tags); err != nil {
+            writeDatabaseError(w, err)
+            return
+        }
+    }
+    // Copies only take the script's text, so their stars, notes and
+    // tags are kept
+    if textChanged {
+        if err := SyncScriptCopies(userId, set.Id); err != nil {
+            writeDatabaseError(w, err)
+            return
+        }
+    }
+    writeJSON(w, http.StatusOK, line)
+}
+
+// Records how well a line was recalled and returns its new schedule.
+func apiGradeLine(w http.ResponseWriter, r *http.Request) {
+    _, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    lineNumber, err := strconv.Atoi(r.PathValue("line"))
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, "Invalid line number")
+        return
+    }
+    var payload struct {
+        Grade string `json:"grade"`
+    }
+    if !decodeJSON(w, r, &payload) {
+        return
+    }
+    grade, err := ParseGrade(payload.Grade)
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    state, err := GradeLine(set.Id, lineNumber, grade)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, state)
+}
+
+// Logs an attempt at recalling a line. If a grade is given, the line
+// is also rescheduled.
+func apiRecordAttempt(w http.ResponseWriter, r *http.Request) {
+    userId, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    lineNumber, err := strconv.Atoi(r.PathValue("line"))
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, "Invalid line number")
+        return
+    }
+    var payload struct {
+        ReviewMethod string `json:"review_method"`
+        Grade string `json:"grade"`
+        RevealMs int `json:"reveal_ms"`
+        HintsUsed int `json:"hints_used"`
+    }
+    if !decodeJSON(w, r, &payload) {
+        return
+    }
+    attempt := Attempt{
+        Line: lineNumber,
+        ReviewMethod: payload.ReviewMethod,
+        RevealMs: payload.RevealMs,
+        HintsUsed: payload.HintsUsed,
+    }
+    if payload.Grade != "" {
+        attempt.Grade, err = ParseGrade(payload.Grade)
+        if err != nil {
+            writeJSONError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+        attempt.Graded = true
+    }
+    state, err := RecordReview(userId, set.Id, attempt)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusCreated, struct {
+        Schedule *ReviewState `json:"schedule"`
+    }{state})
+}
+
+// Scores a typed answer and records it as a graded attempt.
+func apiCheckLine(w http.ResponseWriter, r *http.Request) {
+    userId, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    lineNumber, err := strconv.Atoi(r.PathValue("line"))
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, "Invalid line number")
+        return
+    }
+    var payload struct {
+        Answer string `json:"answer"`
+        ReviewMethod string `json:"review_method"`
+        RevealMs int `json:"reveal_ms"`
+        HintsUsed int `json:"hints_used"`
+    }
+    r.Body = http.MaxBytesReader(w, r.Body, maxAnswerBytes)
+    if !decodeJSON(w, r, &payload) {
+        return
+    }
+    score, state, err := CheckAnswer(userId, set.Id, lineNumber, payload.Answer, payload.ReviewMethod, payload.RevealMs, payload.HintsUsed)
+    if errors.Is(err, ErrAnswerTooLong) {
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+        return
+    } else if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, struct {
+        AnswerScore
+        Schedule *ReviewState `json:"schedule"`
+    }{score, state})
+}
+
+// Lists the hint ladder for a line, weakest first.
+func apiLineHints(w http.ResponseWriter, r *http.Request) {
+    _, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    lineNumber, err := strconv.Atoi(r.PathValue("line"))
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, "Invalid line number")
+        return
+    }
+    line, err := GetLine(set.Id, lineNumber)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, Hints(line))
+}
+
+func apiLineSetStats(w http.ResponseWriter, r *http.Request) {
+    userId, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    stats, err := GetLineSetStats(userId, set)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, stats)
+}
+
+func apiListDueLines(w http.ResponseWriter, r *http.Request) {
+    _, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    scenes, ok := apiSceneFilter(w, r)
+    if !ok {
+        return
+    }
+    filter, ok := apiLineFilter(w, r)
+    if !ok {
+        return
+    }
+    lines, err := GetDueLines(set.Id)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    lines = filterScenes(lines, scenes)
+    lines, err = FilterLines(set.Id, lines, filter)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    if lines == nil {
+        lines = []LineData{}
+    }
+    writeJSON(w, http.StatusOK, lines)
+}
+
+// Breaks a line into chunks to drill. The by query parameter is either
+// "phrase" (the default) or "sentence".
+func apiMonologueDrill(w http.ResponseWriter, r *http.Request) {
+    _, set, ok := apiLineSet(w, r)
+    if !ok {
+        return
+    }
+    lineNumber, err := strconv.Atoi(r.PathValue("line"))
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, "Invalid line number")
+        return
+    }
+    by := r.URL.Query().Get("by")
+    if by == "" {
+        by = ChunkByPhrase
+    } else if by != ChunkByPhrase && by != ChunkBySentence {
+        writeJSONError(w, http.StatusBadRequest, "by should be phrase or sentence")
+        return
+    }
+    line, err := GetLine(set.Id, lineNumber)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, NewMonologueDrill(line, by))
+}
+
+// Searches public line sets by title or owner with the q parameter.
+func apiSearchPublic(w http.ResponseWriter, r *http.Request) {
+    if _, ok := apiUser(w, r); !ok {
+        return
+    }
+    sets, err := SearchPublicLineSets(strings.TrimSpace(r.URL.Query().Get("q")))
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    if sets == nil {
+        sets = []SharedLineSet{}
+    }
+    writeJSON(w, http.StatusOK, sets)
+}
+
+// Writes a line set that the user may not own along with its lines.
+func writeSharedLineSet(w http.ResponseWriter, userId UserId, set SharedLineSet, shareToken string) {
+    lines, err := GetVisibleLines(userId, set.Id, shareToken)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    if lines == nil {
+        lines = []LineData{}
+    }
+    writeJSON(w, http.StatusOK, struct {
+        SharedLineSet
+        Lines []LineData `json:"lines"`
+    }{set, lines})
+}
+
+// Returns a line set that is public, or unlisted if the token query
+// parameter is its share token.
+func apiGetPublic(w http.ResponseWriter, r *http.Request) {
+    userId, ok := apiUser(w, r)
+    if !ok {
+        return
+    }
+    id, err := strconv.Atoi(r.PathValue("set"))
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, "Invalid line set id")
+        return
+    }
+    token := r.URL.Query().Get("token")
+    set, err := GetVisibleLineSet(userId, LineSetId(id), token)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    writeSharedLineSet(w, userId, set, token)
+}
+
+func apiGetShared(w http.ResponseWriter, r *http.Request) {
+    userId, ok := apiUser(w, r)
+    if !ok {
+        return
+    }
+    token := r.PathValue("token")
+    set, err := GetLineSetByShareToken(token)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    writeSharedLineSet(w, userId, set, token)
+}
+
+// Copies a line set into the user's library as a new private line set.
+func apiCopyLineSet(w http.ResponseWriter, r *http.Request) {
+    userId, ok := apiUser(w, r)
+    if !ok {
+        return
+    }
+    id, err := strconv.Atoi(r.PathValue("set"))
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, "Invalid line set id")
+        return
+    }
+    var payload struct {
+        ShareToken string `json:"share_token"`
+    }
+    if r.ContentLength != 0 && !decodeJSON(w, r, &payload) {
+        return
+    }
+    set, err := CopyToLibrary(userId, LineSetId(id), payload.ShareToken)
+    if err != nil {
+        writeLineSetError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusCreated, set)
+}
+
+func isSingleLine(s string) bool {
+    return linefile.HasLineFormat(s) && !strings.ContainsAny(s, "\r\n")
+}
+
+/******************************
+ ******** Productions *********
+ ******************************/
+
+func writeProductionError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, ErrInvalidProductionName), errors.Is(err, ErrInvalidRole):
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+    case errors.Is(err, ErrNotDirector):
+        writeJSONError(w, http.StatusForbidden, err.Error())
+    case errors.Is(err, ErrNoSuchUser):
+        writeJSONError(w, http.StatusNotFound, err.Error())
+    case errors.Is(err, ErrAlreadyMember), errors.Is(err, ErrDuplicateScript):
+        writeJSONError(w, http.StatusConflict, err.Error())
+    default:
+        writeLineSetError(w, err)
+    }
+}
+
+// Reads the {production} path parameter.
+func apiProductionId(w http.ResponseWriter, r *http.Request) (UserId, ProductionId, bool) {
+    userId, ok := apiUser(w, r)
+    if !ok {
+        return -1, 0, false
+    }
+    id, err := strconv.Atoi(r.PathValue("production"))
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, "Invalid production id")
+        return -1, 0, false
+    }
+    return userId, ProductionId(id), true
+}
+
+func apiListProductions(w http.ResponseWriter, r *http.Request) {
+    userId, ok := apiUser(w, r)
+    if !ok {
+        return
+    }
+    productions, err := GetProductions(userId)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    if productions == nil {
+        productions = []Production{}
+    }
+    writeJSON(w, http.StatusOK, productions)
+}
+
+func apiCreateProduction(w http.ResponseWriter, r *http.Request) {
+    userId, ok := apiUser(w, r)
+    if !ok {
+        return
+    }
+    var payload struct {
+        Name string `json:"name"`
+    }
+    if !decodeJSON(w, r, &payload) {
+        return
+    }
+    id, err := CreateProduction(userId, payload.Name)
+    if err != nil {
+        writeProductionError(w, err)
+        return
+    }
+    production, err := GetProduction(userId, id)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    writeJSON(w, http.StatusCreated, production)
+}
+
+// Returns a production with its members and scripts.
+func apiGetProduction(w http.ResponseWriter, r *http.Request) {
+    userId, id, ok := apiProductionId(w, r)
+    if !ok {
+        return
+    }
+    production, err := GetProduction(userId, id)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    members, err := GetProductionMembers(id)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    scripts, err := GetProductionScripts(id)
+    if err != nil {
+        writeDatabaseError(w, err)
+        return
+    }
+    if scripts == nil {
+        scripts = []LineSet{}
+    }
+    writeJSON(w, http.StatusOK, struct {
+        Production
+        Members []ProductionMember `json:"members"`
+        Scripts []LineSet `json:"scripts"`
+    }{production, members, scripts})
+}
+
+func apiInviteMember(w http.ResponseWriter, r *http.Request) {
+    userId, id, ok := apiProductionId(w, r)
+    if !ok {
+        return
+    }
+    var payload struct {
+        Username string `json:"username"`
+        Role string `json:"role"`
+    }
+    if !decodeJSON(w, r, &payload) {
+        return
+    }
+    if err := InviteToProduction(userId, id, payload.Username, payload.Role); err != nil {
+        writeProductionError(w, err)
+        return
+    }
+    w.WriteHeader(http.StatusCreated)
+}
+
+// Accepts or declines an invitation to a production. Declining after
+// accepting leaves the production.
+func apiRespondToInvitation(w http.ResponseWriter, r *http.Request) {
+    userId, id, ok := apiProductionId(w, r)
+    if !ok {
+        return
+    }
+    var payload struct {
+        Accept bool `json:"accept"`
+    }
+    if !decodeJSON(w, r, &payload) {
+        return
+    }
+    if err := RespondToInvitation(userId, id, payload.Accept); err != nil {
+        writeProductionError(w, err)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func apiAddScript(w http.ResponseWriter, r *http.Request) {
+    userId, id, ok := apiProductionId(w, r)
+    if !ok {
+        return
+    }
+    var payload struct {
+        LineSet LineSetId `json:"line_set_id"`
+    }
+    if !decodeJSON(w, r, &payload) {
+        return
+    }
+    if err := AddScriptToProduction(userId, id, payload.LineSet); err != nil {
+        writeProductionError(w, err)
+        return
+    }
+    w.WriteHeader(http.StatusCreated)
+}
+
+func apiProductionProgress(w http.ResponseWriter, r *http.Request) {
+    userId, id, ok := apiProductionId(w, r)
+    if !ok {
+        return
+    }
+    progress, err := GetProductionProgress(userId, id)
+    if err != nil {
+        writeProductionError(w, err)
+        return
+    }
+    if progress == nil {
+        progress = []ScriptProgress{}
+    }
+    writeJSON(w, http.StatusOK, progress)
+}
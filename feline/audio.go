@@ -0,0 +1,264 @@
+package feline
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "io"
+    "mime"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// Each line can have two audio clips: the actor's own delivery of the
+// line, and the cue as read by a scene partner. The clips themselves
+// are kept in an AudioStore, and the database only records which lines
+// have them.
+
+const (
+    AudioCue = "cue"
+    AudioLine = "line"
+)
+
+// The largest clip that can be uploaded, in bytes.
+const MaxAudioSize = 10 << 20
+
+var (
+    ErrInvalidAudioKind = errors.New("Audio is either for the cue or the line.")
+    ErrAudioTooLarge = fmt.Errorf("Audio clips can be at most %d MB.", MaxAudioSize >> 20)
+    ErrUnsupportedAudio = errors.New("Please upload MP3, WAV, Ogg, WebM or MP4 audio.")
+    ErrNoAudio = errors.New("No audio has been recorded for this line.")
+)
+
+// AudioStore keeps audio clips under keys made of letters, digits and
+// '-'.
+type AudioStore interface {
+    Save(key string, data []byte) error
+    Open(key string) (io.ReadSeekCloser, error)
+    Delete(key string) error
+    // Lists every key in the store.
+    Keys() ([]string, error)
+}
+
+// DiskAudioStore keeps each clip in a file named after its key.
+type DiskAudioStore struct {
+    Dir string
+}
+
+// Files being written, which are not clips yet.
+const partialAudioPrefix = ".partial-"
+
+func (store DiskAudioStore) path(key string) (string, error) {
+    if key == "" || strings.ContainsAny(key, `/\.`) {
+        return "", fmt.Errorf("invalid audio key %q", key)
+    }
+    return filepath.Join(store.Dir, key), nil
+}
+
+// Writes the clip to a temporary file first so that a clip being
+// replaced is never served half written.
+func (store DiskAudioStore) Save(key string, data []byte) error {
+    path, err := store.path(key)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(store.Dir, 0750); err != nil {
+        return err
+    }
+    file, err := os.CreateTemp(store.Dir, partialAudioPrefix + key)
+    if err != nil {
+        return err
+    }
+    defer os.Remove(file.Name())
+    if _, err := file.Write(data); err != nil {
+        file.Close()
+        return err
+    }
+    if err := file.Close(); err != nil {
+        return err
+    }
+    return os.Rename(file.Name(), path)
+}
+
+func (store DiskAudioStore) Open(key string) (io.ReadSeekCloser, error) {
+    path, err := store.path(key)
+    if err != nil {
+        return nil, err
+    }
+    return os.Open(path)
+}
+
+func (store DiskAudioStore) Delete(key string) error {
+    path, err := store.path(key)
+    if err != nil {
+        return err
+    }
+    err = os.Remove(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    return err
+}
+
+func (store DiskAudioStore) Keys() ([]string, error) {
+    entries, err := os.ReadDir(store.Dir)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    var keys []string
+    for _, entry := range entries {
+        if !entry.IsDir() && !strings.HasPrefix(entry.Name(), partialAudioPrefix) {
+            keys = append(keys, entry.Name())
+        }
+    }
+    return keys, nil
+}
+
+// Where audio clips are kept. Set from the -audio flag.
+var AudioStorage AudioStore = DiskAudioStore{Dir: "audio"}
+
+// The types clips can be uploaded as, and what http.DetectContentType
+// makes of each. It does not recognize every MP3 or M4A file, so those
+// may also come out as application/octet-stream.
+var audioTypes = map[string][]string{
+    "audio/mpeg": {"audio/mpeg", "application/octet-stream"},
+    "audio/wav": {"audio/wave"},
+    "audio/x-wav": {"audio/wave"},
+    "audio/ogg": {"application/ogg"},
+    "audio/webm": {"video/webm"},
+    "audio/mp4": {"video/mp4", "application/octet-stream"},
+    "audio/x-m4a": {"video/mp4", "application/octet-stream"},
+}
+
+// Checks the clip is of the type it claims to be, one that browsers can
+// play. Returns the type without parameters such as codecs.
+func checkAudioType(contentType string, data []byte) (string, error) {
+    mediaType, _, err := mime.ParseMediaType(contentType)
+    if err != nil {
+        return "", ErrUnsupportedAudio
+    }
+    sniffed := http.DetectContentType(data)
+    for _, allowed := range audioTypes[mediaType] {
+        if sniffed == allowed {
+            return mediaType, nil
+        }
+    }
+    return "", ErrUnsupportedAudio
+}
+
+func audioKey(lineId int, kind string) string {
+    return fmt.Sprintf("%d-%s", lineId, kind)
+}
+
+func checkAudioKind(kind string) error {
+    if kind != AudioCue && kind != AudioLine {
+        return ErrInvalidAudioKind
+    }
+    return nil
+}
+
+// Stores a clip for a line, replacing the one before it if any.
+func SaveLineAudio(set LineSetId, lineNumber int, kind string, contentType string, r io.Reader) (LineAudio, error) {
+    if err := checkAudioKind(kind); err != nil {
+        return LineAudio{}, err
+    }
+    data, err := io.ReadAll(io.LimitReader(r, MaxAudioSize + 1))
+    if err != nil {
+        return LineAudio{}, err
+    }
+    if len(data) > MaxAudioSize {
+        return LineAudio{}, ErrAudioTooLarge
+    }
+    contentType, err = checkAudioType(contentType, data)
+    if err != nil {
+        return LineAudio{}, err
+    }
+    // Recorded before the clip is saved, so that removeOrphanedAudio
+    // never sees a clip without its row
+    audio, err := SetLineAudio(set, lineNumber, LineAudio{
+        Kind: kind,
+        ContentType: contentType,
+        Size: len(data),
+        UploadedAt: time.Now().UTC(),
+    })
+    if err != nil {
+        return audio, err
+    }
+    if err := AudioStorage.Save(audioKey(audio.LineId, kind), data); err != nil {
+        DeleteLineAudio(audio)
+        return audio, err
+    }
+    return audio, nil
+}
+
+func RemoveLineAudio(set LineSetId, lineNumber int, kind string) error {
+    if err := checkAudioKind(kind); err != nil {
+        return err
+    }
+    audio, err := GetLineAudio(set, lineNumber, kind)
+    if err == sql.ErrNoRows {
+        return ErrNoAudio
+    } else if err != nil {
+        return err
+    }
+    if err := DeleteLineAudio(audio); err != nil {
+        return err
+    }
+    return AudioStorage.Delete(audioKey(audio.LineId, kind))
+}
+
+// Responds with a line's clip, supporting range requests so browsers
+// can seek. Returns ErrNoAudio if there is none.
+func serveLineAudio(w http.ResponseWriter, r *http.Request, set LineSetId, lineNumber int, kind string) error {
+    if err := checkAudioKind(kind); err != nil {
+        return err
+    }
+    audio, err := GetLineAudio(set, lineNumber, kind)
+    if err == sql.ErrNoRows {
+        return ErrNoAudio
+    } else if err != nil {
+        return err
+    }
+    clip, err := AudioStorage.Open(audioKey(audio.LineId, kind))
+    if errors.Is(err, os.ErrNotExist) {
+        return ErrNoAudio
+    } else if err != nil {
+        return err
+    }
+    defer clip.Close()
+    w.Header().Set("Content-Type", audio.ContentType)
+    w.Header().Set("Cache-Control", "private, no-cache")
+    http.ServeContent(w, r, "", audio.UploadedAt, clip)
+    return nil
+}
+
+// Removes clips whose lines have been deleted. The keys are listed
+// before the rows are read, so a clip being saved meanwhile already has
+// its row and is kept.
+func removeOrphanedAudio() error {
+    keys, err := AudioStorage.Keys()
+    if err != nil {
+        return err
+    }
+    clips, err := GetAllLineAudio()
+    if err != nil {
+        return err
+    }
+    kept := map[string]bool{}
+    for _, audio := range clips {
+        kept[audioKey(audio.LineId, audio.Kind)] = true
+    }
+    for _, key := range keys {
+        if !kept[key] {
+            if err := AudioStorage.Delete(key); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
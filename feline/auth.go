@@ -3,14 +3,11 @@ package feline
 import (
 	"crypto/rand"
 	"encoding/base32"
-    "errors"
     "log"
     "net/http"
     "golang.org/x/crypto/bcrypt"
 )
 
-var loginSessions = map[SessionToken] UserId {}
-
 func HashPassword(password string) ([]byte, error) {
     return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 }
@@ -24,18 +21,37 @@ func ActiveSession(w http.ResponseWriter, r *http.Request) (*Session, error) {
     if err != nil {
         return nil, err
     }
-    return lynxSessions[userId], nil
+    return lynxSessions.get(userId)
 }
 
-func Login(w http.ResponseWriter, user *User) {
+func Login(w http.ResponseWriter, user *User) error {
     debug.Println("[auth] Login: Creating session token cookie")
-    token := generateSessionToken()
+    token, err := sessionStore.Create(user.Id)
+    if err != nil {
+        return err
+    }
     http.SetCookie(w, &http.Cookie{
         Name: "session_token",
         Value: string(token),
         Path: "/",
+        MaxAge: int(sessionMaxAge.Seconds()),
+        HttpOnly: true,
+        SameSite: http.SameSiteLaxMode,
     })
-    loginSessions[SessionToken(token)] = user.Id
+    return nil
+}
+
+func Logout(w http.ResponseWriter, r *http.Request) error {
+    cookie, err := r.Cookie("session_token")
+    if err != nil {
+        return nil
+    }
+    http.SetCookie(w, &http.Cookie{
+        Name: "session_token",
+        Path: "/",
+        MaxAge: -1,
+    })
+    return sessionStore.Delete(SessionToken(cookie.Value))
 }
 
 func CheckAuth(_ http.ResponseWriter, r *http.Request) (UserId, error) {
@@ -50,10 +66,10 @@ func CheckAuth(_ http.ResponseWriter, r *http.Request) (UserId, error) {
 
     token := SessionToken(cookie.Value)
 
-    userId, is_authenticated := loginSessions[token]
-    if !is_authenticated {
+    userId, err := sessionStore.Lookup(token)
+    if err != nil {
         debug.Println("Invalid session_token cookie. Redirecting to /login")
-        return -1, errors.New("Invalid token")
+        return -1, err
     }
 
     return userId, nil
@@ -63,7 +79,6 @@ func generateSessionToken() SessionToken {
     randomBytes := make([]byte, 16)
     rand.Read(randomBytes)
     token := base32.StdEncoding.EncodeToString(randomBytes)
-    log.Printf("generateSessionToken: %s", token)
     return SessionToken(token)
 }
 
@@ -0,0 +1,398 @@
+package feline
+
+import (
+    "encoding/csv"
+    "errors"
+    "fmt"
+    "html"
+    "io"
+    "strconv"
+    "strings"
+
+    "github.com/ruuzia/lynx/linefile"
+)
+
+// Flashcard decks, as exported by Anki or kept in a spreadsheet, can be
+// turned into line sets and back. A deck is a CSV or TSV file with a
+// card per row. Anki writes a few "#key:value" lines at the top saying
+// how the file is laid out, which are used when present. Otherwise the
+// columns are guessed from a header row or taken to be front then back,
+// and the user can correct the guess before importing.
+
+var (
+    ErrNoCards = errors.New("No cards were found in the file.")
+    ErrInvalidColumns = errors.New("Please pick different columns for the cue and the line.")
+)
+
+// The tag cards get when their line is starred.
+const starredTag = "starred"
+
+// Line files need every cue and line to start with a role, so card text
+// without one is given these.
+const (
+    cardCueRole = "CUE"
+    cardLineRole = "LINE"
+)
+
+// Gives card text a role unless it already has one, as decks written
+// by writeAnkiDeck do.
+func withCardRole(text string, role string) string {
+    if linefile.HasLineFormat(text) {
+        return text
+    }
+    if text == "" {
+        return role + ":"
+    }
+    return role + ": " + text
+}
+
+// CardColumns says which column of a deck holds what, counting from 0,
+// or -1 for none.
+type CardColumns struct {
+    // Whether the first row names the columns rather than being a card
+    Header bool `json:"header"`
+    Cue int `json:"cue"`
+    Line int `json:"line"`
+    Notes int `json:"notes"`
+    Tags int `json:"tags"`
+}
+
+type CardTable struct {
+    Rows [][]string
+    // The layout given by Anki's headers, if any
+    separator rune
+    names []string
+    tagsColumn int
+    // Columns Anki adds for its own use, like the deck and note type
+    reserved map[int]bool
+}
+
+// Reads a CSV or TSV deck, working out the separator if the file does
+// not say.
+func ReadCards(text string) (CardTable, error) {
+    table := CardTable{tagsColumn: -1, reserved: map[int]bool{}}
+    lines := strings.SplitAfter(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+    n := 0
+    for ; n < len(lines) && strings.HasPrefix(lines[n], "#"); n++ {
+        key, value, found := strings.Cut(strings.TrimSpace(lines[n][1:]), ":")
+        if !found {
+            continue
+        }
+        switch strings.ToLower(key) {
+        case "separator":
+            table.separator = cardSeparator(value)
+        case "columns":
+            // Read once the separator is known
+            table.names = []string{value}
+        case "tags column", "guid column", "notetype column", "deck column":
+            column, err := strconv.Atoi(strings.TrimSpace(value))
+            if err != nil || column < 1 {
+                continue
+            }
+            table.reserved[column - 1] = true
+            if strings.ToLower(key) == "tags column" {
+                table.tagsColumn = column - 1
+            }
+        }
+    }
+    body := strings.Join(lines[n:], "")
+    if table.separator == 0 {
+        table.separator = guessSeparator(body)
+    }
+    if table.names != nil {
+        table.names = strings.Split(table.names[0], string(table.separator))
+    }
+
+    reader := csv.NewReader(strings.NewReader(body))
+    reader.Comma = table.separator
+    reader.LazyQuotes = true
+    reader.FieldsPerRecord = -1
+    for {
+        record, err := reader.Read()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            return CardTable{}, err
+        }
+        if strings.TrimSpace(strings.Join(record, "")) != "" {
+            table.Rows = append(table.Rows, record)
+        }
+    }
+    if len(table.Rows) == 0 {
+        return CardTable{}, ErrNoCards
+    }
+    return table, nil
+}
+
+// Reads the value of Anki's "#separator:" header.
+func cardSeparator(value string) rune {
+    switch strings.ToLower(strings.TrimSpace(value)) {
+    case "tab":
+        return '\t'
+    case "comma":
+        return ','
+    case "semicolon":
+        return ';'
+    case "pipe":
+        return '|'
+    case "space":
+        return ' '
+    case "colon":
+        return ':'
+    }
+    if runes := []rune(value); len(runes) == 1 {
+        return runes[0]
+    }
+    return 0
+}
+
+// Picks whichever of tab, semicolon and comma the first row has most of.
+func guessSeparator(text string) rune {
+    first, _, _ := strings.Cut(text, "\n")
+    best, count := ',', strings.Count(first, ",")
+    for _, separator := range []rune{'\t', ';'} {
+        if n := strings.Count(first, string(separator)); n > count {
+            best, count = separator, n
+        }
+    }
+    return best
+}
+
+// The number of columns in the widest row.
+func (table CardTable) Width() int {
+    width := 0
+    for _, row := range table.Rows {
+        width = max(width, len(row))
+    }
+    return width
+}
+
+// Guesses which columns hold the cue, line, notes and tags, using the
+// column names from Anki's headers or the first row when there are
+// any, or else the first two columns Anki does not use for itself.
+func (table CardTable) GuessColumns() CardColumns {
+    columns := CardColumns{Cue: -1, Line: -1, Notes: -1, Tags: table.tagsColumn}
+    names := table.names
+    if names == nil && columnNamesIn(table.Rows[0]) {
+        names = table.Rows[0]
+        columns.Header = true
+    }
+    for i, name := range names {
+        switch strings.ToLower(strings.TrimSpace(name)) {
+        case "cue", "front", "question", "prompt":
+            columns.Cue = i
+        case "line", "back", "answer":
+            columns.Line = i
+        case "notes", "note", "extra":
+            columns.Notes = i
+        case "tags":
+            columns.Tags = i
+        }
+    }
+    for i := 0; i < table.Width() && (columns.Cue < 0 || columns.Line < 0); i++ {
+        if table.reserved[i] || i == columns.Cue || i == columns.Line || i == columns.Notes || i == columns.Tags {
+            continue
+        }
+        if columns.Cue < 0 {
+            columns.Cue = i
+        } else {
+            columns.Line = i
+        }
+    }
+    return columns
+}
+
+// Whether a row looks like column names, such as "Front,Back".
+func columnNamesIn(row []string) bool {
+    for _, cell := range row {
+        switch strings.ToLower(strings.TrimSpace(cell)) {
+        case "cue", "line", "front", "back", "question", "answer":
+            return true
+        }
+    }
+    return false
+}
+
+// Makes a line out of every card, in order. Cards without a role are
+// given CUE and LINE as their roles.
+func (table CardTable) Lines(columns CardColumns) ([]LineData, error) {
+    width := table.Width()
+    for _, column := range []int{columns.Cue, columns.Line, columns.Notes, columns.Tags} {
+        if column >= width {
+            return nil, fmt.Errorf("%w There are only %d columns.", ErrInvalidColumns, width)
+        }
+    }
+    if columns.Cue < 0 || columns.Line < 0 || columns.Cue == columns.Line {
+        return nil, ErrInvalidColumns
+    }
+
+    rows := table.Rows
+    if columns.Header {
+        rows = rows[1:]
+    }
+    var lines []LineData
+    for _, row := range rows {
+        cell := func(column int) string {
+            if column < 0 || column >= len(row) {
+                return ""
+            }
+            return cardText(row[column])
+        }
+        cue := strings.Join(strings.Fields(cell(columns.Cue)), " ")
+        text := strings.Join(strings.Fields(cell(columns.Line)), " ")
+        if text == "" {
+            continue
+        }
+        line := LineData{
+            Id: len(lines),
+            Cue: withCardRole(cue, cardCueRole),
+            Line: withCardRole(text, cardLineRole),
+            Notes: cell(columns.Notes),
+        }
+        for _, tag := range cardTags(cell(columns.Tags)) {
+            if strings.EqualFold(tag, starredTag) {
+                line.Starred = true
+            } else if !line.HasTag(tag) {
+                line.Tags = append(line.Tags, tag)
+            }
+        }
+        lines = append(lines, line)
+    }
+    if len(lines) == 0 {
+        return nil, ErrNoCards
+    }
+    return lines, nil
+}
+
+// Turns the HTML Anki keeps in fields into plain text, leaving out
+// [sound:...] references to media that is not imported.
+func cardText(field string) string {
+    var sb strings.Builder
+    for len(field) > 0 {
+        switch {
+        case strings.HasPrefix(field, "<"):
+            end := strings.Index(field, ">")
+            if end < 0 {
+                sb.WriteString(field)
+                field = ""
+                continue
+            }
+            tag := strings.ToLower(strings.Trim(field[1:end], "/ "))
+            if tag == "br" || tag == "div" || tag == "p" {
+                sb.WriteString("\n")
+            }
+            field = field[end + 1:]
+        case strings.HasPrefix(field, "[sound:"):
+            end := strings.Index(field, "]")
+            if end < 0 {
+                end = len(field) - 1
+            }
+            field = field[end + 1:]
+        default:
+            next := strings.IndexAny(field[1:], "<[")
+            if next < 0 {
+                next = len(field) - 1
+            }
+            sb.WriteString(field[:next + 1])
+            field = field[next + 1:]
+        }
+    }
+    text := strings.ReplaceAll(html.UnescapeString(sb.String()), "\u00a0", " ")
+    return strings.TrimSpace(text)
+}
+
+// Anki separates tags with spaces; spreadsheets often use commas.
+func cardTags(s string) []string {
+    if strings.Contains(s, ",") {
+        return linefile.ParseTags(s)
+    }
+    return strings.Fields(s)
+}
+
+// A look at how a deck will be imported, so the user can check the
+// columns before creating the line set.
+type CardPreview struct {
+    Columns CardColumns `json:"columns"`
+    Width int `json:"width"`
+    // The first few rows as read from the file
+    Rows [][]string `json:"rows"`
+    // The first few lines they make, and how many there are in all
+    Lines []LineData `json:"lines"`
+    Count int `json:"count"`
+    // Why the columns cannot be imported, if they cannot
+    Problem string `json:"problem,omitempty"`
+}
+
+const cardPreviewRows = 5
+
+// Previews a deck with the given columns, or with guessed ones if nil.
+func PreviewCards(text string, columns *CardColumns) (CardPreview, error) {
+    table, err := ReadCards(text)
+    if err != nil {
+        return CardPreview{}, err
+    }
+    preview := CardPreview{Width: table.Width(), Lines: []LineData{}}
+    if columns != nil {
+        preview.Columns = *columns
+    } else {
+        preview.Columns = table.GuessColumns()
+    }
+    preview.Rows = table.Rows[:min(len(table.Rows), cardPreviewRows)]
+    lines, err := table.Lines(preview.Columns)
+    if err != nil {
+        preview.Problem = err.Error()
+        return preview, nil
+    }
+    preview.Lines = lines[:min(len(lines), cardPreviewRows)]
+    preview.Count = len(lines)
+    return preview, nil
+}
+
+// Reads the lines of a deck, guessing the columns if they are nil.
+func CardLines(text string, columns *CardColumns) ([]LineData, error) {
+    table, err := ReadCards(text)
+    if err != nil {
+        return nil, err
+    }
+    if columns == nil {
+        guess := table.GuessColumns()
+        columns = &guess
+    }
+    return table.Lines(*columns)
+}
+
+// CreateLineSetFromCards makes a line set out of a flashcard deck.
+func CreateLineSetFromCards(user UserId, title string, text string, columns *CardColumns) (LineSetId, error) {
+    title = strings.TrimSpace(title)
+    if err := checkTitleAvailable(user, title); err != nil {
+        return 0, err
+    }
+    lines, err := CardLines(text, columns)
+    if err != nil {
+        return 0, err
+    }
+    return addLineSetWithRevision(user, title, lines)
+}
+
+// Writes an Anki deck with the cue on the front of each card and the
+// line on the back, followed by the notes and tags. Starred lines are
+// tagged "starred".
+func writeAnkiDeck(w io.Writer, lines []LineData, separator rune) error {
+    names := map[rune]string{'\t': "tab", ',': "comma"}
+    fmt.Fprintf(w, "#separator:%s\n#html:false\n#columns:%s\n#tags column:4\n",
+        names[separator], strings.Join([]string{"Front", "Back", "Notes", "Tags"}, string(separator)))
+    cw := csv.NewWriter(w)
+    cw.Comma = separator
+    for _, line := range lines {
+        var tags []string
+        if line.Starred {
+            tags = append(tags, starredTag)
+        }
+        for _, tag := range line.Tags {
+            tags = append(tags, strings.ReplaceAll(tag, " ", "_"))
+        }
+        cw.Write([]string{line.Cue, line.Line, line.Notes, strings.Join(tags, " ")})
+    }
+    cw.Flush()
+    return cw.Error()
+}
@@ -0,0 +1,87 @@
+package feline
+
+import (
+    "bytes"
+    "reflect"
+    "testing"
+
+    "github.com/ruuzia/lynx/linefile"
+)
+
+func TestCardLines(t *testing.T) {
+    tests := []struct {
+        name string
+        deck string
+        want []LineData
+    }{
+        {
+            name: "questions and answers",
+            deck: "Front,Back\nTo be or not to be?,That is the question.\n",
+            want: []LineData{{Id: 0, Cue: "CUE: To be or not to be?", Line: "LINE: That is the question."}},
+        },
+        {
+            name: "roles kept",
+            deck: "RUFUS: Oh no! Poco!\tPOCO: Aaaagh! I am slain.\n",
+            want: []LineData{{Id: 0, Cue: "RUFUS: Oh no! Poco!", Line: "POCO: Aaaagh! I am slain."}},
+        },
+        {
+            name: "anki",
+            deck: "#separator:tab\n#html:true\n#tags column:3\n" +
+                "What<br>light?\tThrough <b>yonder</b> window [sound:r.mp3]\tstarred act2\n" +
+                "\tBreaks.\t\n",
+            want: []LineData{
+                {Id: 0, Cue: "CUE: What light?", Line: "LINE: Through yonder window", Starred: true, Metadata: linefile.Metadata{Tags: []string{"act2"}}},
+                {Id: 1, Cue: "CUE:", Line: "LINE: Breaks."},
+            },
+        },
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            got, err := CardLines(test.deck, nil)
+            if err != nil {
+                t.Fatalf("CardLines: %v", err)
+            }
+            if !reflect.DeepEqual(got, test.want) {
+                t.Errorf("CardLines =\n%#v\nwant\n%#v", got, test.want)
+            }
+        })
+    }
+}
+
+// An imported deck can be written as a line file and read back, and
+// exported as a deck and imported again, without changing.
+func TestCardsRoundTrip(t *testing.T) {
+    deck := "Front,Back,Notes,Tags\n" +
+        "To be or not to be?,That is the question.,\"Slowly,\nthen fast\",soliloquy starred\n" +
+        "\"Whether 'tis nobler\",\"In the mind, to suffer\",,\n" +
+        "HAMLET: The slings,HAMLET: and arrows,,\n" +
+        ",Of outrageous fortune,,\n"
+    lines, err := CardLines(deck, nil)
+    if err != nil {
+        t.Fatalf("CardLines: %v", err)
+    }
+    if len(lines) != 4 {
+        t.Fatalf("%d lines, want 4: %#v", len(lines), lines)
+    }
+
+    text := linefile.Format(lines)
+    parsed, err := linefile.ParseString(text)
+    if err != nil {
+        t.Fatalf("ParseString(Format(lines)): %v\n%s", err, text)
+    }
+    if !reflect.DeepEqual(parsed, lines) {
+        t.Errorf("line file round trip =\n%#v\nwant\n%#v", parsed, lines)
+    }
+
+    var exported bytes.Buffer
+    if err := writeAnkiDeck(&exported, lines, '\t'); err != nil {
+        t.Fatalf("writeAnkiDeck: %v", err)
+    }
+    again, err := CardLines(exported.String(), nil)
+    if err != nil {
+        t.Fatalf("CardLines(exported): %v\n%s", err, exported.String())
+    }
+    if !reflect.DeepEqual(again, lines) {
+        t.Errorf("deck round trip =\n%#v\nwant\n%#v", again, lines)
+    }
+}
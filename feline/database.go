@@ -9,8 +9,11 @@ import (
     "encoding/json"
     "log"
     "os"
+    "strings"
     "time"
     _ "github.com/go-sql-driver/mysql"
+
+    "github.com/ruuzia/lynx/linefile"
 )
 
 var db *sql.DB
@@ -38,7 +41,7 @@ func OpenDatabase() {
         log.Fatal(err)
     }
 
-    db, err = sql.Open("mysql", fmt.Sprintf("%s:%s@/%s", credentials.User, credentials.Passsword, credentials.Database))
+    db, err = sql.Open("mysql", fmt.Sprintf("%s:%s@/%s?clientFoundRows=true&parseTime=true", credentials.User, credentials.Passsword, credentials.Database))
     if err != nil {
         log.Fatal(err)
     }
@@ -51,41 +54,1238 @@ func OpenDatabase() {
     db.SetMaxIdleConns(10)
 }
 
-func GetLineSets(user_id UserId) ([]string, error) {
-    var files []string
+type LineSetId int
+
+type LineSet struct {
+    Id LineSetId `json:"id"`
+    Title string `json:"title"`
+    // One of "private", "unlisted" or "public"
+    Visibility string `json:"visibility"`
+    // Secret used in links to unlisted line sets
+    ShareToken string `json:"share_token,omitempty"`
+}
+
+const lineSetColumns = `id, title, visibility, COALESCE(share_token, '')`
+
+type rowScanner interface {
+    Scan(dest ...any) error
+}
+
+func scanLineSet(row rowScanner) (LineSet, error) {
+    var set LineSet
+    err := row.Scan(&set.Id, &set.Title, &set.Visibility, &set.ShareToken)
+    return set, err
+}
+
+/**
+ * Lists a user's line sets, newest first.
+ */
+func GetLineSets(user_id UserId) ([]LineSet, error) {
+    var sets []LineSet
     q := `
-    SELECT title FROM line_sets WHERE user_id = ?
+    SELECT ` + lineSetColumns + ` FROM line_sets
+    WHERE user_id = ? AND deleted_at IS NULL
+    ORDER BY id DESC
     `
     rows, err := db.Query(q, int(user_id))
     if err != nil {
         return nil, err
     }
+    defer rows.Close()
+    for rows.Next() {
+        set, err := scanLineSet(rows)
+        if err != nil {
+            return nil, err
+        }
+
+        sets = append(sets, set)
+    }
+    return sets, rows.Err()
+}
+
+/**
+ * Looks up one of a user's line sets by id. Returns sql.ErrNoRows if
+ * the line set does not exist, was deleted or belongs to someone else.
+ */
+func GetLineSet(user_id UserId, id LineSetId) (LineSet, error) {
+    q := `
+    SELECT ` + lineSetColumns + ` FROM line_sets
+    WHERE user_id = ? AND id = ? AND deleted_at IS NULL
+    `
+    return scanLineSet(db.QueryRow(q, user_id, id))
+}
+
+func GetLineSetByTitle(user_id UserId, title string) (LineSet, error) {
+    q := `
+    SELECT ` + lineSetColumns + ` FROM line_sets
+    WHERE user_id = ? AND title = ? AND deleted_at IS NULL
+    `
+    return scanLineSet(db.QueryRow(q, user_id, title))
+}
+
+func SetLineSetTitle(user_id UserId, id LineSetId, title string) error {
+    q := `
+    UPDATE line_sets SET title = ? WHERE user_id = ? AND id = ? AND deleted_at IS NULL
+    `
+    return expectOneRow(db.Exec(q, title, user_id, id))
+}
+
+/**
+ * Marks a line set as deleted. It is hidden everywhere but can be
+ * restored until it is purged.
+ */
+func DeleteLineSet(user_id UserId, id LineSetId) error {
+    q := `
+    UPDATE line_sets SET deleted_at = ? WHERE user_id = ? AND id = ? AND deleted_at IS NULL
+    `
+    return expectOneRow(db.Exec(q, time.Now().UTC(), user_id, id))
+}
+
+type DeletedLineSet struct {
+    LineSet
+    DeletedAt time.Time `json:"deleted_at"`
+}
+
+/**
+ * Lists a user's line sets deleted after the given time, most recently
+ * deleted first.
+ */
+func GetDeletedLineSets(user_id UserId, since time.Time) ([]DeletedLineSet, error) {
+    q := `
+    SELECT ` + lineSetColumns + `, deleted_at FROM line_sets
+    WHERE user_id = ? AND deleted_at > ?
+    ORDER BY deleted_at DESC
+    `
+    rows, err := db.Query(q, user_id, since)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var sets []DeletedLineSet
+    for rows.Next() {
+        var set DeletedLineSet
+        err := rows.Scan(&set.Id, &set.Title, &set.Visibility, &set.ShareToken, &set.DeletedAt)
+        if err != nil {
+            return nil, err
+        }
+        sets = append(sets, set)
+    }
+    return sets, rows.Err()
+}
+
+/**
+ * Undoes DeleteLineSet for a line set deleted after the given time.
+ */
+func RestoreLineSet(user_id UserId, id LineSetId, since time.Time) error {
+    q := `
+    UPDATE line_sets SET deleted_at = NULL WHERE user_id = ? AND id = ? AND deleted_at > ?
+    `
+    return expectOneRow(db.Exec(q, user_id, id, since))
+}
+
+// A line set removed for good, by its owner's name and title.
+type PurgedLineSet struct {
+    Owner string
+    Title string
+}
+
+/**
+ * Removes line sets deleted before the given time for good. Their
+ * lines and review history go with them through ON DELETE CASCADE.
+ */
+func PurgeDeletedLineSets(before time.Time) ([]PurgedLineSet, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    q := `
+    SELECT u.name, s.title FROM line_sets s
+    JOIN users u ON u.id = s.user_id
+    WHERE s.deleted_at < ?
+    `
+    rows, err := tx.Query(q, before)
+    if err != nil {
+        return nil, err
+    }
+    var purged []PurgedLineSet
+    for rows.Next() {
+        var set PurgedLineSet
+        if err := rows.Scan(&set.Owner, &set.Title); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        purged = append(purged, set)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    if _, err := tx.Exec(`DELETE FROM line_sets WHERE deleted_at < ?`, before); err != nil {
+        return nil, err
+    }
+    return purged, tx.Commit()
+}
+
+/**
+ * Changes who can see a line set. Unlisted line sets are given a share
+ * token the first time, which is kept so old links keep working if the
+ * line set is made unlisted again.
+ */
+func SetLineSetVisibility(user_id UserId, id LineSetId, visibility string, shareToken string) error {
+    q := `
+    UPDATE line_sets SET visibility = ?, share_token = COALESCE(share_token, ?)
+    WHERE user_id = ? AND id = ?
+    `
+    return expectOneRow(db.Exec(q, visibility, shareToken, user_id, id))
+}
+
+// A line set as seen by someone who may not own it.
+type SharedLineSet struct {
+    Id LineSetId `json:"id"`
+    Title string `json:"title"`
+    Owner string `json:"owner"`
+    LineCount int `json:"line_count"`
+}
+
+const sharedLineSetQuery = `
+    SELECT s.id, s.title, u.name, (SELECT COUNT(*) FROM line_data l WHERE l.line_set_id = s.id)
+    FROM line_sets s
+    JOIN users u ON u.id = s.user_id
+    `
+
+func scanSharedLineSet(row rowScanner) (SharedLineSet, error) {
+    var set SharedLineSet
+    err := row.Scan(&set.Id, &set.Title, &set.Owner, &set.LineCount)
+    return set, err
+}
+
+/**
+ * Searches public line sets by title or owner, newest first.
+ */
+func SearchPublicLineSets(query string) ([]SharedLineSet, error) {
+    pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+    q := sharedLineSetQuery + `
+    WHERE s.visibility = 'public' AND s.deleted_at IS NULL AND (s.title LIKE ? OR u.name LIKE ?)
+    ORDER BY s.id DESC
+    LIMIT 100
+    `
+    rows, err := db.Query(q, pattern, pattern)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var sets []SharedLineSet
+    for rows.Next() {
+        set, err := scanSharedLineSet(rows)
+        if err != nil {
+            return nil, err
+        }
+        sets = append(sets, set)
+    }
+    return sets, rows.Err()
+}
+
+/**
+ * Looks up a line set that the viewer is allowed to see: their own,
+ * a public one, or an unlisted one with the right share token. Pass an
+ * empty share token when there is none.
+ */
+func GetVisibleLineSet(viewer UserId, id LineSetId, shareToken string) (SharedLineSet, error) {
+    q := sharedLineSetQuery + `
+    WHERE s.id = ? AND ` + visibleToViewer
+    return scanSharedLineSet(db.QueryRow(q, id, viewer, shareToken))
+}
+
+func GetLineSetByShareToken(shareToken string) (SharedLineSet, error) {
+    q := sharedLineSetQuery + `
+    WHERE s.share_token = ? AND s.visibility IN ('unlisted', 'public') AND s.deleted_at IS NULL
+    `
+    return scanSharedLineSet(db.QueryRow(q, shareToken))
+}
+
+// Condition on line_sets s that takes the viewer and share token as
+// parameters.
+const visibleToViewer = `s.deleted_at IS NULL AND (
+        s.user_id = ?
+        OR s.visibility = 'public'
+        OR (s.visibility = 'unlisted' AND s.share_token = ? AND s.share_token <> '')
+    )`
+
+/**
+ * Returns the lines of a line set the viewer is allowed to see.
+ * Stars, notes and tags are left out unless the viewer owns the line
+ * set.
+ */
+func GetVisibleLines(viewer UserId, id LineSetId, shareToken string) ([]LineData, error) {
+    q := `
+    SELECT l.line_number, l.cue, l.line,
+        IF(s.user_id = ?, l.flagged, FALSE), IF(s.user_id = ?, l.notes, ''),
+        l.act, l.scene, l.metadata, IF(s.user_id = ?, l.tags, NULL), NULL
+    FROM line_data l
+    JOIN line_sets s ON s.id = l.line_set_id
+    WHERE s.id = ? AND ` + visibleToViewer + `
+    ORDER BY l.line_number
+    `
+    rows, err := db.Query(q, viewer, viewer, viewer, id, viewer, shareToken)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var lines []LineData
     for rows.Next() {
-        var name string
-        if err = rows.Scan(&name); err != nil {
+        line, err := scanLine(rows)
+        if err != nil {
             return nil, err
         }
+        lines = append(lines, line)
+    }
+    return lines, rows.Err()
+}
+
+/**
+ * Copies a line set the user is allowed to see into their own library
+ * as a new private line set. The copy starts without stars, notes or
+ * tags.
+ */
+func CopyLineSet(user_id UserId, source LineSetId, shareToken string, title string) (LineSetId, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    var exists bool
+    q := `SELECT TRUE FROM line_sets s WHERE s.id = ? AND ` + visibleToViewer
+    if err := tx.QueryRow(q, source, user_id, shareToken).Scan(&exists); err != nil {
+        return 0, err
+    }
+
+    id, err := copyLineSet(tx, user_id, source, title)
+    if err != nil {
+        return 0, err
+    }
+    return id, tx.Commit()
+}
+
+// Inserts a private copy of a line set without any stars, notes or
+// tags.
+func copyLineSet(tx *sql.Tx, user_id UserId, source LineSetId, title string) (LineSetId, error) {
+    q := `INSERT INTO line_sets (user_id, title, forked_from) VALUES (?, ?, ?)`
+    result, err := tx.Exec(q, user_id, title, source)
+    if err != nil {
+        return 0, err
+    }
+    id, err := result.LastInsertId()
+    if err != nil {
+        return 0, err
+    }
 
-        files = append(files, name)
+    q = `
+    INSERT INTO line_data (line_set_id, line_number, cue, line, flagged, notes, act, scene, metadata)
+    SELECT ?, line_number, cue, line, FALSE, '', act, scene, metadata FROM line_data WHERE line_set_id = ?
+    `
+    if _, err := tx.Exec(q, id, source); err != nil {
+        return 0, err
     }
-    return files, nil
+    return LineSetId(id), nil
 }
 
-func AddLineSet(user_id UserId, title string) error {
+/**
+ * Creates a line set along with all of its lines in a single
+ * transaction.
+ */
+func AddLineSet(user_id UserId, title string, lines []LineData) (LineSetId, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
     q := `
     INSERT INTO line_sets (user_id, title) VALUES (?, ?)
     `
-    _, err := db.Exec(q, user_id, title)
-    return err
+    result, err := tx.Exec(q, user_id, title)
+    if err != nil {
+        return 0, err
+    }
+    id, err := result.LastInsertId()
+    if err != nil {
+        return 0, err
+    }
+    if err := insertLines(tx, LineSetId(id), lines); err != nil {
+        return 0, err
+    }
+    return LineSetId(id), tx.Commit()
 }
 
-func GetUser(username string) (User, error) {
+// The kinds of audio recorded for line_data l, separated by commas.
+const lineAudioColumn = `(SELECT GROUP_CONCAT(a.kind ORDER BY a.kind) FROM line_audio a WHERE a.line_id = l.id)`
+
+// Scans the line_number, cue, line, flagged, notes, act, scene,
+// metadata, tags and lineAudioColumn columns, in that order.
+func scanLine(row rowScanner) (LineData, error) {
+    var line LineData
+    var metadata, tags, audio sql.NullString
+    err := row.Scan(&line.Id, &line.Cue, &line.Line, &line.Starred, &line.Notes, &line.Act, &line.Scene, &metadata, &tags, &audio)
+    if err != nil {
+        return line, err
+    }
+    if audio.Valid {
+        line.Audio = strings.Split(audio.String, ",")
+    }
+    if metadata.Valid {
+        if err := json.Unmarshal([]byte(metadata.String), &line.Metadata); err != nil {
+            return line, err
+        }
+    }
+    // Tags are only read from their own column
+    line.Tags = nil
+    if tags.Valid {
+        err = json.Unmarshal([]byte(tags.String), &line.Tags)
+    }
+    return line, err
+}
+
+// Line metadata is stored as JSON, or NULL if there is none. Tags are
+// kept apart in tagsColumn, since like stars and notes they belong to
+// the actor rather than the script.
+func metadataColumn(metadata linefile.Metadata) sql.NullString {
+    metadata.Tags = nil
+    if metadata.IsZero() {
+        return sql.NullString{}
+    }
+    data, err := json.Marshal(metadata)
+    if err != nil {
+        return sql.NullString{}
+    }
+    return sql.NullString{String: string(data), Valid: true}
+}
+
+// Tags are stored as a JSON array, or NULL if there are none.
+func tagsColumn(tags []string) sql.NullString {
+    if len(tags) == 0 {
+        return sql.NullString{}
+    }
+    data, err := json.Marshal(tags)
+    if err != nil {
+        return sql.NullString{}
+    }
+    return sql.NullString{String: string(data), Valid: true}
+}
+
+/**
+ * Returns the lines of a line set ordered by line number.
+ */
+func GetLines(set LineSetId) ([]LineData, error) {
     q := `
-    SELECT id, name, password_hash
-    FROM users
-    WHERE name = ?;
+    SELECT line_number, cue, line, flagged, notes, act, scene, metadata, tags, ` + lineAudioColumn + `
+    FROM line_data l
+    WHERE line_set_id = ?
+    ORDER BY line_number
     `
-    row := db.QueryRow(q, username)
+    rows, err := db.Query(q, set)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var lines []LineData
+    for rows.Next() {
+        line, err := scanLine(rows)
+        if err != nil {
+            return nil, err
+        }
+        lines = append(lines, line)
+    }
+    return lines, rows.Err()
+}
+
+/**
+ * Replaces every line in a line set. Line numbers are taken from the
+ * position in the slice.
+ */
+func SetLines(set LineSetId, lines []LineData) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.Exec(`DELETE FROM line_data WHERE line_set_id = ?`, set); err != nil {
+        return err
+    }
+    if err := insertLines(tx, set, lines); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func insertLines(tx *sql.Tx, set LineSetId, lines []LineData) error {
+    q := `
+    INSERT INTO line_data (line_set_id, line_number, cue, line, flagged, notes, act, scene, metadata, tags)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `
+    stmt, err := tx.Prepare(q)
+    if err != nil {
+        return err
+    }
+    defer stmt.Close()
+    for i, line := range lines {
+        _, err := stmt.Exec(set, i, line.Cue, line.Line, line.Starred, line.Notes, line.Act, line.Scene, metadataColumn(line.Metadata), tagsColumn(line.Tags))
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func SetLineStarred(set LineSetId, lineNumber int, starred bool) error {
+    q := `
+    UPDATE line_data SET flagged = ? WHERE line_set_id = ? AND line_number = ?
+    `
+    return expectOneRow(db.Exec(q, starred, set, lineNumber))
+}
+
+func SetLineNotes(set LineSetId, lineNumber int, notes string) error {
+    q := `
+    UPDATE line_data SET notes = ? WHERE line_set_id = ? AND line_number = ?
+    `
+    return expectOneRow(db.Exec(q, notes, set, lineNumber))
+}
+
+func UpdateLineTags(set LineSetId, lineNumber int, tags []string) error {
+    q := `
+    UPDATE line_data SET tags = ? WHERE line_set_id = ? AND line_number = ?
+    `
+    return expectOneRow(db.Exec(q, tagsColumn(tags), set, lineNumber))
+}
+
+func GetLine(set LineSetId, lineNumber int) (LineData, error) {
+    q := `
+    SELECT line_number, cue, line, flagged, notes, act, scene, metadata, tags, ` + lineAudioColumn + `
+    FROM line_data l
+    WHERE line_set_id = ? AND line_number = ?
+    `
+    return scanLine(db.QueryRow(q, set, lineNumber))
+}
+
+/**
+ * Saves every field of a line except its tags, which are set with
+ * UpdateLineTags. The line is identified by its Id, which is the
+ * line number.
+ */
+func UpdateLine(set LineSetId, line LineData) error {
+    q := `
+    UPDATE line_data SET cue = ?, line = ?, flagged = ?, notes = ?, act = ?, scene = ?, metadata = ?
+    WHERE line_set_id = ? AND line_number = ?
+    `
+    metadata := metadataColumn(line.Metadata)
+    return expectOneRow(db.Exec(q, line.Cue, line.Line, line.Starred, line.Notes, line.Act, line.Scene, metadata, set, line.Id))
+}
+
+type LineAudio struct {
+    // The row id of the line in line_data, which stays the same when
+    // the line is renumbered
+    LineId int `json:"-"`
+    Kind string `json:"kind"`
+    ContentType string `json:"content_type"`
+    Size int `json:"size"`
+    UploadedAt time.Time `json:"uploaded_at"`
+}
+
+/**
+ * Records that audio of a kind was uploaded for a line, replacing any
+ * before it. Returns the audio with the line's row id filled in.
+ */
+func SetLineAudio(set LineSetId, lineNumber int, audio LineAudio) (LineAudio, error) {
+    q := `SELECT id FROM line_data WHERE line_set_id = ? AND line_number = ?`
+    if err := db.QueryRow(q, set, lineNumber).Scan(&audio.LineId); err != nil {
+        return audio, err
+    }
+    q = `
+    INSERT INTO line_audio (line_id, kind, content_type, size, uploaded_at)
+    VALUES (?, ?, ?, ?, ?)
+    ON DUPLICATE KEY UPDATE content_type = VALUES(content_type), size = VALUES(size), uploaded_at = VALUES(uploaded_at)
+    `
+    _, err := db.Exec(q, audio.LineId, audio.Kind, audio.ContentType, audio.Size, audio.UploadedAt)
+    return audio, err
+}
+
+/**
+ * Looks up the audio of a kind recorded for a line.
+ */
+func GetLineAudio(set LineSetId, lineNumber int, kind string) (LineAudio, error) {
+    q := `
+    SELECT a.line_id, a.kind, a.content_type, a.size, a.uploaded_at
+    FROM line_audio a
+    JOIN line_data l ON l.id = a.line_id
+    WHERE l.line_set_id = ? AND l.line_number = ? AND a.kind = ?
+    `
+    var audio LineAudio
+    err := db.QueryRow(q, set, lineNumber, kind).Scan(&audio.LineId, &audio.Kind, &audio.ContentType, &audio.Size, &audio.UploadedAt)
+    return audio, err
+}
+
+/**
+ * Forgets the audio of a kind recorded for a line.
+ */
+func DeleteLineAudio(audio LineAudio) error {
+    q := `DELETE FROM line_audio WHERE line_id = ? AND kind = ?`
+    return expectOneRow(db.Exec(q, audio.LineId, audio.Kind))
+}
+
+/**
+ * Lists the audio recorded for every line that still exists.
+ */
+func GetAllLineAudio() ([]LineAudio, error) {
+    q := `SELECT line_id, kind, content_type, size, uploaded_at FROM line_audio`
+    rows, err := db.Query(q)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var clips []LineAudio
+    for rows.Next() {
+        var audio LineAudio
+        if err := rows.Scan(&audio.LineId, &audio.Kind, &audio.ContentType, &audio.Size, &audio.UploadedAt); err != nil {
+            return nil, err
+        }
+        clips = append(clips, audio)
+    }
+    return clips, rows.Err()
+}
+
+/**
+ * Returns the voice picked for each role in a line set, by role in
+ * upper case.
+ */
+func GetRoleVoices(set LineSetId) (map[string]string, error) {
+    q := `SELECT role, voice FROM role_voices WHERE line_set_id = ?`
+    rows, err := db.Query(q, set)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    voices := map[string]string{}
+    for rows.Next() {
+        var role, voice string
+        if err := rows.Scan(&role, &voice); err != nil {
+            return nil, err
+        }
+        voices[role] = voice
+    }
+    return voices, rows.Err()
+}
+
+/**
+ * Replaces the voices picked for the roles in a line set. Roles
+ * without a voice use the default one.
+ */
+func SetRoleVoices(set LineSetId, voices map[string]string) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.Exec(`DELETE FROM role_voices WHERE line_set_id = ?`, set); err != nil {
+        return err
+    }
+    q := `INSERT INTO role_voices (line_set_id, role, voice) VALUES (?, ?, ?)`
+    for role, voice := range voices {
+        if _, err := tx.Exec(q, set, role, voice); err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+/**
+ * Removes a line and renumbers the lines after it so line numbers
+ * stay contiguous.
+ */
+func DeleteLine(set LineSetId, lineNumber int) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    q := `DELETE FROM line_data WHERE line_set_id = ? AND line_number = ?`
+    if err := expectOneRow(tx.Exec(q, set, lineNumber)); err != nil {
+        return err
+    }
+    q = `
+    UPDATE line_data SET line_number = line_number - 1
+    WHERE line_set_id = ? AND line_number > ?
+    ORDER BY line_number
+    `
+    if _, err := tx.Exec(q, set, lineNumber); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+/**
+ * Returns the spaced repetition state of every line in a line set
+ * which has been reviewed, keyed by line number.
+ */
+func GetReviewStates(set LineSetId) (map[int]ReviewState, error) {
+    q := `
+    SELECT l.line_number, r.ease, r.interval_days, r.repetitions, r.due
+    FROM line_reviews r
+    JOIN line_data l ON l.id = r.line_id
+    WHERE l.line_set_id = ?
+    `
+    rows, err := db.Query(q, set)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    states := map[int]ReviewState{}
+    for rows.Next() {
+        var lineNumber int
+        var state ReviewState
+        err := rows.Scan(&lineNumber, &state.Ease, &state.IntervalDays, &state.Repetitions, &state.Due)
+        if err != nil {
+            return nil, err
+        }
+        states[lineNumber] = state
+    }
+    return states, rows.Err()
+}
+
+func SaveReviewState(set LineSetId, lineNumber int, state ReviewState) error {
+    q := `
+    INSERT INTO line_reviews (line_id, ease, interval_days, repetitions, due)
+    SELECT id, ?, ?, ?, ? FROM line_data WHERE line_set_id = ? AND line_number = ?
+    ON DUPLICATE KEY UPDATE
+        ease = VALUES(ease),
+        interval_days = VALUES(interval_days),
+        repetitions = VALUES(repetitions),
+        due = VALUES(due)
+    `
+    return expectOneRow(db.Exec(q, state.Ease, state.IntervalDays, state.Repetitions, state.Due, set, lineNumber))
+}
+
+func RecordAttempt(user UserId, set LineSetId, attempt Attempt) error {
+    var grade sql.NullInt64
+    if attempt.Graded {
+        grade = sql.NullInt64{Int64: int64(attempt.Grade), Valid: true}
+    }
+    q := `
+    INSERT INTO line_attempts (user_id, line_id, attempted_at, review_method, grade, reveal_ms, hints_used)
+    SELECT ?, id, ?, ?, ?, ?, ? FROM line_data WHERE line_set_id = ? AND line_number = ?
+    `
+    return expectOneRow(db.Exec(q, user, attempt.AttemptedAt, attempt.ReviewMethod, grade, attempt.RevealMs, attempt.HintsUsed, set, attempt.Line))
+}
+
+/**
+ * Returns a user's attempts at the lines of a line set, oldest first.
+ */
+func GetAttempts(user UserId, set LineSetId) ([]Attempt, error) {
+    q := `
+    SELECT l.line_number, a.attempted_at, a.review_method, a.grade, a.reveal_ms, a.hints_used
+    FROM line_attempts a
+    JOIN line_data l ON l.id = a.line_id
+    WHERE a.user_id = ? AND l.line_set_id = ?
+    ORDER BY a.attempted_at, a.id
+    `
+    rows, err := db.Query(q, user, set)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var attempts []Attempt
+    for rows.Next() {
+        var attempt Attempt
+        var grade sql.NullInt64
+        err := rows.Scan(&attempt.Line, &attempt.AttemptedAt, &attempt.ReviewMethod, &grade, &attempt.RevealMs, &attempt.HintsUsed)
+        if err != nil {
+            return nil, err
+        }
+        attempt.Graded = grade.Valid
+        attempt.Grade = Grade(grade.Int64)
+        attempts = append(attempts, attempt)
+    }
+    return attempts, rows.Err()
+}
+
+/**
+ * Replaces the lines of a line set. previous holds, for each new line,
+ * the line number of the old line it takes the place of, or -1 for a
+ * line that is new. Lines that take the place of an old line keep its
+ * stars, notes and review history, and old lines that are not taken
+ * over are removed.
+ */
+func RewriteLines(set LineSetId, lines []LineData, previous []int) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    // Move the old lines out of the way of the new line numbers
+    q := `UPDATE line_data SET line_number = -1 - line_number WHERE line_set_id = ?`
+    if _, err := tx.Exec(q, set); err != nil {
+        return err
+    }
+    update := `
+    UPDATE line_data SET line_number = ?, cue = ?, line = ?, act = ?, scene = ?, metadata = ?
+    WHERE line_set_id = ? AND line_number = ?
+    `
+    for i, line := range lines {
+        if previous[i] < 0 {
+            continue
+        }
+        metadata := metadataColumn(line.Metadata)
+        err := expectOneRow(tx.Exec(update, i, line.Cue, line.Line, line.Act, line.Scene, metadata, set, -1 - previous[i]))
+        if err != nil {
+            return err
+        }
+    }
+    q = `DELETE FROM line_data WHERE line_set_id = ? AND line_number < 0`
+    if _, err := tx.Exec(q, set); err != nil {
+        return err
+    }
+    insert := `
+    INSERT INTO line_data (line_set_id, line_number, cue, line, flagged, notes, act, scene, metadata, tags)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `
+    for i, line := range lines {
+        if previous[i] >= 0 {
+            continue
+        }
+        _, err := tx.Exec(insert, set, i, line.Cue, line.Line, line.Starred, line.Notes, line.Act, line.Scene, metadataColumn(line.Metadata), tagsColumn(line.Tags))
+        if err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+/******************************
+ ********* Revisions **********
+ ******************************/
+
+type RevisionId int
+
+// A saved version of a line set's text. Stars and notes are not part
+// of the text.
+type Revision struct {
+    Id RevisionId `json:"id"`
+    Author string `json:"author"`
+    CreatedAt time.Time `json:"created_at"`
+    // How the text changed since the revision before
+    Added int `json:"added"`
+    Removed int `json:"removed"`
+    Changed int `json:"changed"`
+    // The revision that was restored to make this one, if any
+    RestoredFrom RevisionId `json:"restored_from,omitempty"`
+    Text string `json:"text,omitempty"`
+}
+
+const revisionColumns = `
+    r.id, u.name, r.created_at, r.lines_added, r.lines_removed, r.lines_changed,
+    COALESCE(r.restored_from, 0), r.text
+    FROM line_set_revisions r
+    JOIN users u ON u.id = r.author_id
+    `
+
+func scanRevision(row rowScanner) (Revision, error) {
+    var revision Revision
+    err := row.Scan(&revision.Id, &revision.Author, &revision.CreatedAt, &revision.Added,
+        &revision.Removed, &revision.Changed, &revision.RestoredFrom, &revision.Text)
+    return revision, err
+}
+
+func AddRevision(set LineSetId, author UserId, revision Revision) (RevisionId, error) {
+    q := `
+    INSERT INTO line_set_revisions
+        (line_set_id, author_id, created_at, text, lines_added, lines_removed, lines_changed, restored_from)
+    VALUES (?, ?, ?, ?, ?, ?, ?, NULLIF(?, 0))
+    `
+    result, err := db.Exec(q, set, author, time.Now().UTC(), revision.Text,
+        revision.Added, revision.Removed, revision.Changed, revision.RestoredFrom)
+    if err != nil {
+        return 0, err
+    }
+    id, err := result.LastInsertId()
+    return RevisionId(id), err
+}
+
+/**
+ * Lists the revisions of a line set, newest first, without their text.
+ */
+func GetRevisions(set LineSetId) ([]Revision, error) {
+    q := `SELECT ` + revisionColumns + `
+    WHERE r.line_set_id = ?
+    ORDER BY r.id DESC
+    `
+    rows, err := db.Query(q, set)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var revisions []Revision
+    for rows.Next() {
+        revision, err := scanRevision(rows)
+        if err != nil {
+            return nil, err
+        }
+        revision.Text = ""
+        revisions = append(revisions, revision)
+    }
+    return revisions, rows.Err()
+}
+
+func GetRevision(set LineSetId, id RevisionId) (Revision, error) {
+    q := `SELECT ` + revisionColumns + `
+    WHERE r.line_set_id = ? AND r.id = ?
+    `
+    return scanRevision(db.QueryRow(q, set, id))
+}
+
+/**
+ * Returns the newest revision of a line set from before the given
+ * one, or the newest revision overall if before is 0. Returns
+ * sql.ErrNoRows if there is none.
+ */
+func GetRevisionBefore(set LineSetId, before RevisionId) (Revision, error) {
+    q := `SELECT ` + revisionColumns + `
+    WHERE r.line_set_id = ? AND (? = 0 OR r.id < ?)
+    ORDER BY r.id DESC
+    LIMIT 1
+    `
+    return scanRevision(db.QueryRow(q, set, before, before))
+}
+
+/******************************
+ ******** Productions *********
+ ******************************/
+
+type ProductionId int
+
+type Production struct {
+    Id ProductionId `json:"id"`
+    Name string `json:"name"`
+    // The viewer's role and membership status
+    Role string `json:"role"`
+    Status string `json:"status"`
+}
+
+type ProductionMember struct {
+    UserId UserId `json:"user_id"`
+    Name string `json:"name"`
+    Role string `json:"role"`
+    Status string `json:"status"`
+}
+
+// An actor's own copy of one of a production's scripts.
+type ProductionCopy struct {
+    Script LineSetId `json:"script_id"`
+    UserId UserId `json:"user_id"`
+    LineSet LineSetId `json:"line_set_id"`
+}
+
+/**
+ * Creates a production with the user as its director.
+ */
+func AddProduction(director UserId, name string) (ProductionId, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    q := `INSERT INTO productions (name, created_at) VALUES (?, ?)`
+    result, err := tx.Exec(q, name, time.Now().UTC())
+    if err != nil {
+        return 0, err
+    }
+    id, err := result.LastInsertId()
+    if err != nil {
+        return 0, err
+    }
+    q = `
+    INSERT INTO production_members (production_id, user_id, role, status)
+    VALUES (?, ?, 'director', 'active')
+    `
+    if _, err := tx.Exec(q, id, director); err != nil {
+        return 0, err
+    }
+    return ProductionId(id), tx.Commit()
+}
+
+/**
+ * Lists the productions a user belongs to or has been invited to.
+ */
+func GetProductions(user UserId) ([]Production, error) {
+    q := `
+    SELECT p.id, p.name, m.role, m.status
+    FROM productions p
+    JOIN production_members m ON m.production_id = p.id
+    WHERE m.user_id = ?
+    ORDER BY p.id DESC
+    `
+    rows, err := db.Query(q, user)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var productions []Production
+    for rows.Next() {
+        var production Production
+        err := rows.Scan(&production.Id, &production.Name, &production.Role, &production.Status)
+        if err != nil {
+            return nil, err
+        }
+        productions = append(productions, production)
+    }
+    return productions, rows.Err()
+}
+
+/**
+ * Looks up a production the user belongs to or has been invited to.
+ * Returns sql.ErrNoRows for everyone else.
+ */
+func GetProduction(user UserId, id ProductionId) (Production, error) {
+    q := `
+    SELECT p.id, p.name, m.role, m.status
+    FROM productions p
+    JOIN production_members m ON m.production_id = p.id
+    WHERE m.user_id = ? AND p.id = ?
+    `
+    var production Production
+    err := db.QueryRow(q, user, id).Scan(&production.Id, &production.Name, &production.Role, &production.Status)
+    return production, err
+}
+
+func GetProductionMembers(id ProductionId) ([]ProductionMember, error) {
+    q := `
+    SELECT u.id, u.name, m.role, m.status
+    FROM production_members m
+    JOIN users u ON u.id = m.user_id
+    WHERE m.production_id = ?
+    ORDER BY m.role, u.name
+    `
+    rows, err := db.Query(q, id)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var members []ProductionMember
+    for rows.Next() {
+        var member ProductionMember
+        err := rows.Scan(&member.UserId, &member.Name, &member.Role, &member.Status)
+        if err != nil {
+            return nil, err
+        }
+        members = append(members, member)
+    }
+    return members, rows.Err()
+}
+
+/**
+ * Invites a user to a production by name. Returns sql.ErrNoRows if
+ * there is no such user.
+ */
+func AddProductionMember(id ProductionId, username string, role string) error {
+    q := `
+    INSERT INTO production_members (production_id, user_id, role, status)
+    SELECT ?, id, ?, 'invited' FROM users WHERE name = ?
+    `
+    return expectOneRow(db.Exec(q, id, role, username))
+}
+
+/**
+ * Accepts an invitation to a production.
+ */
+func ActivateProductionMember(id ProductionId, user UserId) error {
+    q := `
+    UPDATE production_members SET status = 'active'
+    WHERE production_id = ? AND user_id = ? AND status = 'invited'
+    `
+    return expectOneRow(db.Exec(q, id, user))
+}
+
+/**
+ * Removes a member or declines an invitation. Their copies of the
+ * scripts stay in their library.
+ */
+func DeleteProductionMember(id ProductionId, user UserId) error {
+    q := `DELETE FROM production_members WHERE production_id = ? AND user_id = ?`
+    return expectOneRow(db.Exec(q, id, user))
+}
+
+/**
+ * Adds one of the director's line sets to a production as a script.
+ */
+func AddProductionScript(id ProductionId, director UserId, set LineSetId) error {
+    q := `
+    INSERT INTO production_scripts (production_id, line_set_id)
+    SELECT ?, id FROM line_sets WHERE id = ? AND user_id = ?
+    `
+    return expectOneRow(db.Exec(q, id, set, director))
+}
+
+/**
+ * Lists a production's scripts in the order they were added.
+ */
+func GetProductionScripts(id ProductionId) ([]LineSet, error) {
+    q := `
+    SELECT ` + lineSetColumns + `
+    FROM line_sets
+    JOIN production_scripts p ON p.line_set_id = line_sets.id
+    WHERE p.production_id = ? AND line_sets.deleted_at IS NULL
+    ORDER BY line_sets.id
+    `
+    rows, err := db.Query(q, id)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var sets []LineSet
+    for rows.Next() {
+        set, err := scanLineSet(rows)
+        if err != nil {
+            return nil, err
+        }
+        sets = append(sets, set)
+    }
+    return sets, rows.Err()
+}
+
+/**
+ * Gives a member their own copy of a script, which starts without any
+ * stars or notes.
+ */
+func AddProductionCopy(id ProductionId, script LineSetId, user UserId, title string) (LineSetId, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    copyId, err := copyLineSet(tx, user, script, title)
+    if err != nil {
+        return 0, err
+    }
+    q := `
+    INSERT INTO production_copies (production_id, script_id, user_id, line_set_id)
+    VALUES (?, ?, ?, ?)
+    `
+    if _, err := tx.Exec(q, id, script, user, copyId); err != nil {
+        return 0, err
+    }
+    return copyId, tx.Commit()
+}
+
+/**
+ * Lists the members' copies of a production's scripts.
+ */
+func GetProductionCopies(id ProductionId) ([]ProductionCopy, error) {
+    q := `
+    SELECT script_id, user_id, line_set_id FROM production_copies
+    WHERE production_id = ?
+    `
+    rows, err := db.Query(q, id)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var copies []ProductionCopy
+    for rows.Next() {
+        var c ProductionCopy
+        if err := rows.Scan(&c.Script, &c.UserId, &c.LineSet); err != nil {
+            return nil, err
+        }
+        copies = append(copies, c)
+    }
+    return copies, rows.Err()
+}
+
+/**
+ * Lists the copies of a script kept by current members of its
+ * productions. Copies of members who have left, and copies their owner
+ * deleted, are left out.
+ */
+func GetScriptCopies(script LineSetId) ([]LineSetId, error) {
+    q := `
+    SELECT c.line_set_id FROM production_copies c
+    JOIN production_members m ON m.production_id = c.production_id AND m.user_id = c.user_id
+    JOIN line_sets s ON s.id = c.line_set_id
+    WHERE c.script_id = ? AND m.status = 'active' AND s.deleted_at IS NULL
+    `
+    rows, err := db.Query(q, script)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var copies []LineSetId
+    for rows.Next() {
+        var id LineSetId
+        if err := rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        copies = append(copies, id)
+    }
+    return copies, rows.Err()
+}
+
+/**
+ * Turns an UPDATE or DELETE that matched no rows into sql.ErrNoRows.
+ */
+func expectOneRow(result sql.Result, err error) error {
+    if err != nil {
+        return err
+    }
+    n, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if n == 0 {
+        return sql.ErrNoRows
+    }
+    return nil
+}
+
+func GetUser(username string) (User, error) {
+    q := `
+    SELECT id, name, password_hash
+    FROM users
+    WHERE name = ?;
+    `
+    row := db.QueryRow(q, username)
+
+    var user User;
+    err := row.Scan(&user.Id, &user.Name, &user.PasswordHash)
+    return user, err;
+}
+
+func GetUserById(id UserId) (User, error) {
+    q := `
+    SELECT id, name, password_hash
+    FROM users
+    WHERE id = ?;
+    `
+    row := db.QueryRow(q, id)
 
     var user User;
     err := row.Scan(&user.Id, &user.Name, &user.PasswordHash)
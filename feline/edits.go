@@ -0,0 +1,185 @@
+package feline
+
+import (
+    "strings"
+
+    "github.com/ruuzia/lynx/linefile"
+)
+
+// A line set can be edited as text after it has been created. Each
+// line of the new text is matched up with the line it most likely
+// came from, so that lines which were left alone or only lightly
+// edited keep their stars, notes and review history even when lines
+// around them were added, removed or moved.
+
+// How alike two lines need to be for an edited line to count as the
+// same line, from 0 to 1
+const similarLineThreshold = 0.6
+
+// The text of a line set for editing. Stars, notes and tags are left
+// out since they are kept with the lines rather than the text.
+func EditableText(lines []LineData) string {
+    return linefile.Format(withoutAnnotations(lines))
+}
+
+// Whether two lines have the same text, leaving out stars, notes and
+// tags.
+func sameScriptText(a LineData, b LineData) bool {
+    plain := withoutAnnotations([]LineData{a, b})
+    return sameText(plain[0], plain[1])
+}
+
+func withoutAnnotations(lines []LineData) []LineData {
+    plain := make([]LineData, len(lines))
+    for i, line := range lines {
+        line.Starred = false
+        line.Notes = ""
+        line.Tags = nil
+        plain[i] = line
+    }
+    return plain
+}
+
+// Replaces the text of a line set with the parsed lines as a new
+// revision and renames it. The title is checked first but only changed
+// once the lines have been, so a failed edit leaves both alone.
+func EditLineSet(user UserId, set LineSet, title string, lines []LineData) error {
+    title = strings.TrimSpace(title)
+    if title != set.Title {
+        if err := checkRenameAvailable(user, set.Id, title); err != nil {
+            return err
+        }
+    }
+    err := withRevision(user, set.Id, 0, func() error {
+        return EditLines(set.Id, lines)
+    })
+    if err != nil {
+        return err
+    }
+    if title != set.Title {
+        if err := RenameLineSet(user, set.Id, title); err != nil {
+            return err
+        }
+    }
+    return SyncScriptCopies(user, set.Id)
+}
+
+// Replaces the lines of a line set, keeping the stars, notes and
+// review history of every line that is matched to an old one.
+func EditLines(set LineSetId, lines []LineData) error {
+    old, err := GetLines(set)
+    if err != nil {
+        return err
+    }
+    previous := matchLines(old, lines)
+    for j, i := range previous {
+        if i >= 0 {
+            previous[j] = old[i].Id
+        }
+    }
+    return RewriteLines(set, lines, previous)
+}
+
+// For each new line, finds the index of the old line it came from, or
+// -1 if it is a new line. Lines are first matched in order, pairing up
+// the most similar lines, and then lines that moved without changing
+// are matched to where they were.
+func matchLines(old []LineData, lines []LineData) []int {
+    oldWords := make([][]string, len(old))
+    for i, line := range old {
+        oldWords[i] = lineWords(line)
+    }
+    newWords := make([][]string, len(lines))
+    for j, line := range lines {
+        newWords[j] = lineWords(line)
+    }
+
+    // best[i][j] is the highest total similarity of matching old[i:]
+    // to lines[j:] in order
+    n, m := len(old), len(lines)
+    best := make([][]float64, n + 1)
+    for i := range best {
+        best[i] = make([]float64, m + 1)
+    }
+    similar := make([][]float64, n)
+    for i := n - 1; i >= 0; i-- {
+        similar[i] = make([]float64, m)
+        for j := m - 1; j >= 0; j-- {
+            similar[i][j] = wordSimilarity(oldWords[i], newWords[j])
+            best[i][j] = max(best[i + 1][j], best[i][j + 1])
+            if similar[i][j] >= similarLineThreshold {
+                best[i][j] = max(best[i][j], best[i + 1][j + 1] + similar[i][j])
+            }
+        }
+    }
+
+    previous := make([]int, m)
+    for j := range previous {
+        previous[j] = -1
+    }
+    taken := make([]bool, n)
+    i, j := 0, 0
+    for i < n && j < m {
+        if similar[i][j] >= similarLineThreshold && best[i][j] == best[i + 1][j + 1] + similar[i][j] {
+            previous[j] = i
+            taken[i] = true
+            i++
+            j++
+        } else if best[i][j] == best[i + 1][j] {
+            i++
+        } else {
+            j++
+        }
+    }
+
+    moved := map[[2]string][]int{}
+    for i, line := range old {
+        if !taken[i] {
+            key := [2]string{line.Cue, line.Line}
+            moved[key] = append(moved[key], i)
+        }
+    }
+    for j, line := range lines {
+        key := [2]string{line.Cue, line.Line}
+        if previous[j] < 0 && len(moved[key]) > 0 {
+            previous[j] = moved[key][0]
+            moved[key] = moved[key][1:]
+        }
+    }
+    return previous
+}
+
+func lineWords(line LineData) []string {
+    var words []string
+    for _, word := range scoringWords(line.Cue + " " + line.Line) {
+        words = append(words, word.normal)
+    }
+    return words
+}
+
+// The words two lines have in common in order, as a fraction of the
+// longer line.
+func wordSimilarity(a []string, b []string) float64 {
+    longest := max(len(a), len(b))
+    if longest == 0 {
+        return 1
+    }
+    // Too different in length to ever be similar enough
+    if float64(min(len(a), len(b))) < similarLineThreshold * float64(longest) {
+        return 0
+    }
+    common := make([]int, len(b) + 1)
+    for i := len(a) - 1; i >= 0; i-- {
+        diagonal := 0
+        for j := len(b) - 1; j >= 0; j-- {
+            above := common[j]
+            if a[i] == b[j] {
+                common[j] = diagonal + 1
+            } else {
+                common[j] = max(common[j], common[j + 1])
+            }
+            diagonal = above
+        }
+    }
+    return float64(common[0]) / float64(longest)
+}
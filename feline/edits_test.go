@@ -0,0 +1,70 @@
+package feline
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestMatchLines(t *testing.T) {
+    a := LineData{Cue: "RUFUS: Oh no! Poco!", Line: "POCO: Aaaagh! I am slain."}
+    b := LineData{Cue: "RUFUS: Who goes there?", Line: "POCO: Only me, the ghost of your father."}
+    c := LineData{Cue: "RUFUS: What light through yonder window breaks?", Line: "POCO: It is the east."}
+    d := LineData{Cue: "RUFUS: To be or not to be?", Line: "POCO: That is the question."}
+    // One word of eight changed
+    cEdited := LineData{Cue: "RUFUS: What light through yonder door breaks?", Line: "POCO: It is the east."}
+    // Half the words changed
+    cRewritten := LineData{Cue: "RUFUS: What sound comes from the hall?", Line: "POCO: It is the east."}
+
+    tests := []struct {
+        name string
+        old []LineData
+        lines []LineData
+        want []int
+    }{
+        {"unchanged", []LineData{a, b, c}, []LineData{a, b, c}, []int{0, 1, 2}},
+        {"inserted", []LineData{a, c}, []LineData{a, b, c, d}, []int{0, -1, 1, -1}},
+        {"deleted", []LineData{a, b, c, d}, []LineData{b, d}, []int{1, 3}},
+        {"all deleted", []LineData{a, b}, nil, []int{}},
+        {"all new", nil, []LineData{a, b}, []int{-1, -1}},
+        {"swapped", []LineData{a, b}, []LineData{b, a}, []int{1, 0}},
+        {"moved to the end", []LineData{a, b, c, d}, []LineData{b, c, d, a}, []int{1, 2, 3, 0}},
+        {"moved to the start", []LineData{a, b, c, d}, []LineData{d, a, b, c}, []int{3, 0, 1, 2}},
+        {"lightly edited", []LineData{a, c, d}, []LineData{a, cEdited, d}, []int{0, 1, 2}},
+        {"rewritten", []LineData{a, c, d}, []LineData{a, cRewritten, d}, []int{0, -1, 2}},
+        {"edited and inserted", []LineData{a, c}, []LineData{b, a, cEdited}, []int{-1, 0, 1}},
+        {"edited and moved", []LineData{c, a, d}, []LineData{a, d, cEdited}, []int{1, 2, -1}},
+        {"deleted and replaced", []LineData{a, b, d}, []LineData{a, c, d}, []int{0, -1, 2}},
+        {"repeated", []LineData{a, b, a}, []LineData{a, a, b, a}, []int{0, -1, 1, 2}},
+        {"repeated and moved", []LineData{a, b, a}, []LineData{b, a, a}, []int{1, 2, 0}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            got := matchLines(test.old, test.lines)
+            if !reflect.DeepEqual(got, test.want) {
+                t.Errorf("matchLines = %v, want %v", got, test.want)
+            }
+        })
+    }
+}
+
+func TestWordSimilarity(t *testing.T) {
+    tests := []struct {
+        a string
+        b string
+        want float64
+    }{
+        {"", "", 1},
+        {"to be or not to be", "to be or not to be", 1},
+        {"to be or not to be", "To be, or not to be!", 1},
+        {"to be or not to be", "to be or not to see", 5.0 / 6},
+        {"to be or not to be", "to be", 0},
+        {"that is the question", "is that the question", 0.75},
+        {"that is the question", "what a piece of work", 0},
+    }
+    for _, test := range tests {
+        got := wordSimilarity(lineWords(LineData{Line: test.a}), lineWords(LineData{Line: test.b}))
+        if got != test.want {
+            t.Errorf("wordSimilarity(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+        }
+    }
+}
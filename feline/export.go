@@ -0,0 +1,168 @@
+package feline
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "html/template"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/ruuzia/lynx/linefile"
+)
+
+// Line sets can be exported as
+//
+//     text  the line format, with metadata, as read by the builder and the CLI
+//     json  the title and lines as returned by the API
+//     csv   one row per line, for spreadsheets
+//     html  printable sides: each cue followed by the actor's line
+//     anki  a flashcard deck to import into Anki, with tabs between fields
+//     anki-csv  the same with commas
+//
+// Stars and notes are left out unless asked for, since they are the
+// actor's own and exports are often shared with the rest of the cast.
+
+var ErrUnknownExportFormat = errors.New("Unknown export format, expected text, json, csv, html, anki or anki-csv")
+
+type ExportOptions struct {
+    Stars bool
+    Notes bool
+}
+
+type exportFormat struct {
+    contentType string
+    extension string
+    // Whether the browser should download the export rather than show it
+    download bool
+}
+
+var exportFormats = map[string]exportFormat{
+    "text": {"text/plain; charset=utf-8", "txt", true},
+    "json": {"application/json", "json", true},
+    "csv": {"text/csv; charset=utf-8", "csv", true},
+    "html": {"text/html; charset=utf-8", "html", false},
+    "anki": {"text/tab-separated-values; charset=utf-8", "txt", true},
+    "anki-csv": {"text/csv; charset=utf-8", "csv", true},
+}
+
+// Reads ?stars=true&notes=true.
+func exportOptions(r *http.Request) ExportOptions {
+    stars, _ := strconv.ParseBool(r.FormValue("stars"))
+    notes, _ := strconv.ParseBool(r.FormValue("notes"))
+    return ExportOptions{Stars: stars, Notes: notes}
+}
+
+// Clears the stars and notes that are not to be exported.
+func (options ExportOptions) apply(lines []LineData) []LineData {
+    exported := make([]LineData, len(lines))
+    for i, line := range lines {
+        line.Starred = line.Starred && options.Stars
+        if !options.Notes {
+            line.Notes = ""
+        }
+        exported[i] = line
+    }
+    return exported
+}
+
+// Writes the lines of a line set in one of the export formats.
+func ExportLineSet(w io.Writer, format string, title string, lines []LineData, options ExportOptions) error {
+    lines = options.apply(lines)
+    switch format {
+    case "text":
+        return linefile.Write(w, lines)
+    case "json":
+        if lines == nil {
+            lines = []LineData{}
+        }
+        return json.NewEncoder(w).Encode(struct {
+            Title string `json:"title"`
+            Lines []LineData `json:"lines"`
+        }{title, lines})
+    case "csv":
+        return writeExportCSV(w, lines, options)
+    case "html":
+        return writeSides(w, title, lines)
+    case "anki":
+        return writeAnkiDeck(w, lines, '\t')
+    case "anki-csv":
+        return writeAnkiDeck(w, lines, ',')
+    }
+    return ErrUnknownExportFormat
+}
+
+func writeExportCSV(w io.Writer, lines []LineData, options ExportOptions) error {
+    cw := csv.NewWriter(w)
+    header := []string{"id", "act", "scene", "cue", "line"}
+    if options.Stars {
+        header = append(header, "starred")
+    }
+    if options.Notes {
+        header = append(header, "notes")
+    }
+    header = append(header, "blocking", "directions", "page", "tags", "pronunciation")
+    cw.Write(header)
+    for _, line := range lines {
+        record := []string{strconv.Itoa(line.Id), line.Act, line.Scene, line.Cue, line.Line}
+        if options.Stars {
+            record = append(record, strconv.FormatBool(line.Starred))
+        }
+        if options.Notes {
+            record = append(record, line.Notes)
+        }
+        page := ""
+        if line.Page > 0 {
+            page = strconv.Itoa(line.Page)
+        }
+        record = append(record, line.Blocking, line.Directions, page, strings.Join(line.Tags, ", "), line.Pronunciation)
+        cw.Write(record)
+    }
+    cw.Flush()
+    return cw.Error()
+}
+
+func writeSides(w io.Writer, title string, lines []LineData) error {
+    t, err := template.ParseFiles("./web/templates/sides.html")
+    if err != nil {
+        return err
+    }
+    return t.Execute(w, struct {
+        Title string
+        Acts []linefile.Act
+    }{title, linefile.Outline(lines)})
+}
+
+// Responds with the export, named after the line set.
+func writeExport(w http.ResponseWriter, format string, title string, lines []LineData, options ExportOptions) {
+    exportFormat, exists := exportFormats[format]
+    if !exists {
+        http.Error(w, ErrUnknownExportFormat.Error(), http.StatusBadRequest)
+        return
+    }
+    w.Header().Set("Content-Type", exportFormat.contentType)
+    if exportFormat.download {
+        filename := exportFilename(title) + "." + exportFormat.extension
+        w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+    }
+    if err := ExportLineSet(w, format, title, lines, options); err != nil {
+        debug.Println("[export]", err)
+    }
+}
+
+// Keeps a title usable as a file name on any system.
+func exportFilename(title string) string {
+    name := strings.Map(func(c rune) rune {
+        if strings.ContainsRune(`/\:*?"<>|`, c) || c < ' ' {
+            return '_'
+        }
+        return c
+    }, strings.TrimSpace(title))
+    if name == "" {
+        return "lines"
+    }
+    return name
+}
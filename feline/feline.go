@@ -7,17 +7,25 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
-	"strings"
+	"time"
 )
 
 var debug = log.New(os.Stdout, "debug: ", log.Lshortfile)
 
 func OpenServer(address string) {
     OpenDatabase()
-    buildLynx()
+    sessionStore = NewSQLSessionStore(db)
+    go sweepSessions(sessionStore, sessionSweepInterval)
+    go sweepDeletedLineSets(time.Hour)
     http.HandleFunc("/", serveHome)
     http.HandleFunc("/builder", serveBuilder)
+    http.HandleFunc("/stats", serveStats)
+    http.HandleFunc("/browse", serveBrowse)
+    http.HandleFunc("/script", serveScript)
+    http.HandleFunc("/history", serveHistory)
+    http.HandleFunc("/export", serveExport)
+    http.HandleFunc("/productions", serveProductions)
+    http.HandleFunc("/production", serveProduction)
     http.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
         if r.Method == "GET" {
             serveLogin(w, LoginPage{})
@@ -42,9 +50,23 @@ func OpenServer(address string) {
     http.HandleFunc("/feline/startsession", handleStartSession)
     http.HandleFunc("/feline/starline", handleStarLine)
     http.HandleFunc("/feline/linenotes", handleLineNotes)
+    http.HandleFunc("/feline/linetags", handleLineTags)
+    http.HandleFunc("/feline/lineaudio", handleLineAudio)
+    http.HandleFunc("/feline/linespeech", handleLineSpeech)
+    http.HandleFunc("GET /feline/silence", handleSilence)
+    http.HandleFunc("POST /feline/voices", handleVoices)
+    http.HandleFunc("/feline/gradeline", handleGradeLine)
+    http.HandleFunc("/feline/checkline", handleCheckLine)
     http.HandleFunc("/feline/updatebuilder", handleUpdateBuilder)
     http.HandleFunc("/feline/finishbuilder", handleFinishBuilder)
     http.HandleFunc("/feline/list-line-sets", handleListLineSets)
+    http.HandleFunc("POST /feline/copylineset", handleCopyLineSet)
+    http.HandleFunc("POST /feline/reviewscenes", handleReviewScenes)
+    http.HandleFunc("POST /feline/visibility", handleLineSetVisibility)
+    http.HandleFunc("POST /feline/lineset/{action}", handleLineSetAction)
+    http.HandleFunc("POST /feline/restorerevision", handleRestoreRevision)
+    http.HandleFunc("POST /feline/production/{action}", handleProduction)
+    registerAPI(http.DefaultServeMux)
 
     fmt.Println("Listening to localhost:2323")
     log.Fatal(http.ListenAndServe(address, nil))
@@ -72,40 +94,11 @@ func redirectLogin(w http.ResponseWriter, r *http.Request) {
     http.Redirect(w, r, "/login", http.StatusFound)
 }
 
-// Just a helper function to run shell commands from a particular
-// directory
-func execute(dir string, exe string, arg ...string) {
-    cmd := exec.Command(exe, arg...)
-    cmd.Dir = dir
-    out, err := cmd.CombinedOutput()
-    fmt.Printf("%s\n", out)
-    if err != nil {
-        log.Fatal(err)
-    }
-    
-}
-
-// Mostly a convenience so that I don't have to do it myself :>
-func buildLynx() {
-    execute("", "mkdir", "-p", "build")
-    execute("./build/", "cmake", "..", "-G", "Ninja")
-    execute("./build/", "ninja")
-}
-
 func handleLogout(w http.ResponseWriter, r *http.Request) {
-    cookie, err := r.Cookie("session_token")
-    if err != nil {
-        if err == http.ErrNoCookie {
-            // Not logged in
-            redirectLogin(w, r)
-            return
-        }
-        log.Fatal(err)
+    if err := Logout(w, r); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
     }
-
-    token := SessionToken(cookie.Value)
-
-    delete(loginSessions, token)
     redirectLogin(w, r)
 }
 
@@ -174,7 +167,7 @@ func handleSignup(w http.ResponseWriter, r *http.Request) {
     StartSession(w, r, user)
 }
 
-func getFileList(session *Session) ([]string, error) {
+func getFileList(session *Session) ([]LineSet, error) {
     debug.Println("getFileList")
     files, err := GetLineSets(session.id)
     if err != nil {
@@ -183,23 +176,6 @@ func getFileList(session *Session) ([]string, error) {
     return files, nil
 }
 
-func runLynxCommand(user string, args... string) ([]byte, error) {
-    var cmdArgs []string
-    cmdArgs = append(cmdArgs, "--user")
-    cmdArgs = append(cmdArgs, user)
-    for _, arg := range args {
-        cmdArgs = append(cmdArgs, arg)
-    }
-    fmt.Println("CMD: ./Lynx", strings.Join(cmdArgs, " "))
-    cmd := exec.Command("./Lynx", cmdArgs...)
-    cmd.Dir = "build"
-    out, err := cmd.Output()
-    if err != nil {
-        fmt.Printf("Error: Code: %d Stderr: %s\n", err.(*exec.ExitError).ExitCode(), string(err.(*exec.ExitError).Stderr))
-    }
-    return out, err
-}
-
 type LoginPage struct {
     ErrorMessage string
 }
@@ -0,0 +1,177 @@
+package feline
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+)
+
+// A review session can be narrowed down with a filter such as
+//
+//     starred tag:song,tricky -scene:"The Garden"
+//
+// Every term must match for a line to be reviewed. The terms are
+// starred, due, new (never reviewed), tag:, scene: and act:, where
+// tag, scene and act take a comma separated list of names to match
+// any of, ignoring case. Names with spaces can be quoted, and a term
+// starting with '-' excludes the lines it matches.
+
+var ErrInvalidFilter = errors.New("Invalid filter")
+
+type filterTerm struct {
+    kind string
+    values []string
+    negate bool
+}
+
+type LineFilter struct {
+    terms []filterTerm
+}
+
+func ParseLineFilter(s string) (LineFilter, error) {
+    var filter LineFilter
+    words, err := splitFilter(s)
+    if err != nil {
+        return LineFilter{}, err
+    }
+    for _, word := range words {
+        var term filterTerm
+        word, term.negate = strings.CutPrefix(word, "-")
+        kind, value, hasValue := strings.Cut(word, ":")
+        term.kind = strings.ToLower(kind)
+        switch term.kind {
+        case "starred", "due", "new":
+            if hasValue {
+                return LineFilter{}, fmt.Errorf("%w: %s does not take a value", ErrInvalidFilter, term.kind)
+            }
+        case "tag", "scene", "act":
+            for _, v := range strings.Split(value, ",") {
+                if v = strings.TrimSpace(v); v != "" {
+                    term.values = append(term.values, v)
+                }
+            }
+            if len(term.values) == 0 {
+                return LineFilter{}, fmt.Errorf("%w: %s: needs a name", ErrInvalidFilter, term.kind)
+            }
+        default:
+            return LineFilter{}, fmt.Errorf("%w: unknown term %q, expected starred, due, new, tag:, scene: or act:", ErrInvalidFilter, word)
+        }
+        filter.terms = append(filter.terms, term)
+    }
+    return filter, nil
+}
+
+// Splits a filter at spaces outside of double quotes, removing the
+// quotes.
+func splitFilter(s string) ([]string, error) {
+    var words []string
+    var word strings.Builder
+    inWord, quoted := false, false
+    for _, c := range s {
+        switch {
+        case c == '"':
+            quoted = !quoted
+            inWord = true
+        case !quoted && (c == ' ' || c == '\t' || c == '\n' || c == '\r'):
+            if inWord {
+                words = append(words, word.String())
+                word.Reset()
+                inWord = false
+            }
+        default:
+            word.WriteRune(c)
+            inWord = true
+        }
+    }
+    if quoted {
+        return nil, fmt.Errorf("%w: missing closing '\"'", ErrInvalidFilter)
+    }
+    if inWord {
+        words = append(words, word.String())
+    }
+    return words, nil
+}
+
+func (filter LineFilter) IsEmpty() bool {
+    return len(filter.terms) == 0
+}
+
+// Whether the filter depends on the review schedule of each line.
+func (filter LineFilter) usesSchedule() bool {
+    for _, term := range filter.terms {
+        if term.kind == "due" || term.kind == "new" {
+            return true
+        }
+    }
+    return false
+}
+
+// Keeps the lines matching every term. states holds the review
+// schedule of each line which has been reviewed, by line number.
+func (filter LineFilter) Apply(lines []LineData, states map[int]ReviewState, now time.Time) []LineData {
+    if filter.IsEmpty() {
+        return lines
+    }
+    var kept []LineData
+    for _, line := range lines {
+        if filter.matches(line, states, now) {
+            kept = append(kept, line)
+        }
+    }
+    return kept
+}
+
+func (filter LineFilter) matches(line LineData, states map[int]ReviewState, now time.Time) bool {
+    for _, term := range filter.terms {
+        if term.matches(line, states, now) == term.negate {
+            return false
+        }
+    }
+    return true
+}
+
+func (term filterTerm) matches(line LineData, states map[int]ReviewState, now time.Time) bool {
+    state, reviewed := states[line.Id]
+    switch term.kind {
+    case "starred":
+        return line.Starred
+    case "due":
+        return !reviewed || state.IsDue(now)
+    case "new":
+        return !reviewed
+    case "tag":
+        for _, tag := range term.values {
+            if line.HasTag(tag) {
+                return true
+            }
+        }
+    case "scene":
+        return containsFold(term.values, line.Scene)
+    case "act":
+        return containsFold(term.values, line.Act)
+    }
+    return false
+}
+
+func containsFold(values []string, s string) bool {
+    for _, value := range values {
+        if strings.EqualFold(value, s) {
+            return true
+        }
+    }
+    return false
+}
+
+// Keeps the lines of a line set that match a filter, looking up their
+// review schedules if the filter needs them.
+func FilterLines(set LineSetId, lines []LineData, filter LineFilter) ([]LineData, error) {
+    var states map[int]ReviewState
+    if filter.usesSchedule() {
+        var err error
+        if states, err = GetReviewStates(set); err != nil {
+            return nil, err
+        }
+    }
+    return filter.Apply(lines, states, time.Now().UTC()), nil
+}
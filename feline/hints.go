@@ -0,0 +1,86 @@
+package feline
+
+import (
+    "strings"
+    "unicode/utf8"
+)
+
+// Between showing the cue and revealing the line, the actor can ask
+// for progressively stronger hints. Each hint used lowers the grade
+// of the attempt.
+
+type Hint struct {
+    Level string `json:"level"`
+    Text string `json:"text"`
+}
+
+// The hint ladder, from weakest to strongest.
+var hintLevels = []string{"skeleton", "first_letters", "cloze", "full"}
+
+// Generates every hint for a line, weakest first. The role is kept so
+// the actor knows who is speaking.
+func Hints(line LineData) []Hint {
+    role, text, found := strings.Cut(line.Line, ":")
+    prefix := ""
+    if found && isRoleName(role) {
+        prefix = role + ": "
+        text = strings.TrimSpace(text)
+    } else {
+        text = line.Line
+    }
+
+    words := strings.Fields(text)
+    skeleton := make([]string, len(words))
+    cloze := make([]string, len(words))
+    for i, word := range words {
+        skeleton[i] = blankWord(word)
+        if i % 2 == 0 {
+            cloze[i] = word
+        } else {
+            cloze[i] = blankWord(word)
+        }
+    }
+
+    return []Hint{
+        {Level: "skeleton", Text: prefix + strings.Join(skeleton, " ")},
+        {Level: "first_letters", Text: prefix + FirstLetters(text)},
+        {Level: "cloze", Text: prefix + strings.Join(cloze, " ")},
+        {Level: "full", Text: prefix + text},
+    }
+}
+
+// Hides the letters of a word behind a fixed width blank, keeping any
+// surrounding punctuation.
+func blankWord(word string) string {
+    first := strings.IndexFunc(word, isWordRune)
+    if first < 0 {
+        return word
+    }
+    last := strings.LastIndexFunc(word, isWordRune)
+    _, size := utf8.DecodeRuneInString(word[last:])
+    end := last + size
+    return word[:first] + "___" + word[end:]
+}
+
+// Parses the number of hints allowed from a level name, where "none"
+// allows no hints and "full" allows the whole ladder.
+func ParseHintLimit(level string) int {
+    for i, name := range hintLevels {
+        if level == name {
+            return i + 1
+        }
+    }
+    return 0
+}
+
+// Lowers a grade by one step for each hint used. Seeing the full line
+// as a hint always counts as forgotten.
+func (g Grade) WithHints(hintsUsed int) Grade {
+    if hintsUsed <= 0 {
+        return g
+    }
+    if hintsUsed >= len(hintLevels) {
+        return GradeAgain
+    }
+    return max(g - Grade(hintsUsed), GradeAgain)
+}
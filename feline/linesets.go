@@ -0,0 +1,570 @@
+package feline
+
+import (
+    "bufio"
+    "crypto/rand"
+    "encoding/base64"
+    "database/sql"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+    "unicode"
+
+    "github.com/ruuzia/lynx/linefile"
+)
+
+type LineData = linefile.LineData
+
+var (
+    ErrInvalidTitle = errors.New("Please provide a title.")
+    ErrDuplicateTitle = errors.New("A line set with this title already exists.")
+    ErrTitleCharacters = errors.New("Titles cannot contain slashes, \"..\" or control characters.")
+)
+
+// CreateLineSet validates the line set text and stores it under the
+// given title.
+func CreateLineSet(user UserId, title string, text string) (LineSetId, error) {
+    title = strings.TrimSpace(title)
+    if err := checkTitleAvailable(user, title); err != nil {
+        return 0, err
+    }
+    lines, err := linefile.ParseString(text)
+    if err != nil {
+        return 0, err
+    }
+    return addLineSetWithRevision(user, title, lines)
+}
+
+var (
+    ErrNoRoles = errors.New("Please select the roles you are playing.")
+    ErrNoSpeeches = errors.New("No speeches were found in the script.")
+    ErrSpeechFormat = errors.New("A speech could not be written as `ROLE: text`.")
+)
+
+// A whole script to pick an actor's lines from.
+type Script struct {
+    Text string
+    // One of linefile.ImportFormats, or linefile.FormatAuto
+    Format string
+    // Line numbers of ambiguous lines to read the other way
+    Alternatives []int
+}
+
+// Reads the script with the importer for its format.
+func ImportScript(script Script) (linefile.ImportResult, string, error) {
+    options := linefile.ImportOptions{Alternatives: map[int]bool{}}
+    for _, line := range script.Alternatives {
+        options.Alternatives[line] = true
+    }
+    result, format, err := linefile.Import(script.Text, script.Format, options)
+    if err == nil && len(result.Speeches) == 0 && strings.TrimSpace(script.Text) != "" {
+        err = ErrNoSpeeches
+    }
+    return result, format, err
+}
+
+// Makes a cue/line pair for every speech in the script by one of the
+// given roles.
+func ScriptLines(script Script, roles []string) ([]LineData, error) {
+    result, _, err := ImportScript(script)
+    if err != nil {
+        return nil, err
+    }
+    lines := linefile.PairsForRoles(result.Speeches, roles)
+    if len(lines) == 0 {
+        return nil, ErrNoRoles
+    }
+    // Anything that could not be read back from the line file would
+    // leave the set without revisions and unable to be edited
+    for _, line := range lines {
+        for _, text := range []string{line.Cue, line.Line} {
+            if !linefile.HasLineFormat(text) {
+                return nil, fmt.Errorf("%w %q", ErrSpeechFormat, text)
+            }
+        }
+    }
+    return lines, nil
+}
+
+// CreateLineSetFromScene makes a line set out of a whole script, with a
+// cue/line pair for every speech by one of the given roles.
+func CreateLineSetFromScene(user UserId, title string, script Script, roles []string) (LineSetId, error) {
+    title = strings.TrimSpace(title)
+    if err := checkTitleAvailable(user, title); err != nil {
+        return 0, err
+    }
+    lines, err := ScriptLines(script, roles)
+    if err != nil {
+        return 0, err
+    }
+    return addLineSetWithRevision(user, title, lines)
+}
+
+// Adds a line set and records its text as the first revision.
+func addLineSetWithRevision(user UserId, title string, lines []LineData) (LineSetId, error) {
+    id, err := AddLineSet(user, title, lines)
+    if err != nil {
+        return 0, err
+    }
+    return id, recordRevision(user, id, 0)
+}
+
+// Like checkTitleAvailable, for giving a line set a new title.
+func checkRenameAvailable(user UserId, set LineSetId, title string) error {
+    // MySQL compares titles without case by default, so changing only
+    // the case of a title finds the line set itself
+    if err := checkTitleAvailable(user, title); err == ErrDuplicateTitle {
+        existing, err := GetLineSetByTitle(user, title)
+        if err != nil {
+            return err
+        } else if existing.Id != set {
+            return ErrDuplicateTitle
+        }
+    } else if err != nil {
+        return err
+    }
+    return nil
+}
+
+// Renames the line file first, so that the title is only changed once
+// the file has been, and renames it back if the title cannot be.
+func RenameLineSet(user UserId, set LineSetId, title string) error {
+    title = strings.TrimSpace(title)
+    old, err := GetLineSet(user, set)
+    if err != nil {
+        return err
+    }
+    if title == old.Title {
+        return nil
+    }
+    if err := checkRenameAvailable(user, set, title); err != nil {
+        return err
+    }
+    if err := renameLineFile(user, old.Title, title); err != nil {
+        return err
+    }
+    if err := SetLineSetTitle(user, set, title); err != nil {
+        if undoErr := renameLineFile(user, title, old.Title); undoErr != nil {
+            debug.Println("[linesets] Error renaming line file back:", undoErr)
+        }
+        return err
+    }
+    return nil
+}
+
+// Deleted line sets can be restored for this long before they are
+// removed for good.
+const lineSetUndoWindow = 24 * time.Hour
+
+var ErrUndoExpired = errors.New("That line set can no longer be restored.")
+
+// Lists the line sets the user deleted recently enough to restore.
+func RecentlyDeletedLineSets(user UserId) ([]DeletedLineSet, error) {
+    return GetDeletedLineSets(user, time.Now().UTC().Add(-lineSetUndoWindow))
+}
+
+// Restores a recently deleted line set. If the user has since made
+// another line set with the same title, " (copy)" is added to it.
+func UndoDeleteLineSet(user UserId, set LineSetId) (LineSet, error) {
+    deleted, err := RecentlyDeletedLineSets(user)
+    if err != nil {
+        return LineSet{}, err
+    }
+    var restored *LineSet
+    for i := range deleted {
+        if deleted[i].Id == set {
+            restored = &deleted[i].LineSet
+        }
+    }
+    if restored == nil {
+        return LineSet{}, ErrUndoExpired
+    }
+    title, err := availableTitle(user, restored.Title)
+    if err != nil {
+        return LineSet{}, err
+    }
+
+    err = RestoreLineSet(user, set, time.Now().UTC().Add(-lineSetUndoWindow))
+    if err == sql.ErrNoRows {
+        return LineSet{}, ErrUndoExpired
+    } else if err != nil {
+        return LineSet{}, err
+    }
+    if title != restored.Title {
+        if err := SetLineSetTitle(user, set, title); err != nil {
+            return LineSet{}, err
+        }
+        restored.Title = title
+    }
+    return *restored, nil
+}
+
+// Makes a copy of one of the user's line sets under a new title. The
+// copy starts without stars, notes or review history.
+func DuplicateLineSet(user UserId, set LineSetId) (LineSet, error) {
+    original, err := GetLineSet(user, set)
+    if err != nil {
+        return LineSet{}, err
+    }
+    title, err := availableTitle(user, original.Title)
+    if err != nil {
+        return LineSet{}, err
+    }
+    id, err := CopyLineSet(user, set, "", title)
+    if err != nil {
+        return LineSet{}, err
+    }
+    if err := recordRevision(user, id, 0); err != nil {
+        return LineSet{}, err
+    }
+    return LineSet{Id: id, Title: title, Visibility: VisibilityPrivate}, nil
+}
+
+// Periodically removes line sets that were deleted longer ago than the
+// undo window.
+func sweepDeletedLineSets(interval time.Duration) {
+    for range time.Tick(interval) {
+        purged, err := PurgeDeletedLineSets(time.Now().UTC().Add(-lineSetUndoWindow))
+        if err != nil {
+            debug.Println("[linesets] Error purging deleted line sets:", err)
+            continue
+        }
+        for _, set := range purged {
+            if err := removeLineFile(set.Owner, set.Title); err != nil {
+                debug.Println("[linesets] Error removing line file:", err)
+            }
+        }
+        // Lines can also go when a line set is edited
+        if err := removeOrphanedAudio(); err != nil {
+            debug.Println("[linesets] Error removing audio of deleted lines:", err)
+        }
+    }
+}
+
+// Replaces the tags of a line and returns them. Like stars and notes,
+// tags belong to the actor, so they are not part of the text and are
+// kept when a production's script changes.
+func SetLineTags(set LineSetId, lineNumber int, tags []string) ([]string, error) {
+    if err := linefile.CheckMetadata(linefile.Metadata{Tags: tags}); err != nil {
+        return nil, err
+    }
+    return tags, UpdateLineTags(set, lineNumber, tags)
+}
+
+const (
+    VisibilityPrivate = "private"
+    // Anyone with the share link can view and copy the line set
+    VisibilityUnlisted = "unlisted"
+    // Listed on the browse page
+    VisibilityPublic = "public"
+)
+
+var ErrInvalidVisibility = errors.New("Visibility should be one of private, unlisted or public")
+
+func ParseVisibility(s string) (string, error) {
+    switch s {
+    case VisibilityPrivate, VisibilityUnlisted, VisibilityPublic:
+        return s, nil
+    }
+    return "", ErrInvalidVisibility
+}
+
+// Changes who can see a line set and returns it with its share token.
+func ChangeVisibility(user UserId, set LineSetId, visibility string) (LineSet, error) {
+    visibility, err := ParseVisibility(visibility)
+    if err != nil {
+        return LineSet{}, err
+    }
+    if err := SetLineSetVisibility(user, set, visibility, generateShareToken()); err != nil {
+        return LineSet{}, err
+    }
+    return GetLineSet(user, set)
+}
+
+func generateShareToken() string {
+    randomBytes := make([]byte, 16)
+    rand.Read(randomBytes)
+    return base64.RawURLEncoding.EncodeToString(randomBytes)
+}
+
+// CopyToLibrary forks a line set the user can see into their own
+// library. The copy is private and gets its own stars and notes. If the
+// title is taken, " (copy)" is added to it.
+func CopyToLibrary(user UserId, source LineSetId, shareToken string) (LineSet, error) {
+    original, err := GetVisibleLineSet(user, source, shareToken)
+    if err != nil {
+        return LineSet{}, err
+    }
+    title, err := availableTitle(user, original.Title)
+    if err != nil {
+        return LineSet{}, err
+    }
+    id, err := CopyLineSet(user, source, shareToken, title)
+    if err != nil {
+        return LineSet{}, err
+    }
+    if err := recordRevision(user, id, 0); err != nil {
+        return LineSet{}, err
+    }
+    return LineSet{Id: id, Title: title, Visibility: VisibilityPrivate}, nil
+}
+
+// Returns the title, cleaned up and with " (copy)" added if the user
+// already has a line set by that name.
+func availableTitle(user UserId, title string) (string, error) {
+    title = cleanTitle(title)
+    candidate := title
+    for n := 1; ; n++ {
+        err := checkTitleAvailable(user, candidate)
+        if err == nil {
+            return candidate, nil
+        } else if err != ErrDuplicateTitle {
+            return "", err
+        }
+        if n == 1 {
+            candidate = title + " (copy)"
+        } else {
+            candidate = fmt.Sprintf("%s (copy %d)", title, n)
+        }
+    }
+}
+
+func checkTitleAvailable(user UserId, title string) error {
+    if title == "" {
+        return ErrInvalidTitle
+    }
+    if err := checkTitleCharacters(title); err != nil {
+        return err
+    }
+    if _, err := GetLineSetByTitle(user, title); err == nil {
+        return ErrDuplicateTitle
+    } else if err != sql.ErrNoRows {
+        return err
+    }
+    return nil
+}
+
+// Titles name line files when LineFileDir is set, so they must not be
+// able to reach outside the user's LineSets directory.
+func checkTitleCharacters(title string) error {
+    if strings.ContainsAny(title, `/\`) || strings.Contains(title, "..") {
+        return ErrTitleCharacters
+    }
+    for _, c := range title {
+        if unicode.IsControl(c) {
+            return ErrTitleCharacters
+        }
+    }
+    return nil
+}
+
+// Replaces what checkTitleCharacters rejects, for titles made from
+// other names such as a production's or one given before titles were
+// checked.
+func cleanTitle(title string) string {
+    title = strings.Map(func(c rune) rune {
+        if c == '/' || c == '\\' || unicode.IsControl(c) {
+            return '-'
+        }
+        return c
+    }, title)
+    for strings.Contains(title, "..") {
+        title = strings.ReplaceAll(title, "..", ".")
+    }
+    return title
+}
+
+// ImportLineFiles copies line sets stored by the Lynx command line tool
+// into the database. Line sets were stored in dir/<user>/LineSets/<title>,
+// with the titles listed newest first in dir/<user>/Listing. Users must
+// already exist. Line sets which already have lines in the database are
+// left alone.
+func ImportLineFiles(dir string) error {
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return err
+    }
+    for _, entry := range entries {
+        if !entry.IsDir() {
+            continue
+        }
+        user, err := GetUser(entry.Name())
+        if err == sql.ErrNoRows {
+            debug.Printf("[import] skipping %s: no such user\n", entry.Name())
+            continue
+        } else if err != nil {
+            return err
+        }
+        if err := importUserLineFiles(filepath.Join(dir, entry.Name()), user); err != nil {
+            return fmt.Errorf("importing %s: %w", entry.Name(), err)
+        }
+    }
+    return nil
+}
+
+func importUserLineFiles(userDir string, user User) error {
+    listing, err := readListing(filepath.Join(userDir, "Listing"))
+    if err != nil {
+        return err
+    }
+    // Insert oldest first so that ids keep the listing order
+    for i := len(listing) - 1; i >= 0; i-- {
+        title := listing[i]
+        lines, err := readLineFile(filepath.Join(userDir, "LineSets", title))
+        if err != nil {
+            return fmt.Errorf("%s: %w", title, err)
+        }
+
+        set, err := GetLineSetByTitle(user.Id, title)
+        if err == sql.ErrNoRows {
+            id, err := AddLineSet(user.Id, title, lines)
+            if err != nil {
+                return err
+            }
+            if err := recordRevision(user.Id, id, 0); err != nil {
+                return fmt.Errorf("%s: %w", title, err)
+            }
+            debug.Printf("[import] %s: added %s\n", user.Name, title)
+            continue
+        } else if err != nil {
+            return err
+        }
+
+        existing, err := GetLines(set.Id)
+        if err != nil {
+            return err
+        }
+        if len(existing) > 0 {
+            debug.Printf("[import] %s: skipping %s, already imported\n", user.Name, title)
+            continue
+        }
+        if err := SetLines(set.Id, lines); err != nil {
+            return err
+        }
+        if err := recordRevision(user.Id, set.Id, 0); err != nil {
+            return fmt.Errorf("%s: %w", title, err)
+        }
+        debug.Printf("[import] %s: filled in %s\n", user.Name, title)
+    }
+    return nil
+}
+
+func readLineFile(path string) ([]LineData, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    return linefile.Parse(f)
+}
+
+func readListing(path string) ([]string, error) {
+    f, err := os.Open(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    var titles []string
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        if scanner.Text() != "" {
+            titles = append(titles, scanner.Text())
+        }
+    }
+    return titles, scanner.Err()
+}
+
+// LineFileDir is the data directory of the Lynx command line tool, laid
+// out as described for ImportLineFiles. When set, renaming or purging a
+// line set renames or removes its line file too, so that importing the
+// directory again does not bring back old titles. Empty if line sets
+// are only kept in the database.
+var LineFileDir string
+
+var errLineFileOutside = errors.New("line file path is outside the user's directory")
+
+// Returns the path of a user's line file, checking that neither the
+// user name nor the title leads outside the user's directory.
+func lineFilePath(owner string, title string) (string, error) {
+    userDir := filepath.Join(LineFileDir, owner)
+    if rel, err := filepath.Rel(LineFileDir, userDir); err != nil || rel == "." || !filepath.IsLocal(rel) {
+        return "", errLineFileOutside
+    }
+    path := filepath.Join(userDir, "LineSets", title)
+    if rel, err := filepath.Rel(userDir, path); err != nil || !filepath.IsLocal(rel) {
+        return "", errLineFileOutside
+    }
+    return path, nil
+}
+
+func renameLineFile(user UserId, oldTitle string, newTitle string) error {
+    if LineFileDir == "" || oldTitle == newTitle {
+        return nil
+    }
+    owner, err := GetUserById(user)
+    if err != nil {
+        return err
+    }
+    userDir := filepath.Join(LineFileDir, owner.Name)
+    oldPath, err := lineFilePath(owner.Name, oldTitle)
+    if err != nil {
+        return err
+    }
+    newPath, err := lineFilePath(owner.Name, newTitle)
+    if err != nil {
+        return err
+    }
+    if _, err := os.Stat(oldPath); errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    if err := os.Rename(oldPath, newPath); err != nil {
+        return err
+    }
+    return editListing(filepath.Join(userDir, "Listing"), func(title string) (string, bool) {
+        if title == oldTitle {
+            return newTitle, true
+        }
+        return title, true
+    })
+}
+
+func removeLineFile(owner string, title string) error {
+    if LineFileDir == "" {
+        return nil
+    }
+    userDir := filepath.Join(LineFileDir, owner)
+    path, err := lineFilePath(owner, title)
+    if err != nil {
+        return err
+    }
+    err = os.Remove(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    } else if err != nil {
+        return err
+    }
+    return editListing(filepath.Join(userDir, "Listing"), func(t string) (string, bool) {
+        return t, t != title
+    })
+}
+
+// Rewrites a listing, replacing each title or leaving it out.
+func editListing(path string, edit func(title string) (string, bool)) error {
+    titles, err := readListing(path)
+    if err != nil || titles == nil {
+        return err
+    }
+    var listing strings.Builder
+    for _, title := range titles {
+        if title, keep := edit(title); keep {
+            listing.WriteString(title + "\n")
+        }
+    }
+    return os.WriteFile(path, []byte(listing.String()), 0644)
+}
@@ -0,0 +1,172 @@
+package feline
+
+import (
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// Monologues are learned by breaking a long line into chunks and
+// drilling them progressively. For each chunk the actor first recalls
+// the chunk on its own from a first letter hint, then recites every
+// chunk so far. The drill ends with the whole speech without hints.
+
+const (
+    ChunkBySentence = "sentence"
+    ChunkByPhrase = "phrase"
+)
+
+// Chunks shorter than this many words are merged into the next one.
+const minimumChunkWords = 3
+
+type DrillStep struct {
+    // One of "chunk", "cumulative" or "full"
+    Kind string `json:"kind"`
+    // The chunk being learned, for "chunk" steps
+    Chunk int `json:"chunk"`
+    // Text already learned, shown for context
+    Context string `json:"context"`
+    Hint string `json:"hint"`
+    Answer string `json:"answer"`
+}
+
+type MonologueDrill struct {
+    Line LineData `json:"line"`
+    Role string `json:"role"`
+    Chunks []string `json:"chunks"`
+    Steps []DrillStep `json:"steps"`
+}
+
+// Splits the text of a line into sentences, or into phrases at commas,
+// semicolons, colons and dashes as well.
+func ChunkText(text string, by string) []string {
+    var chunks []string
+    var current strings.Builder
+    words := strings.Fields(text)
+    for i, word := range words {
+        if current.Len() > 0 {
+            current.WriteByte(' ')
+        }
+        current.WriteString(word)
+        if i + 1 < len(words) && endsChunk(word, by) {
+            chunks = append(chunks, current.String())
+            current.Reset()
+        }
+    }
+    if current.Len() > 0 {
+        chunks = append(chunks, current.String())
+    }
+    return mergeShortChunks(chunks)
+}
+
+func endsChunk(word string, by string) bool {
+    word = strings.TrimRight(word, `"')]”’`)
+    if word == "" {
+        return false
+    }
+    switch word[len(word) - 1] {
+    case '.', '!', '?':
+        return true
+    case ',', ';', ':':
+        return by == ChunkByPhrase
+    }
+    return by == ChunkByPhrase && (strings.HasSuffix(word, "-") || strings.HasSuffix(word, "—"))
+}
+
+func mergeShortChunks(chunks []string) []string {
+    var merged []string
+    carry := ""
+    for _, chunk := range chunks {
+        if carry != "" {
+            chunk = carry + " " + chunk
+            carry = ""
+        }
+        if len(strings.Fields(chunk)) < minimumChunkWords {
+            carry = chunk
+            continue
+        }
+        merged = append(merged, chunk)
+    }
+    if carry != "" {
+        if len(merged) > 0 {
+            merged[len(merged) - 1] += " " + carry
+        } else {
+            merged = append(merged, carry)
+        }
+    }
+    return merged
+}
+
+// Replaces every word with its first letter, keeping punctuation:
+// "To be, or not to be" becomes "T b, o n t b".
+func FirstLetters(text string) string {
+    words := strings.Fields(text)
+    for i, word := range words {
+        first := strings.IndexFunc(word, isWordRune)
+        if first < 0 {
+            continue
+        }
+        last := strings.LastIndexFunc(word, isWordRune)
+        _, size := utf8.DecodeRuneInString(word[first:])
+        _, lastSize := utf8.DecodeRuneInString(word[last:])
+        words[i] = word[:first + size] + word[last + lastSize:]
+    }
+    return strings.Join(words, " ")
+}
+
+func isWordRune(c rune) bool {
+    return unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// Builds the progressive drill for a single line.
+func NewMonologueDrill(line LineData, by string) MonologueDrill {
+    role, text, found := strings.Cut(line.Line, ":")
+    if !found {
+        role, text = "", line.Line
+    }
+    chunks := ChunkText(strings.TrimSpace(text), by)
+
+    drill := MonologueDrill{Line: line, Role: role, Chunks: chunks}
+    for i, chunk := range chunks {
+        learned := strings.Join(chunks[:i], " ")
+        drill.Steps = append(drill.Steps, DrillStep{
+            Kind: "chunk",
+            Chunk: i,
+            Context: learned,
+            Hint: FirstLetters(chunk),
+            Answer: chunk,
+        })
+        if i > 0 {
+            sofar := strings.Join(chunks[:i + 1], " ")
+            drill.Steps = append(drill.Steps, DrillStep{
+                Kind: "cumulative",
+                Chunk: i,
+                Hint: FirstLetters(sofar),
+                Answer: sofar,
+            })
+        }
+    }
+    drill.Steps = append(drill.Steps, DrillStep{
+        Kind: "full",
+        Chunk: len(chunks) - 1,
+        Answer: strings.Join(chunks, " "),
+    })
+    return drill
+}
+
+// Builds drills for the lines of a line set that are long enough to be
+// split into chunks. If there are none, every line is drilled.
+func MonologueDrills(lines []LineData, by string) []MonologueDrill {
+    var drills, all []MonologueDrill
+    for _, line := range lines {
+        drill := NewMonologueDrill(line, by)
+        all = append(all, drill)
+        if len(drill.Chunks) > 1 {
+            drills = append(drills, drill)
+        }
+    }
+    if len(drills) == 0 {
+        return all
+    }
+    return drills
+}
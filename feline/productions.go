@@ -0,0 +1,360 @@
+package feline
+
+import (
+    "database/sql"
+    "errors"
+    "sort"
+    "strings"
+    "time"
+)
+
+// A production is a cast working from the same scripts. Directors add
+// their line sets as scripts, and every actor gets their own copy of
+// each script with their own stars, notes and review history. Copies
+// are kept in sync when the director edits a script.
+
+const (
+    RoleDirector = "director"
+    RoleActor = "actor"
+    RoleStageManager = "stage-manager"
+)
+
+const (
+    MemberInvited = "invited"
+    MemberActive = "active"
+)
+
+var (
+    ErrInvalidProductionName = errors.New("Please provide a name for the production.")
+    ErrInvalidRole = errors.New("Role should be one of director, actor or stage-manager")
+    ErrNotDirector = errors.New("Only the director can do that.")
+    ErrAlreadyMember = errors.New("That user is already in the production.")
+    ErrNoSuchUser = errors.New("There is no user with that name.")
+    ErrDuplicateScript = errors.New("That line set is already one of the production's scripts.")
+)
+
+func ParseRole(s string) (string, error) {
+    switch s {
+    case RoleDirector, RoleActor, RoleStageManager:
+        return s, nil
+    }
+    return "", ErrInvalidRole
+}
+
+// Directors and stage managers run the production.
+func (production Production) canManage() bool {
+    return production.Status == MemberActive &&
+        (production.Role == RoleDirector || production.Role == RoleStageManager)
+}
+
+func (production Production) isDirector() bool {
+    return production.Status == MemberActive && production.Role == RoleDirector
+}
+
+func CreateProduction(user UserId, name string) (ProductionId, error) {
+    name = strings.TrimSpace(name)
+    if name == "" {
+        return 0, ErrInvalidProductionName
+    }
+    return AddProduction(user, name)
+}
+
+// Invites a user to the production. Directors and stage managers can
+// invite anyone, but only directors can invite other directors.
+func InviteToProduction(user UserId, id ProductionId, username string, role string) error {
+    production, err := GetProduction(user, id)
+    if err != nil {
+        return err
+    }
+    role, err = ParseRole(role)
+    if err != nil {
+        return err
+    }
+    if !production.canManage() || (role == RoleDirector && !production.isDirector()) {
+        return ErrNotDirector
+    }
+
+    invitee, err := GetUser(strings.TrimSpace(username))
+    if err == sql.ErrNoRows {
+        return ErrNoSuchUser
+    } else if err != nil {
+        return err
+    }
+    if _, err := GetProduction(invitee.Id, id); err == nil {
+        return ErrAlreadyMember
+    } else if err != sql.ErrNoRows {
+        return err
+    }
+    return AddProductionMember(id, invitee.Name, role)
+}
+
+// Accepts or declines an invitation. Actors who accept get their own
+// copy of every script.
+func RespondToInvitation(user UserId, id ProductionId, accept bool) error {
+    production, err := GetProduction(user, id)
+    if err != nil {
+        return err
+    }
+    if !accept {
+        return DeleteProductionMember(id, user)
+    }
+    if err := ActivateProductionMember(id, user); err != nil {
+        return err
+    }
+    if production.Role != RoleActor {
+        return nil
+    }
+    scripts, err := GetProductionScripts(id)
+    if err != nil {
+        return err
+    }
+    // Actors who left and came back keep their old copies
+    copies, err := GetProductionCopies(id)
+    if err != nil {
+        return err
+    }
+    hasCopy := map[LineSetId]bool{}
+    for _, c := range copies {
+        if c.UserId == user {
+            hasCopy[c.Script] = true
+        }
+    }
+    for _, script := range scripts {
+        if hasCopy[script.Id] {
+            continue
+        }
+        if err := giveScriptCopy(production, script, user); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Adds one of the director's line sets to the production and gives
+// every actor a copy.
+func AddScriptToProduction(user UserId, id ProductionId, set LineSetId) error {
+    production, err := GetProduction(user, id)
+    if err != nil {
+        return err
+    }
+    if !production.isDirector() {
+        return ErrNotDirector
+    }
+    script, err := GetLineSet(user, set)
+    if err != nil {
+        return err
+    }
+    scripts, err := GetProductionScripts(id)
+    if err != nil {
+        return err
+    }
+    for _, existing := range scripts {
+        if existing.Id == set {
+            return ErrDuplicateScript
+        }
+    }
+    if err := AddProductionScript(id, user, set); err != nil {
+        return err
+    }
+
+    members, err := GetProductionMembers(id)
+    if err != nil {
+        return err
+    }
+    for _, member := range members {
+        if member.Role == RoleActor && member.Status == MemberActive {
+            if err := giveScriptCopy(production, script, member.UserId); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+func giveScriptCopy(production Production, script LineSet, user UserId) error {
+    title, err := availableTitle(user, production.Name + ": " + script.Title)
+    if err != nil {
+        return err
+    }
+    set, err := AddProductionCopy(production.Id, script.Id, user, title)
+    if err != nil {
+        return err
+    }
+    return recordRevision(user, set, 0)
+}
+
+// Brings the copies of a script kept by current members up to date
+// with the script's text, skipping copies that already match. Each
+// actor keeps their stars, notes, tags and progress on the lines that
+// are still there, and can restore their copy from before the change,
+// which is recorded as a revision by author.
+func SyncScriptCopies(author UserId, script LineSetId) error {
+    copies, err := GetScriptCopies(script)
+    if err != nil || len(copies) == 0 {
+        return err
+    }
+    lines, err := GetLines(script)
+    if err != nil {
+        return err
+    }
+    lines = withoutAnnotations(lines)
+    text := EditableText(lines)
+    for _, set := range copies {
+        current, err := GetLines(set)
+        if err != nil {
+            return err
+        }
+        if EditableText(current) == text {
+            continue
+        }
+        err = withRevision(author, set, 0, func() error {
+            return EditLines(set, lines)
+        })
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+type ActorProgress struct {
+    Actor string `json:"actor"`
+    LineSet LineSetId `json:"line_set_id"`
+    Lines int `json:"lines"`
+    // Lines that have been attempted at least once
+    Practiced int `json:"practiced"`
+    Due int `json:"due"`
+    Attempts int `json:"attempts"`
+    SuccessRate float64 `json:"success_rate"`
+    LastAttempt *time.Time `json:"last_attempt"`
+    // The same for each scene, in script order
+    Scenes []SceneProgress `json:"scenes"`
+}
+
+type SceneProgress struct {
+    SceneRef
+    Lines int `json:"lines"`
+    Practiced int `json:"practiced"`
+    Due int `json:"due"`
+    Attempts int `json:"attempts"`
+    SuccessRate float64 `json:"success_rate"`
+    graded int
+    successes int
+}
+
+type ScriptProgress struct {
+    Script LineSet `json:"script"`
+    Actors []ActorProgress `json:"actors"`
+}
+
+// Summarizes how far each actor has got with each script. Only
+// directors and stage managers can see this.
+func GetProductionProgress(user UserId, id ProductionId) ([]ScriptProgress, error) {
+    production, err := GetProduction(user, id)
+    if err != nil {
+        return nil, err
+    }
+    if !production.canManage() {
+        return nil, ErrNotDirector
+    }
+    scripts, err := GetProductionScripts(id)
+    if err != nil {
+        return nil, err
+    }
+    members, err := GetProductionMembers(id)
+    if err != nil {
+        return nil, err
+    }
+    copies, err := GetProductionCopies(id)
+    if err != nil {
+        return nil, err
+    }
+
+    names := map[UserId]string{}
+    for _, member := range members {
+        names[member.UserId] = member.Name
+    }
+    progress := make([]ScriptProgress, len(scripts))
+    byScript := map[LineSetId]*ScriptProgress{}
+    for i, script := range scripts {
+        progress[i].Script = script
+        byScript[script.Id] = &progress[i]
+    }
+
+    for _, c := range copies {
+        script, exists := byScript[c.Script]
+        name, isMember := names[c.UserId]
+        if !exists || !isMember {
+            continue
+        }
+        actor, err := getActorProgress(c.UserId, c.LineSet)
+        if err != nil {
+            return nil, err
+        }
+        actor.Actor = name
+        script.Actors = append(script.Actors, actor)
+    }
+    for i := range progress {
+        sort.Slice(progress[i].Actors, func(a, b int) bool {
+            return progress[i].Actors[a].Actor < progress[i].Actors[b].Actor
+        })
+    }
+    return progress, nil
+}
+
+func getActorProgress(actor UserId, set LineSetId) (ActorProgress, error) {
+    stats, err := GetLineSetStats(actor, LineSet{Id: set})
+    if err != nil {
+        return ActorProgress{}, err
+    }
+    due, err := GetDueLines(set)
+    if err != nil {
+        return ActorProgress{}, err
+    }
+    isDue := map[int]bool{}
+    for _, line := range due {
+        isDue[line.Id] = true
+    }
+    progress := ActorProgress{
+        LineSet: set,
+        Lines: len(stats.Lines),
+        Due: len(due),
+        Attempts: stats.Attempts,
+        SuccessRate: stats.SuccessRate,
+        Scenes: []SceneProgress{},
+    }
+    byScene := map[SceneRef]int{}
+    for _, line := range stats.Lines {
+        if line.Attempts > 0 {
+            progress.Practiced++
+        }
+        if line.LastAttempt != nil && (progress.LastAttempt == nil || line.LastAttempt.After(*progress.LastAttempt)) {
+            progress.LastAttempt = line.LastAttempt
+        }
+
+        ref := SceneRef{Act: line.Line.Act, Scene: line.Line.Scene}
+        i, exists := byScene[ref]
+        if !exists {
+            i = len(progress.Scenes)
+            byScene[ref] = i
+            progress.Scenes = append(progress.Scenes, SceneProgress{SceneRef: ref})
+        }
+        scene := &progress.Scenes[i]
+        scene.Lines++
+        if line.Attempts > 0 {
+            scene.Practiced++
+        }
+        if isDue[line.Line.Id] {
+            scene.Due++
+        }
+        scene.Attempts += line.Attempts
+        scene.graded += line.Graded
+        scene.successes += line.Successes
+    }
+    for i, scene := range progress.Scenes {
+        if scene.graded > 0 {
+            progress.Scenes[i].SuccessRate = float64(scene.successes) / float64(scene.graded)
+        }
+    }
+    return progress, nil
+}
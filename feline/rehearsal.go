@@ -0,0 +1,192 @@
+package feline
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "io"
+    "net/http"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/ruuzia/lynx/linefile"
+)
+
+// Hands-free rehearsal runs through a scene as a playlist, so actors can
+// run their lines while driving or working on blocking. Each cue is
+// played, then a pause long enough to say the line in, then optionally
+// the line itself so the actor can check what they said. The server
+// decides the pacing; clients only play the items in order.
+
+const (
+    RehearsalCue = "cue"
+    RehearsalPause = "pause"
+    RehearsalLine = "line"
+)
+
+// The pause for a line is rehearsalPauseBase plus rehearsalPausePerWord
+// for each word, a little slower than lines are usually spoken, times
+// the pace.
+const (
+    rehearsalPauseBase = 2 * time.Second
+    rehearsalPausePerWord = 450 * time.Millisecond
+    // Left after a line played for self-check, before the next cue
+    rehearsalGap = time.Second
+    maxRehearsalPause = time.Minute
+)
+
+var (
+    ErrInvalidPace = errors.New("The pace should be between 0.25 and 4.")
+    ErrInvalidPause = errors.New("Pauses are a number of milliseconds up to a minute.")
+)
+
+type RehearsalOptions struct {
+    // Play the actor's line after the pause
+    SelfCheck bool
+    // Multiplies every pause, so 1.5 leaves half as much time again
+    Pace float64
+}
+
+type RehearsalItem struct {
+    // One of "cue", "pause" or "line"
+    Kind string `json:"kind"`
+    // The line the item belongs to
+    Line int `json:"line"`
+    // The words of the cue or line, for clients that show them or read
+    // them aloud themselves. The pause to say a line in has the line.
+    Text string `json:"text,omitempty"`
+    // Whether the server can play the cue or line, as recorded or read
+    // aloud
+    Audio bool `json:"audio"`
+    // How long a pause lasts, in milliseconds
+    Duration int `json:"duration,omitempty"`
+}
+
+type Rehearsal struct {
+    Items []RehearsalItem `json:"items"`
+    // The time spent in pauses, in milliseconds. The length of the cues
+    // and lines is only known once they are played.
+    PauseTotal int `json:"pause_total"`
+}
+
+// Reads ?self_check=true&pace=1.5, with the pace 1 if not given.
+func parseRehearsalOptions(selfCheck string, pace string) (RehearsalOptions, error) {
+    options := RehearsalOptions{Pace: 1}
+    options.SelfCheck, _ = strconv.ParseBool(selfCheck)
+    if pace != "" {
+        var err error
+        options.Pace, err = strconv.ParseFloat(pace, 64)
+        if err != nil {
+            return options, ErrInvalidPace
+        }
+    }
+    if options.Pace < 0.25 || options.Pace > 4 {
+        return options, ErrInvalidPace
+    }
+    return options, nil
+}
+
+// How long the actor is given to say a line.
+func rehearsalPause(line LineData, pace float64) time.Duration {
+    _, words := splitRole(line.Line)
+    pause := rehearsalPauseBase + rehearsalPausePerWord * time.Duration(len(strings.Fields(words)))
+    return min(time.Duration(float64(pause) * pace), maxRehearsalPause)
+}
+
+// Whether a cue or line can be played, from a recording or the
+// synthesizer.
+func canPlay(line LineData, part string) bool {
+    return Synthesizer != nil || slices.Contains(line.Audio, part)
+}
+
+// Builds the playlist for the given lines, in order. The cue before the
+// first line of a scene is left out, leaving only the pause.
+func NewRehearsal(lines []LineData, options RehearsalOptions) Rehearsal {
+    rehearsal := Rehearsal{Items: []RehearsalItem{}}
+    pause := func(line int, text string, d time.Duration) {
+        rehearsal.Items = append(rehearsal.Items, RehearsalItem{Kind: RehearsalPause, Line: line, Text: text, Duration: int(d.Milliseconds())})
+        rehearsal.PauseTotal += int(d.Milliseconds())
+    }
+    for _, line := range lines {
+        if line.Cue != linefile.SceneStartCue {
+            rehearsal.Items = append(rehearsal.Items, RehearsalItem{
+                Kind: RehearsalCue,
+                Line: line.Id,
+                Text: line.Cue,
+                Audio: canPlay(line, AudioCue),
+            })
+        }
+        pause(line.Id, line.Line, rehearsalPause(line, options.Pace))
+        if options.SelfCheck {
+            rehearsal.Items = append(rehearsal.Items, RehearsalItem{
+                Kind: RehearsalLine,
+                Line: line.Id,
+                Text: line.Line,
+                Audio: canPlay(line, AudioLine),
+            })
+            pause(line.Id, "", rehearsalGap)
+        }
+    }
+    return rehearsal
+}
+
+// Writes a WAV file of silence, which clients play for pauses so that
+// the playlist keeps going when the screen is locked.
+func writeSilence(w io.Writer, d time.Duration) error {
+    const sampleRate = 8000
+    d = max(0, min(d, maxRehearsalPause))
+    samples := uint32(d * sampleRate / time.Second)
+    header := struct {
+        Riff [4]byte
+        Size uint32
+        Wave [4]byte
+        Fmt [4]byte
+        FmtSize uint32
+        Format uint16
+        Channels uint16
+        SampleRate uint32
+        ByteRate uint32
+        BlockAlign uint16
+        BitsPerSample uint16
+        Data [4]byte
+        DataSize uint32
+    }{
+        [4]byte{'R', 'I', 'F', 'F'}, 36 + samples, [4]byte{'W', 'A', 'V', 'E'},
+        [4]byte{'f', 'm', 't', ' '}, 16, 1, 1, sampleRate, sampleRate, 1, 8,
+        [4]byte{'d', 'a', 't', 'a'}, samples,
+    }
+    if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+        return err
+    }
+    // 8-bit samples are unsigned, so silence is the middle value
+    _, err := w.Write([]byte(strings.Repeat("\x80", int(samples))))
+    return err
+}
+
+// Reads the length of a pause from ?ms=.
+func parseSilence(ms string) (time.Duration, error) {
+    n, err := strconv.Atoi(ms)
+    if err != nil || n < 0 || time.Duration(n) * time.Millisecond > maxRehearsalPause {
+        return 0, ErrInvalidPause
+    }
+    return time.Duration(n) * time.Millisecond, nil
+}
+
+// Responds with a pause of the length given by ?ms=. The same pause is
+// always the same file, so it can be cached for good.
+func serveSilence(w http.ResponseWriter, r *http.Request) error {
+    d, err := parseSilence(r.FormValue("ms"))
+    if err != nil {
+        return err
+    }
+    var wav bytes.Buffer
+    if err := writeSilence(&wav, d); err != nil {
+        return err
+    }
+    w.Header().Set("Content-Type", "audio/wav")
+    w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+    http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(wav.Bytes()))
+    return nil
+}
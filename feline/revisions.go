@@ -0,0 +1,185 @@
+package feline
+
+import (
+    "database/sql"
+    "fmt"
+
+    "github.com/ruuzia/lynx/linefile"
+)
+
+// Every save of a line set's text is kept as a revision, so that
+// changes made through rehearsals can be looked back on and undone.
+// Revisions store the text without stars, notes and tags, which stay
+// with the lines themselves. Line sets made before revisions were kept get
+// their first revision the first time they are edited.
+
+// How one line differs between two versions of a line set.
+type LineChange struct {
+    // One of "same", "changed", "added" or "removed"
+    Op string `json:"op"`
+    Old *LineData `json:"old,omitempty"`
+    New *LineData `json:"new,omitempty"`
+}
+
+// Lists the changes from old to lines in the order of the new lines,
+// with removed lines where they used to be.
+func diffLines(old []LineData, lines []LineData) []LineChange {
+    previous := matchLines(old, lines)
+    taken := make([]bool, len(old))
+    for _, i := range previous {
+        if i >= 0 {
+            taken[i] = true
+        }
+    }
+
+    var changes []LineChange
+    next := 0
+    removedBefore := func(end int) {
+        for ; next < end; next++ {
+            if !taken[next] {
+                changes = append(changes, LineChange{Op: "removed", Old: &old[next]})
+            }
+        }
+    }
+    for j := range lines {
+        i := previous[j]
+        if i < 0 {
+            changes = append(changes, LineChange{Op: "added", New: &lines[j]})
+            continue
+        }
+        removedBefore(i)
+        next = max(next, i + 1)
+        op := "changed"
+        if sameText(old[i], lines[j]) {
+            op = "same"
+        }
+        changes = append(changes, LineChange{Op: op, Old: &old[i], New: &lines[j]})
+    }
+    removedBefore(len(old))
+    return changes
+}
+
+func sameText(a LineData, b LineData) bool {
+    return linefile.Format([]LineData{a}) == linefile.Format([]LineData{b})
+}
+
+func summarizeChanges(changes []LineChange) (added int, removed int, changed int) {
+    for _, change := range changes {
+        switch change.Op {
+        case "added":
+            added++
+        case "removed":
+            removed++
+        case "changed":
+            changed++
+        }
+    }
+    return
+}
+
+// Lines of a revision's text.
+func revisionLines(revision Revision) ([]LineData, error) {
+    lines, err := linefile.ParseString(revision.Text)
+    if err != nil {
+        return nil, fmt.Errorf("revision %d: %w", revision.Id, err)
+    }
+    return lines, nil
+}
+
+// Saves the current text of a line set as a new revision, unless it
+// has not changed since the last one. Text that would not parse back
+// into the same lines is never saved, since restoring it would lose
+// them.
+func recordRevision(author UserId, set LineSetId, restoredFrom RevisionId) error {
+    lines, err := GetLines(set)
+    if err != nil {
+        return err
+    }
+    revision := Revision{Text: EditableText(lines), RestoredFrom: restoredFrom}
+    if _, err := linefile.ParseString(revision.Text); err != nil {
+        return err
+    }
+    var old []LineData
+    latest, err := GetRevisionBefore(set, 0)
+    if err == nil {
+        if latest.Text == revision.Text {
+            return nil
+        }
+        old, err = revisionLines(latest)
+        if err != nil {
+            return err
+        }
+    } else if err != sql.ErrNoRows {
+        return err
+    }
+    revision.Added, revision.Removed, revision.Changed = summarizeChanges(diffLines(old, lines))
+    _, err = AddRevision(set, author, revision)
+    return err
+}
+
+// Runs an edit of a line set's text and records it as a revision.
+// Line sets without any revisions first get one for the text from
+// before the edit.
+func withRevision(author UserId, set LineSetId, restoredFrom RevisionId, edit func() error) error {
+    _, err := GetRevisionBefore(set, 0)
+    if err == sql.ErrNoRows {
+        err = recordRevision(author, set, 0)
+    }
+    if err != nil {
+        return err
+    }
+    if err := edit(); err != nil {
+        return err
+    }
+    return recordRevision(author, set, restoredFrom)
+}
+
+// A revision with the changes it made to the one before it.
+type RevisionDiff struct {
+    Revision Revision `json:"revision"`
+    Changes []LineChange `json:"changes"`
+}
+
+func GetRevisionDiff(set LineSetId, id RevisionId) (RevisionDiff, error) {
+    revision, err := GetRevision(set, id)
+    if err != nil {
+        return RevisionDiff{}, err
+    }
+    var old []LineData
+    previous, err := GetRevisionBefore(set, id)
+    if err == nil {
+        old, err = revisionLines(previous)
+    }
+    if err != nil && err != sql.ErrNoRows {
+        return RevisionDiff{}, err
+    }
+    lines, err := revisionLines(revision)
+    if err != nil {
+        return RevisionDiff{}, err
+    }
+    changes := diffLines(old, lines)
+    if changes == nil {
+        changes = []LineChange{}
+    }
+    return RevisionDiff{Revision: revision, Changes: changes}, nil
+}
+
+// Brings back the text of an older revision as a new revision. Stars,
+// notes and review history stay with the lines that match.
+func RestoreRevision(user UserId, set LineSetId, id RevisionId) error {
+    revision, err := GetRevision(set, id)
+    if err != nil {
+        return err
+    }
+    lines, err := revisionLines(revision)
+    if err != nil {
+        return err
+    }
+    err = withRevision(user, set, id, func() error {
+        return EditLines(set, lines)
+    })
+    if err != nil {
+        return err
+    }
+    return SyncScriptCopies(user, set)
+}
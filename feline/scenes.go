@@ -0,0 +1,72 @@
+package feline
+
+import (
+    "errors"
+    "strconv"
+    "strings"
+
+    "github.com/ruuzia/lynx/linefile"
+)
+
+// Review sessions can be restricted to some of the scenes of a line
+// set. Scenes are named by their act and scene headings, either of
+// which may be empty.
+
+type SceneRef struct {
+    Act string `json:"act"`
+    Scene string `json:"scene"`
+}
+
+var ErrInvalidScene = errors.New("Each act should be given with a scene")
+
+// Keeps the lines in any of the given scenes. No scenes means every
+// line is kept.
+func filterScenes(lines []LineData, scenes []SceneRef) []LineData {
+    if len(scenes) == 0 {
+        return lines
+    }
+    selected := map[SceneRef]bool{}
+    for _, scene := range scenes {
+        selected[scene] = true
+    }
+    var filtered []LineData
+    for _, line := range lines {
+        if selected[SceneRef{Act: line.Act, Scene: line.Scene}] {
+            filtered = append(filtered, line)
+        }
+    }
+    return filtered
+}
+
+// Reads scenes from repeated act and scene parameters, which are
+// paired up in order.
+func parseSceneRefs(acts []string, scenes []string) ([]SceneRef, error) {
+    if len(acts) != len(scenes) {
+        return nil, ErrInvalidScene
+    }
+    refs := make([]SceneRef, len(acts))
+    for i := range acts {
+        refs[i] = SceneRef{Act: acts[i], Scene: scenes[i]}
+    }
+    return refs, nil
+}
+
+// Looks up scenes by their position in the outline of a line set,
+// written "act.scene" with both counting from 0. Used by forms, where
+// the titles themselves could be mangled.
+func scenesAt(outline []linefile.Act, positions []string) ([]SceneRef, error) {
+    var refs []SceneRef
+    for _, position := range positions {
+        a, s, found := strings.Cut(position, ".")
+        i, err := strconv.Atoi(a)
+        if err != nil || !found || i < 0 || i >= len(outline) {
+            return nil, ErrInvalidScene
+        }
+        j, err := strconv.Atoi(s)
+        if err != nil || j < 0 || j >= len(outline[i].Scenes) {
+            return nil, ErrInvalidScene
+        }
+        refs = append(refs, SceneRef{Act: outline[i].Title, Scene: outline[i].Scenes[j].Title})
+    }
+    return refs, nil
+}
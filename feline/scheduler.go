@@ -0,0 +1,146 @@
+package feline
+
+import (
+    "errors"
+    "math"
+    "time"
+)
+
+// Lines are scheduled for review with a variant of the SM-2 spaced
+// repetition algorithm. Each graded recall updates the line's ease
+// factor and the interval until it is next due.
+
+type Grade int
+
+const (
+    GradeAgain Grade = iota
+    GradeHard
+    GradeGood
+    GradeEasy
+)
+
+var gradeNames = []string{"again", "hard", "good", "easy"}
+
+var ErrInvalidGrade = errors.New("Grade should be one of again, hard, good or easy")
+
+func ParseGrade(s string) (Grade, error) {
+    for i, name := range gradeNames {
+        if s == name {
+            return Grade(i), nil
+        }
+    }
+    return 0, ErrInvalidGrade
+}
+
+func (g Grade) String() string {
+    if g < GradeAgain || g > GradeEasy {
+        return "invalid"
+    }
+    return gradeNames[g]
+}
+
+const (
+    initialEase = 2.5
+    minimumEase = 1.3
+    // Lines that were forgotten come back later in the same session
+    relearnDelay = 10 * time.Minute
+)
+
+// ReviewState is the spaced repetition schedule of a single line.
+type ReviewState struct {
+    Ease float64 `json:"ease"`
+    IntervalDays int `json:"interval_days"`
+    Repetitions int `json:"repetitions"`
+    Due time.Time `json:"due"`
+}
+
+// The state of a line that has never been reviewed. It is due
+// immediately.
+func NewReviewState() ReviewState {
+    return ReviewState{Ease: initialEase}
+}
+
+func (state ReviewState) IsDue(now time.Time) bool {
+    return !state.Due.After(now)
+}
+
+// Returns the state after recalling the line with the given grade.
+func (state ReviewState) Schedule(grade Grade, now time.Time) ReviewState {
+    // SM-2 quality from 0 to 5, where anything below 3 is a failure
+    quality := map[Grade]float64{
+        GradeAgain: 1,
+        GradeHard: 3,
+        GradeGood: 4,
+        GradeEasy: 5,
+    }[grade]
+
+    next := state
+    next.Ease += 0.1 - (5 - quality) * (0.08 + (5 - quality) * 0.02)
+    if next.Ease < minimumEase {
+        next.Ease = minimumEase
+    }
+
+    if grade == GradeAgain {
+        next.Repetitions = 0
+        next.IntervalDays = 0
+        next.Due = now.Add(relearnDelay)
+        return next
+    }
+
+    next.Repetitions++
+    switch next.Repetitions {
+    case 1:
+        next.IntervalDays = 1
+    case 2:
+        next.IntervalDays = 6
+    default:
+        interval := float64(state.IntervalDays) * next.Ease
+        switch grade {
+        case GradeHard:
+            interval = float64(state.IntervalDays) * 1.2
+        case GradeEasy:
+            interval *= 1.3
+        }
+        next.IntervalDays = int(math.Round(interval))
+    }
+    if next.IntervalDays <= state.IntervalDays {
+        next.IntervalDays = state.IntervalDays + 1
+    }
+    next.Due = now.AddDate(0, 0, next.IntervalDays)
+    return next
+}
+
+// Records a graded recall of a line and returns its new schedule.
+func GradeLine(set LineSetId, lineNumber int, grade Grade) (ReviewState, error) {
+    states, err := GetReviewStates(set)
+    if err != nil {
+        return ReviewState{}, err
+    }
+    state, exists := states[lineNumber]
+    if !exists {
+        state = NewReviewState()
+    }
+    state = state.Schedule(grade, time.Now().UTC())
+    return state, SaveReviewState(set, lineNumber, state)
+}
+
+// Returns the lines that are due for review, in script order. Lines
+// that have never been reviewed are always due.
+func GetDueLines(set LineSetId) ([]LineData, error) {
+    lines, err := GetLines(set)
+    if err != nil {
+        return nil, err
+    }
+    states, err := GetReviewStates(set)
+    if err != nil {
+        return nil, err
+    }
+    now := time.Now().UTC()
+    var due []LineData
+    for _, line := range lines {
+        if state, exists := states[line.Id]; !exists || state.IsDue(now) {
+            due = append(due, line)
+        }
+    }
+    return due, nil
+}
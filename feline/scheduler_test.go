@@ -0,0 +1,85 @@
+package feline
+
+import (
+    "math"
+    "testing"
+    "time"
+)
+
+var scheduleNow = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestSchedule(t *testing.T) {
+    learned := ReviewState{Ease: 2.5, IntervalDays: 10, Repetitions: 3}
+    tests := []struct {
+        name string
+        state ReviewState
+        grade Grade
+        want ReviewState
+    }{
+        {"new again", NewReviewState(), GradeAgain, ReviewState{Ease: 1.96, Due: scheduleNow.Add(relearnDelay)}},
+        {"new hard", NewReviewState(), GradeHard, ReviewState{Ease: 2.36, IntervalDays: 1, Repetitions: 1, Due: scheduleNow.AddDate(0, 0, 1)}},
+        {"new good", NewReviewState(), GradeGood, ReviewState{Ease: 2.5, IntervalDays: 1, Repetitions: 1, Due: scheduleNow.AddDate(0, 0, 1)}},
+        {"new easy", NewReviewState(), GradeEasy, ReviewState{Ease: 2.6, IntervalDays: 1, Repetitions: 1, Due: scheduleNow.AddDate(0, 0, 1)}},
+        {"second good", ReviewState{Ease: 2.5, IntervalDays: 1, Repetitions: 1}, GradeGood, ReviewState{Ease: 2.5, IntervalDays: 6, Repetitions: 2, Due: scheduleNow.AddDate(0, 0, 6)}},
+        {"learned again", learned, GradeAgain, ReviewState{Ease: 1.96, Due: scheduleNow.Add(relearnDelay)}},
+        {"learned hard", learned, GradeHard, ReviewState{Ease: 2.36, IntervalDays: 12, Repetitions: 4, Due: scheduleNow.AddDate(0, 0, 12)}},
+        {"learned good", learned, GradeGood, ReviewState{Ease: 2.5, IntervalDays: 25, Repetitions: 4, Due: scheduleNow.AddDate(0, 0, 25)}},
+        {"learned easy", learned, GradeEasy, ReviewState{Ease: 2.6, IntervalDays: 34, Repetitions: 4, Due: scheduleNow.AddDate(0, 0, 34)}},
+        {"ease floor", ReviewState{Ease: 1.4, IntervalDays: 10, Repetitions: 3}, GradeHard, ReviewState{Ease: minimumEase, IntervalDays: 12, Repetitions: 4, Due: scheduleNow.AddDate(0, 0, 12)}},
+        {"ease floor again", ReviewState{Ease: minimumEase, IntervalDays: 10, Repetitions: 3}, GradeAgain, ReviewState{Ease: minimumEase, Due: scheduleNow.Add(relearnDelay)}},
+        // 2 days times 1.2 rounds to 2, but a passed review always waits longer
+        {"interval grows", ReviewState{Ease: minimumEase, IntervalDays: 2, Repetitions: 3}, GradeHard, ReviewState{Ease: minimumEase, IntervalDays: 3, Repetitions: 4, Due: scheduleNow.AddDate(0, 0, 3)}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            got := test.state.Schedule(test.grade, scheduleNow)
+            if math.Abs(got.Ease - test.want.Ease) > 1e-9 {
+                t.Errorf("ease = %v, want %v", got.Ease, test.want.Ease)
+            }
+            got.Ease = test.want.Ease
+            if got != test.want {
+                t.Errorf("Schedule = %+v, want %+v", got, test.want)
+            }
+        })
+    }
+}
+
+// Forgetting a learned line starts its intervals again from a day,
+// keeping the lower ease.
+func TestScheduleLapse(t *testing.T) {
+    state := ReviewState{Ease: 2.5, IntervalDays: 30, Repetitions: 5}
+    now := scheduleNow
+    state = state.Schedule(GradeAgain, now)
+    if state.Repetitions != 0 || state.IntervalDays != 0 || !state.Due.Equal(now.Add(relearnDelay)) {
+        t.Fatalf("after lapse = %+v", state)
+    }
+    if state.IsDue(now) || !state.IsDue(now.Add(relearnDelay)) {
+        t.Errorf("after lapse due at %v, want %v", state.Due, now.Add(relearnDelay))
+    }
+
+    for _, wantDays := range []int{1, 6, 12} {
+        now = state.Due
+        state = state.Schedule(GradeGood, now)
+        if state.IntervalDays != wantDays || !state.Due.Equal(now.AddDate(0, 0, wantDays)) {
+            t.Errorf("relearning interval = %d days due %v, want %d", state.IntervalDays, state.Due, wantDays)
+        }
+        if math.Abs(state.Ease - 1.96) > 1e-9 {
+            t.Errorf("relearning ease = %v, want 1.96", state.Ease)
+        }
+    }
+}
+
+func TestParseGrade(t *testing.T) {
+    for grade := GradeAgain; grade <= GradeEasy; grade++ {
+        parsed, err := ParseGrade(grade.String())
+        if err != nil || parsed != grade {
+            t.Errorf("ParseGrade(%q) = %v, %v", grade.String(), parsed, err)
+        }
+    }
+    if _, err := ParseGrade("perfect"); err != ErrInvalidGrade {
+        t.Errorf("ParseGrade(\"perfect\") = %v, want ErrInvalidGrade", err)
+    }
+    if s := Grade(7).String(); s != "invalid" {
+        t.Errorf("Grade(7).String() = %q", s)
+    }
+}
@@ -0,0 +1,406 @@
+package feline
+
+import (
+    "errors"
+    "strings"
+    "unicode"
+)
+
+// Typed answers are scored by a word level diff against the line.
+// Case and punctuation are ignored, contractions are expanded so that
+// "don't" matches "do not", and a single typo in a longer word or a
+// missing apostrophe, like "its" for "it's", still counts as a match.
+// The diff shows the words as they were written.
+
+// Answers are scored against a table of every word of the line by
+// every word typed, so answers much longer than the line are refused.
+const (
+    maxAnswerBytes = 64 << 10
+    maxAnswerRatio = 4
+    // Lets short lines be answered with a few words too many
+    maxAnswerSlack = 20
+)
+
+var ErrAnswerTooLong = errors.New("That answer is much longer than the line.")
+
+type DiffOp struct {
+    // One of "equal", "typo", "missing" or "extra"
+    Op string `json:"op"`
+    // The word from the line, or from the answer for "extra" words
+    Text string `json:"text"`
+    // What was typed instead, for "typo" words
+    Typed string `json:"typed,omitempty"`
+}
+
+type AnswerScore struct {
+    // Matched words divided by the words in the line plus any extra
+    // words typed, from 0 to 1
+    Accuracy float64 `json:"accuracy"`
+    Diff []DiffOp `json:"diff"`
+    // The recall grade this accuracy corresponds to
+    Grade string `json:"grade"`
+}
+
+type scoredWord struct {
+    // Lowercase without punctuation or apostrophes, for matching
+    normal string
+    // Lowercase with its apostrophes, to tell a slip like "its" for
+    // "it's" from an exact match
+    spelled string
+    // The word as written, or its part of a contraction
+    display string
+    // The word as written and the number of parts it was scored as
+    written string
+    parts int
+    // Which written word this came from
+    word int
+}
+
+var contractions = map[string][]string{
+    "can't": {"can", "not"},
+    "won't": {"will", "not"},
+    "shan't": {"shall", "not"},
+    "ain't": {"am", "not"},
+    "let's": {"let", "us"},
+    "it's": {"it", "is"},
+    "that's": {"that", "is"},
+    "what's": {"what", "is"},
+    "where's": {"where", "is"},
+    "who's": {"who", "is"},
+    "there's": {"there", "is"},
+    "here's": {"here", "is"},
+    "he's": {"he", "is"},
+    "she's": {"she", "is"},
+    "'tis": {"it", "is"},
+    "'twas": {"it", "was"},
+}
+
+var contractionSuffixes = []struct {
+    suffix string
+    word string
+}{
+    {"n't", "not"},
+    {"'re", "are"},
+    {"'ll", "will"},
+    {"'ve", "have"},
+    {"'m", "am"},
+    {"'d", "would"},
+}
+
+// A word as written, with the lowercase spelling it is scored by.
+type writtenWord struct {
+    text string
+    spelled string
+}
+
+func writtenWords(text string) []writtenWord {
+    var words []writtenWord
+    for _, word := range strings.Fields(text) {
+        spelled := strings.NewReplacer("’", "'", "‘", "'").Replace(strings.ToLower(word))
+        spelled = strings.TrimFunc(spelled, func(c rune) bool {
+            return !isWordRune(c) && c != '\''
+        })
+        // Drops quote marks, but not the apostrophe of "'tis"
+        if _, exists := contractions[spelled]; !exists {
+            spelled = strings.Trim(spelled, "'")
+        }
+        words = append(words, writtenWord{text: word, spelled: spelled})
+    }
+    return words
+}
+
+func withoutApostrophes(word string) string {
+    return strings.ReplaceAll(word, "'", "")
+}
+
+// Words spelled differently only by apostrophes in the line and the
+// answer, like "its" and "it's". These are kept whole rather than
+// expanded so that they match as a typo.
+func apostropheSlips(line []writtenWord, answer []writtenWord) map[string]bool {
+    spellings := make(map[string]string)
+    slips := make(map[string]bool)
+    for _, word := range append(line[:len(line):len(line)], answer...) {
+        bare := withoutApostrophes(word.spelled)
+        if spelled, exists := spellings[bare]; exists && spelled != word.spelled {
+            slips[bare] = true
+        }
+        spellings[bare] = word.spelled
+    }
+    return slips
+}
+
+// Splits text into lowercase words without punctuation, expanding
+// contractions.
+func scoringWords(text string) []scoredWord {
+    return expandWords(writtenWords(text), nil)
+}
+
+// Expands contractions, except for the words in keep.
+func expandWords(written []writtenWord, keep map[string]bool) []scoredWord {
+    var words []scoredWord
+    for w, word := range written {
+        expanded := []string{word.spelled}
+        if !keep[withoutApostrophes(word.spelled)] {
+            expanded = expandContraction(word.spelled)
+        }
+        var parts []scoredWord
+        for i, part := range expanded {
+            normal := strings.Map(func(c rune) rune {
+                if isWordRune(c) {
+                    return unicode.ToLower(c)
+                }
+                return -1
+            }, part)
+            if normal == "" {
+                continue
+            }
+            display := word.text
+            if len(expanded) > 1 {
+                display = matchCase(part, word.text, i == 0)
+            }
+            parts = append(parts, scoredWord{normal: normal, spelled: part, display: display, written: word.text, word: w})
+        }
+        for i := range parts {
+            parts[i].parts = len(parts)
+        }
+        words = append(words, parts...)
+    }
+    return words
+}
+
+// Gives part of an expanded contraction the case it was written in, so
+// "I'm" is shown as "I am" and "DON'T" as "DO NOT".
+func matchCase(part string, written string, first bool) string {
+    letters := strings.Map(func(c rune) rune {
+        if unicode.IsLetter(c) {
+            return c
+        }
+        return -1
+    }, written)
+    runes := []rune(letters)
+    switch {
+    case len(runes) > 1 && strings.ToUpper(letters) == letters:
+        return strings.ToUpper(part)
+    case first && len(runes) > 0 && unicode.IsUpper(runes[0]):
+        partRunes := []rune(part)
+        partRunes[0] = unicode.ToUpper(partRunes[0])
+        return string(partRunes)
+    }
+    return part
+}
+
+// Words that are often typed as contractions without the apostrophe,
+// like "dont"
+var notContractionStems = map[string]bool{
+    "is": true, "are": true, "was": true, "were": true,
+    "do": true, "does": true, "did": true,
+    "have": true, "has": true, "had": true,
+    "could": true, "would": true, "should": true, "must": true, "need": true,
+}
+
+func expandContraction(word string) []string {
+    if expanded, exists := contractions[word]; exists {
+        return expanded
+    }
+    if stem, found := strings.CutSuffix(word, "nt"); found && !strings.Contains(word, "'") {
+        if expanded, exists := contractions[stem + "n't"]; exists {
+            return expanded
+        }
+        if notContractionStems[stem] {
+            return []string{stem, "not"}
+        }
+    }
+    for _, c := range contractionSuffixes {
+        if strings.HasSuffix(word, c.suffix) && len(word) > len(c.suffix) {
+            return []string{strings.TrimSuffix(word, c.suffix), c.word}
+        }
+    }
+    return []string{word}
+}
+
+// Removes a leading "ROLE:" if the text has one.
+func stripRole(text string) string {
+    _, rest := splitRole(text)
+    return rest
+}
+
+// Splits "ROLE: text" into the role and the text. The role is empty if
+// the text does not start with one.
+func splitRole(text string) (string, string) {
+    if role, rest, found := strings.Cut(text, ":"); found && isRoleName(role) {
+        return role, strings.TrimSpace(rest)
+    }
+    return "", text
+}
+
+func isRoleName(s string) bool {
+    if s == "" {
+        return false
+    }
+    for _, c := range s {
+        if !unicode.IsLetter(c) && c != '/' {
+            return false
+        }
+    }
+    return true
+}
+
+// Whether two words match, and if so whether only as a typo: a
+// difference in apostrophes, or a single edit in a longer word.
+func wordsMatch(a scoredWord, b scoredWord) (bool, bool) {
+    if a.normal == b.normal {
+        return true, a.spelled != b.spelled
+    }
+    if len(a.normal) >= 4 && len(b.normal) >= 4 && editDistance(a.normal, b.normal) <= 1 {
+        return true, true
+    }
+    return false, false
+}
+
+// ScoreAnswer compares a typed answer against the text of a line.
+func ScoreAnswer(line string, answer string) (AnswerScore, error) {
+    lineWords, answerWords := writtenWords(stripRole(line)), writtenWords(stripRole(answer))
+    slips := apostropheSlips(lineWords, answerWords)
+    expected, typed := expandWords(lineWords, slips), expandWords(answerWords, slips)
+    if len(typed) > maxAnswerRatio * len(expected) + maxAnswerSlack {
+        return AnswerScore{}, ErrAnswerTooLong
+    }
+
+    // Longest common subsequence of matching words
+    n, m := len(expected), len(typed)
+    table := make([][]int, n + 1)
+    for i := range table {
+        table[i] = make([]int, m + 1)
+    }
+    for i := n - 1; i >= 0; i-- {
+        for j := m - 1; j >= 0; j-- {
+            if match, _ := wordsMatch(expected[i], typed[j]); match {
+                table[i][j] = table[i + 1][j + 1] + 1
+            } else {
+                table[i][j] = max(table[i + 1][j], table[i][j + 1])
+            }
+        }
+    }
+
+    var score AnswerScore
+    // The parts of a contraction in a row with the same result are shown
+    // as the word that was written
+    run := 0
+    var previous scoredWord
+    add := func(op DiffOp, word scoredWord) {
+        last := len(score.Diff) - 1
+        if run > 0 && op.Op != "typo" && score.Diff[last].Op == op.Op && word.word == previous.word {
+            run++
+        } else if op.Op != "typo" {
+            run = 1
+        } else {
+            run = 0
+        }
+        previous = word
+        score.Diff = append(score.Diff, op)
+        if run > 1 && run == word.parts {
+            score.Diff = append(score.Diff[:len(score.Diff) - run], DiffOp{Op: op.Op, Text: word.written})
+            run = 0
+        }
+    }
+    matches, extras := 0, 0
+    i, j := 0, 0
+    for i < n || j < m {
+        switch {
+        case i < n && j < m:
+            if match, typo := wordsMatch(expected[i], typed[j]); match && table[i][j] == table[i + 1][j + 1] + 1 {
+                if typo {
+                    add(DiffOp{Op: "typo", Text: expected[i].display, Typed: typed[j].display}, expected[i])
+                } else {
+                    add(DiffOp{Op: "equal", Text: expected[i].display}, expected[i])
+                }
+                matches++
+                i++
+                j++
+            } else if table[i + 1][j] >= table[i][j + 1] {
+                add(DiffOp{Op: "missing", Text: expected[i].display}, expected[i])
+                i++
+            } else {
+                add(DiffOp{Op: "extra", Text: typed[j].display}, typed[j])
+                extras++
+                j++
+            }
+        case i < n:
+            add(DiffOp{Op: "missing", Text: expected[i].display}, expected[i])
+            i++
+        default:
+            add(DiffOp{Op: "extra", Text: typed[j].display}, typed[j])
+            extras++
+            j++
+        }
+    }
+
+    if n + extras > 0 {
+        score.Accuracy = float64(matches) / float64(n + extras)
+    }
+    score.Grade = GradeForAccuracy(score.Accuracy).String()
+    return score, nil
+}
+
+// Scores a typed answer to a line and records it as a graded attempt.
+func CheckAnswer(user UserId, set LineSetId, lineNumber int, answer string, reviewMethod string, revealMs int, hintsUsed int) (AnswerScore, *ReviewState, error) {
+    line, err := GetLine(set, lineNumber)
+    if err != nil {
+        return AnswerScore{}, nil, err
+    }
+    score, err := ScoreAnswer(line.Line, answer)
+    if err != nil {
+        return AnswerScore{}, nil, err
+    }
+    grade := GradeForAccuracy(score.Accuracy).WithHints(hintsUsed)
+    score.Grade = grade.String()
+    state, err := RecordReview(user, set, Attempt{
+        Line: lineNumber,
+        ReviewMethod: reviewMethod,
+        Graded: true,
+        Grade: GradeForAccuracy(score.Accuracy),
+        RevealMs: revealMs,
+        HintsUsed: hintsUsed,
+    })
+    return score, state, err
+}
+
+func GradeForAccuracy(accuracy float64) Grade {
+    switch {
+    case accuracy >= 0.98:
+        return GradeEasy
+    case accuracy >= 0.9:
+        return GradeGood
+    case accuracy >= 0.7:
+        return GradeHard
+    default:
+        return GradeAgain
+    }
+}
+
+// Edit distance between two words, where swapping two adjacent letters
+// counts as a single edit.
+func editDistance(a string, b string) int {
+    s, t := []rune(a), []rune(b)
+    d := make([][]int, len(s) + 1)
+    for i := range d {
+        d[i] = make([]int, len(t) + 1)
+        d[i][0] = i
+    }
+    for j := range d[0] {
+        d[0][j] = j
+    }
+    for i := 1; i <= len(s); i++ {
+        for j := 1; j <= len(t); j++ {
+            cost := 1
+            if s[i - 1] == t[j - 1] {
+                cost = 0
+            }
+            d[i][j] = min(d[i - 1][j] + 1, d[i][j - 1] + 1, d[i - 1][j - 1] + cost)
+            if i > 1 && j > 1 && s[i - 1] == t[j - 2] && s[i - 2] == t[j - 1] {
+                d[i][j] = min(d[i][j], d[i - 2][j - 2] + 1)
+            }
+        }
+    }
+    return d[len(s)][len(t)]
+}
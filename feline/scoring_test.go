@@ -0,0 +1,179 @@
+package feline
+
+import (
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestScoreAnswer(t *testing.T) {
+    tests := []struct {
+        name string
+        line string
+        answer string
+        accuracy float64
+        grade string
+        diff []DiffOp
+    }{
+        {
+            name: "exact",
+            line: "POCO: That is the question.",
+            answer: "that is the question",
+            accuracy: 1,
+            grade: "easy",
+            diff: []DiffOp{{"equal", "That", ""}, {"equal", "is", ""}, {"equal", "the", ""}, {"equal", "question.", ""}},
+        },
+        {
+            name: "role typed",
+            line: "POCO: Hello.",
+            answer: "POCO: hello",
+            accuracy: 1,
+            grade: "easy",
+            diff: []DiffOp{{"equal", "Hello.", ""}},
+        },
+        {
+            name: "missing and extra",
+            line: "To be or not to be",
+            answer: "To be or to be really",
+            accuracy: 5.0 / 7,
+            grade: "hard",
+            diff: []DiffOp{
+                {"equal", "To", ""}, {"equal", "be", ""}, {"equal", "or", ""}, {"missing", "not", ""},
+                {"equal", "to", ""}, {"equal", "be", ""}, {"extra", "really", ""},
+            },
+        },
+        {
+            name: "typo in a long word",
+            line: "Through yonder window",
+            answer: "through yodner window",
+            accuracy: 1,
+            grade: "easy",
+            diff: []DiffOp{{"equal", "Through", ""}, {"typo", "yonder", "yodner"}, {"equal", "window", ""}},
+        },
+        {
+            name: "no typos in short words",
+            line: "The cat sat",
+            answer: "The cot sat",
+            accuracy: 2.0 / 4,
+            grade: "again",
+            diff: []DiffOp{{"equal", "The", ""}, {"missing", "cat", ""}, {"extra", "cot", ""}, {"equal", "sat", ""}},
+        },
+        {
+            name: "contraction typed out",
+            line: "I'm sure it won't rain.",
+            answer: "I am sure it will not rain",
+            accuracy: 1,
+            grade: "easy",
+            diff: []DiffOp{{"equal", "I'm", ""}, {"equal", "sure", ""}, {"equal", "it", ""}, {"equal", "won't", ""}, {"equal", "rain.", ""}},
+        },
+        {
+            name: "words typed as a contraction",
+            line: "I am sure it will not rain.",
+            answer: "I'm sure it won't rain",
+            accuracy: 1,
+            grade: "easy",
+            diff: []DiffOp{{"equal", "I", ""}, {"equal", "am", ""}, {"equal", "sure", ""}, {"equal", "it", ""}, {"equal", "will", ""}, {"equal", "not", ""}, {"equal", "rain.", ""}},
+        },
+        {
+            name: "part of a contraction missing",
+            line: "I'm here.",
+            answer: "I here",
+            accuracy: 2.0 / 3,
+            grade: "again",
+            diff: []DiffOp{{"equal", "I", ""}, {"missing", "am", ""}, {"equal", "here.", ""}},
+        },
+        {
+            name: "contraction missing",
+            line: "DON'T go.",
+            answer: "go",
+            accuracy: 1.0 / 3,
+            grade: "again",
+            diff: []DiffOp{{"missing", "DON'T", ""}, {"equal", "go.", ""}},
+        },
+        {
+            name: "missing apostrophe",
+            line: "It's mine.",
+            answer: "Its mine",
+            accuracy: 1,
+            grade: "easy",
+            diff: []DiffOp{{"typo", "It's", "Its"}, {"equal", "mine.", ""}},
+        },
+        {
+            name: "extra apostrophe",
+            line: "The dog wagged its tail.",
+            answer: "The dog wagged it's tail.",
+            accuracy: 1,
+            grade: "easy",
+            diff: []DiffOp{{"equal", "The", ""}, {"equal", "dog", ""}, {"equal", "wagged", ""}, {"typo", "its", "it's"}, {"equal", "tail.", ""}},
+        },
+        {
+            name: "contraction without an apostrophe",
+            line: "Don't go.",
+            answer: "dont go",
+            accuracy: 1,
+            grade: "easy",
+            diff: []DiffOp{{"typo", "Don't", "dont"}, {"equal", "go.", ""}},
+        },
+        {
+            name: "quotes",
+            line: "She said 'run'.",
+            answer: "she said run",
+            accuracy: 1,
+            grade: "easy",
+            diff: []DiffOp{{"equal", "She", ""}, {"equal", "said", ""}, {"equal", "'run'.", ""}},
+        },
+        {
+            name: "empty answer",
+            line: "Hello there.",
+            answer: "",
+            accuracy: 0,
+            grade: "again",
+            diff: []DiffOp{{"missing", "Hello", ""}, {"missing", "there.", ""}},
+        },
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            score, err := ScoreAnswer(test.line, test.answer)
+            if err != nil {
+                t.Fatalf("ScoreAnswer: %v", err)
+            }
+            if score.Accuracy != test.accuracy || score.Grade != test.grade {
+                t.Errorf("accuracy %v (%s), want %v (%s)", score.Accuracy, score.Grade, test.accuracy, test.grade)
+            }
+            if !reflect.DeepEqual(score.Diff, test.diff) {
+                t.Errorf("diff =\n%q\nwant\n%q", score.Diff, test.diff)
+            }
+        })
+    }
+}
+
+func TestScoreAnswerTooLong(t *testing.T) {
+    line := "To be or not to be"
+    longest := maxAnswerRatio * 6 + maxAnswerSlack
+    if _, err := ScoreAnswer(line, strings.Repeat("be ", longest)); err != nil {
+        t.Errorf("answer of %d words: %v", longest, err)
+    }
+    _, err := ScoreAnswer(line, strings.Repeat("be ", longest + 1))
+    if !errors.Is(err, ErrAnswerTooLong) {
+        t.Errorf("answer of %d words: %v, want ErrAnswerTooLong", longest + 1, err)
+    }
+}
+
+// Request bodies are cut off at maxAnswerBytes before anything is scored.
+func TestCheckLineBodyTooLong(t *testing.T) {
+    useTestStores(t)
+    cookie := testLogin(t, User{Id: 9002, Name: "rufus"})
+
+    body := `{"line": 0, "answer": "` + strings.Repeat("a", maxAnswerBytes) + `"}`
+    r := httptest.NewRequest("POST", "/feline/checkline", strings.NewReader(body))
+    r.Header.Set("Content-Type", "application/json")
+    r.AddCookie(cookie)
+    w := httptest.NewRecorder()
+    handleCheckLine(w, r)
+    if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "too large") {
+        t.Errorf("handleCheckLine: %d %s, want %d", w.Code, w.Body, http.StatusBadRequest)
+    }
+}
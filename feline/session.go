@@ -1,25 +1,95 @@
 package feline
 
 import (
+    "database/sql"
     "encoding/json"
-    "log"
+    "errors"
     "net/http"
-    "os"
-    "os/exec"
+    "net/url"
     "strconv"
-    "time"
+    "strings"
+    "sync"
 	"html/template"
+
+    "github.com/ruuzia/lynx/linefile"
 )
 
-var lynxSessions = map[UserId]*Session {}
+// Review sessions of every logged in user. Handlers may run
+// concurrently for the same user (e.g. from a phone and a laptop), so
+// each Session must be locked before reading or changing its state.
+var lynxSessions = sessionRegistry{sessions: map[UserId]*Session{}}
 
 type Session struct {
+    // Never change after the session is created
     username string;
     id UserId;
+
+    // Guarded by mutex
+    mutex sync.Mutex;
     location string;
-    file string;
+    lineSet LineSet;
     page interface{};
     builderPage BuilderPage;
+    // The strongest hint chosen for each review method
+    hintLimits map[string]string;
+    // The scenes of lineSet being reviewed, or every scene if empty
+    scenes []SceneRef;
+    // The filter last typed on the settings page
+    filter string;
+    // The rehearsal options last picked on the settings page
+    rehearsal RehearsalOptions;
+}
+
+// Returns the current location and page. Pages are never modified
+// after being assigned to a session, so the copy can be safely used
+// after the session is unlocked.
+func (session *Session) currentPage() (string, interface{}) {
+    session.mutex.Lock()
+    defer session.mutex.Unlock()
+    return session.location, session.page
+}
+
+func (session *Session) currentLineSet() LineSet {
+    session.mutex.Lock()
+    defer session.mutex.Unlock()
+    return session.lineSet
+}
+
+type sessionRegistry struct {
+    mutex sync.Mutex
+    sessions map[UserId]*Session
+}
+
+// Returns the review session for a logged in user, creating an empty
+// one if the user logged in before the server last restarted.
+func (registry *sessionRegistry) get(userId UserId) (*Session, error) {
+    registry.mutex.Lock()
+    session, exists := registry.sessions[userId]
+    registry.mutex.Unlock()
+    if exists {
+        return session, nil
+    }
+
+    user, err := GetUserById(userId)
+    if err != nil {
+        return nil, err
+    }
+    return registry.getOrCreate(user), nil
+}
+
+func (registry *sessionRegistry) getOrCreate(user User) *Session {
+    registry.mutex.Lock()
+    defer registry.mutex.Unlock()
+    if session, exists := registry.sessions[user.Id]; exists {
+        return session
+    }
+    session := &Session{
+        username: user.Name,
+        id: user.Id,
+        rehearsal: RehearsalOptions{Pace: 1},
+    }
+    registry.sessions[user.Id] = session
+    return session
 }
 type SessionToken string
 type UserId int
@@ -32,19 +102,34 @@ type IndexPage struct {
 type BuilderPage struct {
     Title string `json:"title"`
     Text string `json:"text"`
+    // "pairs" for cue/line pairs, "scene" for a whole scene or "cards"
+    // for a flashcard deck
+    Mode string `json:"mode"`
+    // The roles the actor is playing in scene mode
+    Roles []string `json:"roles"`
+    // The format of the script in scene mode, and the ambiguous lines
+    // in it that the actor wants read the other way
+    Format string `json:"format"`
+    Alternatives []int `json:"alternatives"`
+    // The columns of the deck in cards mode, or nil to guess them
+    Columns *CardColumns `json:"columns"`
     ReturnTo string
     ErrorMsg string
+    // The line set being edited, or zero when creating a new one
+    Editing LineSetId
+}
+
+func (page *BuilderPage) script() Script {
+    return Script{Text: page.Text, Format: page.Format, Alternatives: page.Alternatives}
 }
 
 func StartSession(w http.ResponseWriter, r *http.Request, user User) {
     debug.Printf("Starting session %s\n", user.Name)
-    Login(w, &user)
-    if _, exists := lynxSessions[user.Id]; !exists {
-        lynxSessions[user.Id] = &Session{
-            username: user.Name,
-            id: user.Id,
-        }
+    if err := Login(w, &user); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
     }
+    lynxSessions.getOrCreate(user)
 
     http.Redirect(w, r, "/", http.StatusFound)
 }
@@ -53,20 +138,58 @@ func StartSession(w http.ResponseWriter, r *http.Request, user User) {
  *** SESSION PAGE DISPATCHERS *****
  **********************************/
 
+type ReviewTypeDesc struct {
+    Code string
+    Title string
+    Description string
+}
+
+type HintDesc struct {
+    Code string
+    Title string
+}
+
+type PaceDesc struct {
+    Pace float64
+    Title string
+}
+
+type SettingsPage struct {
+    Options []ReviewTypeDesc
+    HintOptions []HintDesc
+    HintLimits map[string]string
+    // The last filter used, shown again for the next session
+    Filter string
+    Rehearsal RehearsalOptions
+    Paces []PaceDesc
+    ErrorMsg string
+}
+
 func dispatchSettings(w http.ResponseWriter, r *http.Request, session *Session) {
-    type ReviewTypeDesc struct {
-        Code string
-        Title string
-        Description string
-    }
 
-    type SettingsPage struct {
-        Options []ReviewTypeDesc
+    hintLimits := map[string]string{}
+    for method, limit := range session.hintLimits {
+        hintLimits[method] = limit
     }
 
     session.location = "settings"
     session.page = SettingsPage{
-        []ReviewTypeDesc{
+        HintLimits: hintLimits,
+        Filter: session.filter,
+        Rehearsal: session.rehearsal,
+        Paces: []PaceDesc{
+            {Pace: 1.5, Title: "Slow"},
+            {Pace: 1, Title: "Normal"},
+            {Pace: 0.75, Title: "Fast"},
+        },
+        HintOptions: []HintDesc{
+            {Code: "none", Title: "No hints"},
+            {Code: "skeleton", Title: "Word count"},
+            {Code: "first_letters", Title: "First letters"},
+            {Code: "cloze", Title: "Every other word"},
+            {Code: "full", Title: "Full line"},
+        },
+        Options: []ReviewTypeDesc{
             {
                 Code: "in_order",
                 Title: "In order",
@@ -87,6 +210,31 @@ func dispatchSettings(w http.ResponseWriter, r *http.Request, session *Session)
                 Title: "No cues",
                 Description: "Advanced: recall lines only based on order",
             },
+            {
+                Code: "due",
+                Title: "Review due lines",
+                Description: "Spaced repetition: review the lines you are due to practice",
+            },
+            {
+                Code: "monologue",
+                Title: "Monologue",
+                Description: "Learn long speeches phrase by phrase",
+            },
+            {
+                Code: "audio_cue",
+                Title: "Audio cues",
+                Description: "Hear each cue as recorded instead of reading it",
+            },
+            {
+                Code: "listen",
+                Title: "Listen",
+                Description: "Hands-free: hear each cue, say your line in the pause, then see it",
+            },
+            {
+                Code: "rehearse",
+                Title: "Hands-free rehearsal",
+                Description: "Run the scene as audio, with a pause to say each line in",
+            },
         },
     }
     
@@ -94,58 +242,130 @@ func dispatchSettings(w http.ResponseWriter, r *http.Request, session *Session)
 }
 
 type FileSelectPage struct {
-    Files []string
+    Files []LineSet
+    // Line sets that can still be restored
+    Deleted []DeletedLineSet
+    ErrorMsg string
 }
 
 func dispatchFileSelect(w http.ResponseWriter, r *http.Request, session *Session) {
+    dispatchFileSelectError(w, r, session, "")
+}
+
+func dispatchFileSelectError(w http.ResponseWriter, r *http.Request, session *Session, errorMsg string) {
     debug.Println("dispatchFileSelect")
 
     files, err := getFileList(session)
     if err != nil {
-        log.Fatal(err)
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
     }
     debug.Print(files)
+    deleted, err := RecentlyDeletedLineSets(session.id)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
 
     session.location = "fileselect"
     session.page = FileSelectPage {
         Files: files,
+        Deleted: deleted,
+        ErrorMsg: errorMsg,
     }
     sessionUpdatePage(w, r)
 }
 
-func dispatchLineReviewer(w http.ResponseWriter, r *http.Request, session *Session, reviewMethod string) {
-    out, err := runLynxCommand(session.username, "lines", "--file", session.file)
+// How the actor chose to review on the settings page
+type ReviewOptions struct {
+    Method string
+    // Whether the actor types each line to have it checked
+    TypedAnswers bool
+    // How many steps of the hint ladder the actor may use
+    HintLimit int
+    // Which lines to review
+    Filter LineFilter
+    // How to pace hands-free rehearsal
+    Rehearsal RehearsalOptions
+}
+
+func dispatchLineReviewer(w http.ResponseWriter, r *http.Request, session *Session, options ReviewOptions) {
+    var lines []LineData
+    var err error
+    if options.Method == "due" {
+        lines, err = GetDueLines(session.lineSet.Id)
+    } else {
+        lines, err = GetLines(session.lineSet.Id)
+    }
     if err != nil {
-        debug.Fatal(err)
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
     }
-    var lines []LineData
-    err = json.Unmarshal(out, &lines)
+    lines = filterScenes(lines, session.scenes)
+    lines, err = FilterLines(session.lineSet.Id, lines, options.Filter)
     if err != nil {
-        debug.Fatal(err)
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    if options.Method == "monologue" {
+        dispatchMonologue(w, r, session, lines)
+        return
+    }
+    if options.Method == "rehearse" {
+        dispatchRehearsal(w, r, session, lines, options.Rehearsal)
+        return
     }
 
     type LineReviewerPage struct {
         Lines []LineData
+        // The hints available for each line, weakest first
+        Hints [][]Hint
         ReviewMethod string
+        TypedAnswers bool
+    }
+
+    hints := make([][]Hint, len(lines))
+    for i, line := range lines {
+        hints[i] = Hints(line)[:options.HintLimit]
     }
 
     session.location = "linereviewer"
     session.page = LineReviewerPage {
         Lines: lines,
-        ReviewMethod: reviewMethod,
+        Hints: hints,
+        ReviewMethod: options.Method,
+        TypedAnswers: options.TypedAnswers,
     }
     sessionUpdatePage(w, r)
 
 }
 
-type LineData struct {
-    Id int `json:"id"`
-    Cue string `json:"cue"`
-    Line string `json:"line"`
-    Starred bool `json:"starred"`
-    Notes string `json:"notes"`
-};
+func dispatchMonologue(w http.ResponseWriter, r *http.Request, session *Session, lines []LineData) {
+    type MonologuePage struct {
+        Drills []MonologueDrill
+    }
+
+    session.location = "monologue"
+    session.page = MonologuePage {
+        Drills: MonologueDrills(lines, ChunkByPhrase),
+    }
+    sessionUpdatePage(w, r)
+}
+
+func dispatchRehearsal(w http.ResponseWriter, r *http.Request, session *Session, lines []LineData, options RehearsalOptions) {
+    type RehearsalPage struct {
+        Title string
+        Rehearsal Rehearsal
+    }
 
+    session.location = "rehearse"
+    session.page = RehearsalPage {
+        Title: session.lineSet.Title,
+        Rehearsal: NewRehearsal(lines, options),
+    }
+    sessionUpdatePage(w, r)
+}
 
 type SessionFinishedPage struct {
 }
@@ -183,12 +403,89 @@ func handleStarLine(w http.ResponseWriter, r *http.Request) {
     debug.Println("starred: ", payload.Starred)
     debug.Println("line: ", strconv.Itoa(payload.Line))
 
-    out, err := runLynxCommand(session.username, "set-flagged", session.file, strconv.Itoa(payload.Line), strconv.FormatBool(payload.Starred))
+    err = SetLineStarred(session.currentLineSet().Id, payload.Line, payload.Starred)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+}
+
+func handleGradeLine(w http.ResponseWriter, r *http.Request) {
+    session, err := ActiveSession(w, r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    type GradeLinePayload struct {
+        Line int `json:"line"`
+        Grade string `json:"grade"`
+        ReviewMethod string `json:"review_method"`
+        RevealMs int `json:"reveal_ms"`
+        HintsUsed int `json:"hints_used"`
+    }
+    var payload GradeLinePayload
+    err = json.NewDecoder(r.Body).Decode(&payload)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    attempt := Attempt{
+        Line: payload.Line,
+        ReviewMethod: payload.ReviewMethod,
+        RevealMs: payload.RevealMs,
+        HintsUsed: payload.HintsUsed,
+    }
+    // Lines that were revealed and then skipped are logged ungraded
+    if payload.Grade != "" {
+        attempt.Grade, err = ParseGrade(payload.Grade)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        attempt.Graded = true
+    }
+
+    state, err := RecordReview(session.id, session.currentLineSet().Id, attempt)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    json.NewEncoder(w).Encode(state)
+}
+
+func handleCheckLine(w http.ResponseWriter, r *http.Request) {
+    session, err := ActiveSession(w, r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    type CheckLinePayload struct {
+        Line int `json:"line"`
+        Answer string `json:"answer"`
+        ReviewMethod string `json:"review_method"`
+        RevealMs int `json:"reveal_ms"`
+        HintsUsed int `json:"hints_used"`
+    }
+    var payload CheckLinePayload
+    r.Body = http.MaxBytesReader(w, r.Body, maxAnswerBytes)
+    err = json.NewDecoder(r.Body).Decode(&payload)
     if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    score, _, err := CheckAnswer(session.id, session.currentLineSet().Id, payload.Line, payload.Answer, payload.ReviewMethod, payload.RevealMs, payload.HintsUsed)
+    if errors.Is(err, ErrAnswerTooLong) {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    } else if err != nil {
         http.Error(w, err.Error(), http.StatusInternalServerError)
         return
     }
-    debug.Println(string(out))
+    json.NewEncoder(w).Encode(&score)
 }
 
 func handleListLineSets(w http.ResponseWriter, r *http.Request) {
@@ -197,12 +494,12 @@ func handleListLineSets(w http.ResponseWriter, r *http.Request) {
         http.Error(w, err.Error(), http.StatusBadRequest)
         return
     }
-    names, err := GetLineSets(session.id)
+    sets, err := GetLineSets(session.id)
     if err != nil {
         http.Error(w, err.Error(), http.StatusInternalServerError)
         return
     }
-    json.NewEncoder(w).Encode(&names)
+    json.NewEncoder(w).Encode(&sets)
 }
 
 func handleUpdateBuilder(w http.ResponseWriter, r *http.Request) {
@@ -211,15 +508,25 @@ func handleUpdateBuilder(w http.ResponseWriter, r *http.Request) {
         http.Error(w, err.Error(), http.StatusBadRequest)
         return
     }
-    err = json.NewDecoder(r.Body).Decode(&session.builderPage)
+    var payload BuilderPage
+    err = json.NewDecoder(r.Body).Decode(&payload)
     if err != nil {
         debug.Println(err.Error())
         http.Error(w, err.Error(), http.StatusBadRequest)
         return
     }
-    debug.Printf("title: %s\n", session.builderPage.Title)
+    debug.Printf("title: %s\n", payload.Title)
+
+    session.mutex.Lock()
+    session.builderPage.Title = payload.Title
+    session.builderPage.Text = payload.Text
+    session.builderPage.Mode = payload.Mode
+    session.builderPage.Roles = payload.Roles
+    session.builderPage.Format = payload.Format
+    session.builderPage.Alternatives = payload.Alternatives
+    session.builderPage.Columns = payload.Columns
+    session.mutex.Unlock()
 
-    
     w.WriteHeader(http.StatusOK)
 }
 
@@ -229,35 +536,32 @@ func handleFinishBuilder(w http.ResponseWriter, r *http.Request) {
         http.Error(w, err.Error(), http.StatusBadRequest)
         return
     }
+    session.mutex.Lock()
+    defer session.mutex.Unlock()
 
-    tempFile := session.username + "lineset" + strconv.FormatInt(time.Now().Unix(), 10);
-    f, err := os.Create(tempFile)
-    if err != nil {
-        debug.Println("Error: failed to write to file")
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+    if session.builderPage.Editing != 0 {
+        finishEditing(w, r, session)
         return
     }
-    f.WriteString(session.builderPage.Text);
-    f.Close();
-    out, err := runLynxCommand(session.username, "add-set", session.builderPage.Title, "../" + tempFile)
-    debug.Printf("add-set: %s\n", out)
+
+    var id LineSetId
+    switch session.builderPage.Mode {
+    case "scene":
+        id, err = CreateLineSetFromScene(session.id, session.builderPage.Title, session.builderPage.script(), session.builderPage.Roles)
+    case "cards":
+        id, err = CreateLineSetFromCards(session.id, session.builderPage.Title, session.builderPage.Text, session.builderPage.Columns)
+    default:
+        id, err = CreateLineSet(session.id, session.builderPage.Title, session.builderPage.Text)
+    }
     if err != nil {
-        message := string(err.(*exec.ExitError).Stderr)
-        debug.Println(err.Error(), message)
-        session.builderPage.ErrorMsg = "Error in format. " + message
+        debug.Println(err.Error())
+        session.builderPage.ErrorMsg = "Error in format. " + err.Error()
         http.Redirect(w, r, "/builder", http.StatusFound)
         return
     }
 
-    AddLineSet(session.id, session.builderPage.Title)
-
-    err = os.Remove(tempFile)
-    if err != nil {
-        debug.Println(err)
-    }
-
     if session.builderPage.ReturnTo == "/session" && session.location == "fileselect" {
-        session.file = session.builderPage.Title
+        session.lineSet = LineSet{Id: id, Title: session.builderPage.Title}
         dispatchSettings(w, r, session)
     }
 
@@ -283,7 +587,157 @@ func handleLineNotes(w http.ResponseWriter, r *http.Request) {
     }
     debug.Println("line: ", payload.Line)
     debug.Println("notes: ", payload.Notes)
-    runLynxCommand(session.username, "set-notes", session.file, strconv.Itoa(payload.Line), payload.Notes)
+    err = SetLineNotes(session.currentLineSet().Id, payload.Line, payload.Notes)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+}
+
+// Replaces the tags of a line in the current line set. Tags are sent
+// as a comma separated string.
+func handleLineTags(w http.ResponseWriter, r *http.Request) {
+    session, err := ActiveSession(w, r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    var payload struct {
+        Line int `json:"line"`
+        Tags string `json:"tags"`
+    }
+    err = json.NewDecoder(r.Body).Decode(&payload)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    tags, err := SetLineTags(session.currentLineSet().Id, payload.Line, linefile.ParseTags(payload.Tags))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    json.NewEncoder(w).Encode(tags)
+}
+
+// Plays, replaces or removes the audio of a line in the current line
+// set: GET, PUT or DELETE /feline/lineaudio?line=N&kind=cue. Uploads
+// are the raw clip with its Content-Type.
+func handleLineAudio(w http.ResponseWriter, r *http.Request) {
+    session, err := ActiveSession(w, r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    line, err := strconv.Atoi(r.FormValue("line"))
+    if err != nil {
+        http.Error(w, "Invalid line", http.StatusBadRequest)
+        return
+    }
+    set, kind := session.currentLineSet().Id, r.FormValue("kind")
+
+    switch r.Method {
+    case http.MethodGet:
+        err = serveLineAudio(w, r, set, line, kind)
+    case http.MethodPut:
+        _, err = SaveLineAudio(set, line, kind, r.Header.Get("Content-Type"), r.Body)
+    case http.MethodDelete:
+        err = RemoveLineAudio(set, line, kind)
+    default:
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    switch {
+    case err == nil:
+    case errors.Is(err, ErrNoAudio), err == sql.ErrNoRows:
+        http.Error(w, err.Error(), http.StatusNotFound)
+    case errors.Is(err, ErrInvalidAudioKind), errors.Is(err, ErrUnsupportedAudio):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+    case errors.Is(err, ErrAudioTooLarge):
+        http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+    default:
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
+
+// Plays a cue or line of the current line set, as recorded or read
+// aloud: GET /feline/linespeech?line=N&part=cue.
+func handleLineSpeech(w http.ResponseWriter, r *http.Request) {
+    session, err := ActiveSession(w, r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    line, err := strconv.Atoi(r.FormValue("line"))
+    if err != nil {
+        http.Error(w, "Invalid line", http.StatusBadRequest)
+        return
+    }
+    err = serveLineSpeech(w, r, session.currentLineSet().Id, line, r.FormValue("part"))
+    switch {
+    case err == nil:
+    case errors.Is(err, ErrNoAudio), err == sql.ErrNoRows:
+        http.Error(w, err.Error(), http.StatusNotFound)
+    case errors.Is(err, ErrNoSynthesizer):
+        http.Error(w, err.Error(), http.StatusServiceUnavailable)
+    case errors.Is(err, ErrInvalidAudioKind):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+    default:
+        debug.Println("[tts]", err)
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
+
+// Plays a pause of hands-free rehearsal: GET /feline/silence?ms=N.
+func handleSilence(w http.ResponseWriter, r *http.Request) {
+    if _, err := ActiveSession(w, r); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if err := serveSilence(w, r); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+    }
+}
+
+// Saves the voices picked on the script page, sent as role and voice
+// fields in pairs.
+func handleVoices(w http.ResponseWriter, r *http.Request) {
+    userId, err := CheckAuth(w, r)
+    if err != nil {
+        redirectLogin(w, r)
+        return
+    }
+    r.ParseForm()
+    id, err := strconv.Atoi(r.FormValue("set"))
+    if err != nil {
+        http.Error(w, "Invalid line set", http.StatusBadRequest)
+        return
+    }
+    set, err := GetLineSet(userId, LineSetId(id))
+    if err == sql.ErrNoRows {
+        http.Error(w, "Line set not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    roles, voiceNames := r.Form["role"], r.Form["voice"]
+    if len(roles) != len(voiceNames) {
+        http.Error(w, "Every role needs a voice", http.StatusBadRequest)
+        return
+    }
+    voices := make([]RoleVoice, len(roles))
+    for i := range roles {
+        voices[i] = RoleVoice{Role: roles[i], Voice: voiceNames[i]}
+    }
+    if err := SetVoices(set.Id, voices); errors.Is(err, ErrInvalidVoice) {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    } else if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    http.Redirect(w, r, "/script?set=" + strconv.Itoa(int(set.Id)), http.StatusFound)
 }
 
 func handleStartSession(w http.ResponseWriter, r *http.Request) {
@@ -292,7 +746,14 @@ func handleStartSession(w http.ResponseWriter, r *http.Request) {
         redirectLogin(w, r)
         return
     }
-    dispatchFileSelect(w, r, lynxSessions[userId])
+    session, err := lynxSessions.get(userId)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    session.mutex.Lock()
+    defer session.mutex.Unlock()
+    dispatchFileSelect(w, r, session)
 }
 
 func handleSettings(w http.ResponseWriter, r *http.Request) {
@@ -301,8 +762,10 @@ func handleSettings(w http.ResponseWriter, r *http.Request) {
         redirectLogin(w, r)
         return
     }
+    session.mutex.Lock()
+    defer session.mutex.Unlock()
     if session.location != "settings" {
-        sendPage(w, session)
+        sendPage(w, session.location, session.page)
         return
     }
 
@@ -314,7 +777,38 @@ func handleSettings(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    dispatchLineReviewer(w, r, session, reviewType)
+    hintLimit := r.Form.Get("hints_" + reviewType)
+    if session.hintLimits == nil {
+        session.hintLimits = map[string]string{}
+    }
+    session.hintLimits[reviewType] = hintLimit
+
+    session.filter = strings.TrimSpace(r.Form.Get("filter"))
+    filter, err := ParseLineFilter(session.filter)
+    if err == nil && reviewType == "rehearse" {
+        var rehearsal RehearsalOptions
+        rehearsal, err = parseRehearsalOptions(r.Form.Get("self_check"), r.Form.Get("pace"))
+        if err == nil {
+            session.rehearsal = rehearsal
+        }
+    }
+    if err != nil {
+        page := session.page.(SettingsPage)
+        page.Filter = session.filter
+        page.Rehearsal = session.rehearsal
+        page.ErrorMsg = err.Error()
+        session.page = page
+        sessionUpdatePage(w, r)
+        return
+    }
+
+    dispatchLineReviewer(w, r, session, ReviewOptions{
+        Method: reviewType,
+        TypedAnswers: r.Form.Get("typed") == "on",
+        HintLimit: ParseHintLimit(hintLimit),
+        Filter: filter,
+        Rehearsal: session.rehearsal,
+    })
 }
 
 func handleFileSelect(w http.ResponseWriter, r *http.Request) {
@@ -324,8 +818,10 @@ func handleFileSelect(w http.ResponseWriter, r *http.Request) {
         redirectLogin(w, r)
         return
     }
+    session.mutex.Lock()
+    defer session.mutex.Unlock()
     if session.location != "fileselect" {
-        sendPage(w, session)
+        sendPage(w, session.location, session.page)
         return
     }
 
@@ -336,45 +832,129 @@ func handleFileSelect(w http.ResponseWriter, r *http.Request) {
         return
     }
     index, err := strconv.Atoi(file);
-    if err != nil {
+    data := session.page.(FileSelectPage)
+    if err != nil || index < 0 || index >= len(data.Files) {
         http.Error(w, "Invalid index", http.StatusBadRequest)
         return
     }
 
-    data := session.page.(FileSelectPage)
-
-    session.file = data.Files[index]
+    session.lineSet = data.Files[index]
+    session.scenes = nil
     dispatchSettings(w, r, session)
 }
 
-/******************************
-******************************/
-
-func serveHome(w http.ResponseWriter, r *http.Request) {
+// Renames, deletes, restores or duplicates a line set from the file
+// select page.
+func handleLineSetAction(w http.ResponseWriter, r *http.Request) {
     session, err := ActiveSession(w, r)
     if err != nil {
         redirectLogin(w, r)
         return
     }
+    session.mutex.Lock()
+    defer session.mutex.Unlock()
 
-    type HomePage struct {
-        ActiveSession bool
-        Name string
+    id, err := strconv.Atoi(r.FormValue("set"))
+    if err != nil {
+        http.Error(w, "Invalid line set", http.StatusBadRequest)
+        return
     }
-    data := HomePage {
-        ActiveSession: session.location == "linereviewer",
-        Name: session.username,
+    set := LineSetId(id)
+    switch r.PathValue("action") {
+    case "rename":
+        err = RenameLineSet(session.id, set, r.FormValue("title"))
+    case "delete":
+        err = DeleteLineSet(session.id, set)
+    case "undo":
+        _, err = UndoDeleteLineSet(session.id, set)
+    case "duplicate":
+        _, err = DuplicateLineSet(session.id, set)
+    default:
+        http.NotFound(w, r)
+        return
     }
 
-    t, err := template.ParseFiles("./web/templates/index.html")
-    if err != nil {
+    errorMsg := ""
+    if err == sql.ErrNoRows {
+        errorMsg = "That line set no longer exists."
+    } else if errors.Is(err, ErrInvalidTitle) || errors.Is(err, ErrTitleCharacters) || errors.Is(err, ErrDuplicateTitle) || errors.Is(err, ErrUndoExpired) {
+        errorMsg = err.Error()
+    } else if err != nil {
         http.Error(w, err.Error(), http.StatusInternalServerError)
         return
     }
-    t.Execute(w, data)
+    if session.location != "fileselect" {
+        sessionUpdatePage(w, r)
+        return
+    }
+    dispatchFileSelectError(w, r, session, errorMsg)
 }
 
-func serveBuilder(w http.ResponseWriter, r *http.Request) {
+// Starts reviewing the selected scenes of a line set from the script
+// page.
+func handleReviewScenes(w http.ResponseWriter, r *http.Request) {
+    session, err := ActiveSession(w, r)
+    if err != nil {
+        redirectLogin(w, r)
+        return
+    }
+    id, err := strconv.Atoi(r.FormValue("set"))
+    if err != nil {
+        http.Error(w, "Invalid line set", http.StatusBadRequest)
+        return
+    }
+    set, err := GetLineSet(session.id, LineSetId(id))
+    if err != nil {
+        http.Error(w, "Line set not found", http.StatusNotFound)
+        return
+    }
+    lines, err := GetLines(set.Id)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    scenes, err := scenesAt(linefile.Outline(lines), r.Form["scene"])
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    session.mutex.Lock()
+    defer session.mutex.Unlock()
+    session.lineSet = set
+    session.scenes = scenes
+    dispatchSettings(w, r, session)
+}
+
+/******************************
+******************************/
+
+func serveHome(w http.ResponseWriter, r *http.Request) {
+    session, err := ActiveSession(w, r)
+    if err != nil {
+        redirectLogin(w, r)
+        return
+    }
+
+    type HomePage struct {
+        ActiveSession bool
+        Name string
+    }
+    location, _ := session.currentPage()
+    data := HomePage {
+        ActiveSession: location == "linereviewer",
+        Name: session.username,
+    }
+
+    t, err := template.ParseFiles("./web/templates/index.html")
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    t.Execute(w, data)
+}
+
+func serveBuilder(w http.ResponseWriter, r *http.Request) {
     session, err := ActiveSession(w, r)
     if err != nil {
         redirectLogin(w, r)
@@ -382,17 +962,559 @@ func serveBuilder(w http.ResponseWriter, r *http.Request) {
     }
 
     r.ParseForm()
+    session.mutex.Lock()
+    if edit := r.Form.Get("edit"); edit != "" {
+        if err := startEditing(session, edit); err != nil {
+            session.mutex.Unlock()
+            http.Error(w, err.Error(), http.StatusNotFound)
+            return
+        }
+    } else if session.builderPage.Editing != 0 {
+        // Creating a new line set after leaving an edit
+        session.builderPage = BuilderPage{ReturnTo: session.builderPage.ReturnTo}
+    }
     if r.Form.Get("returnTo") != "" {
         session.builderPage.ReturnTo = "/" + r.Form.Get("returnTo")
     }
+    page := session.builderPage
+    session.mutex.Unlock()
 
     t, err := template.ParseFiles("./web/templates/builder.html")
     if err != nil {
         http.Error(w, err.Error(), http.StatusInternalServerError)
         return
     }
-    debug.Println(session.builderPage)
-    t.Execute(w, &session.builderPage)
+    debug.Println(page)
+    t.Execute(w, &page)
+}
+
+// Opens one of the user's line sets in the builder. Unsaved changes to
+// the same line set are kept.
+func startEditing(session *Session, edit string) error {
+    id, err := strconv.Atoi(edit)
+    if err != nil {
+        return err
+    }
+    if session.builderPage.Editing == LineSetId(id) {
+        return nil
+    }
+    set, err := GetLineSet(session.id, LineSetId(id))
+    if err != nil {
+        return err
+    }
+    lines, err := GetLines(set.Id)
+    if err != nil {
+        return err
+    }
+    session.builderPage = BuilderPage{
+        Title: set.Title,
+        Text: EditableText(lines),
+        Mode: "pairs",
+        ReturnTo: session.builderPage.ReturnTo,
+        Editing: set.Id,
+    }
+    return nil
+}
+
+// Saves the line set open in the builder, keeping the stars, notes and
+// history of lines that were not changed much.
+func finishEditing(w http.ResponseWriter, r *http.Request, session *Session) {
+    page := &session.builderPage
+    set, err := GetLineSet(session.id, page.Editing)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+    var lines []LineData
+    switch page.Mode {
+    case "scene":
+        lines, err = ScriptLines(page.script(), page.Roles)
+    case "cards":
+        lines, err = CardLines(page.Text, page.Columns)
+    default:
+        lines, err = linefile.ParseString(page.Text)
+    }
+    if err == nil {
+        err = EditLineSet(session.id, set, page.Title, lines)
+    }
+    if err != nil {
+        debug.Println(err.Error())
+        page.ErrorMsg = "Error in format. " + err.Error()
+        http.Redirect(w, r, "/builder?edit=" + strconv.Itoa(int(set.Id)), http.StatusFound)
+        return
+    }
+
+    if session.lineSet.Id == set.Id {
+        session.lineSet.Title = strings.TrimSpace(page.Title)
+    }
+    returnTo := page.ReturnTo
+    if returnTo == "" {
+        returnTo = "/"
+    }
+    session.builderPage = BuilderPage{ReturnTo: page.ReturnTo}
+    if returnTo == "/session" && session.location == "fileselect" {
+        // Show the new title
+        dispatchFileSelect(w, r, session)
+        return
+    }
+    http.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+func serveStats(w http.ResponseWriter, r *http.Request) {
+    session, err := ActiveSession(w, r)
+    if err != nil {
+        redirectLogin(w, r)
+        return
+    }
+
+    type StatsPage struct {
+        LineSets []LineSet
+        Stats *LineSetStats
+    }
+    var data StatsPage
+    data.LineSets, err = GetLineSets(session.id)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    if r.FormValue("set") != "" {
+        id, err := strconv.Atoi(r.FormValue("set"))
+        if err != nil {
+            http.Error(w, "Invalid line set", http.StatusBadRequest)
+            return
+        }
+        set, err := GetLineSet(session.id, LineSetId(id))
+        if err != nil {
+            http.Error(w, "Line set not found", http.StatusNotFound)
+            return
+        }
+        stats, err := GetLineSetStats(session.id, set)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        data.Stats = &stats
+    }
+
+    t, err := template.New("stats.html").Funcs(template.FuncMap{
+        "percent": func(x float64) string { return strconv.Itoa(int(x * 100 + 0.5)) + "%" },
+        "minutes": func(seconds float64) string { return strconv.FormatFloat(seconds / 60, 'f', 1, 64) },
+    }).ParseFiles("./web/templates/stats.html")
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    t.Execute(w, data)
+}
+
+// Lists public line sets, optionally filtered by the q parameter.
+func serveBrowse(w http.ResponseWriter, r *http.Request) {
+    if _, err := ActiveSession(w, r); err != nil {
+        redirectLogin(w, r)
+        return
+    }
+
+    type BrowsePage struct {
+        Query string
+        LineSets []SharedLineSet
+    }
+    data := BrowsePage{Query: strings.TrimSpace(r.FormValue("q"))}
+    sets, err := SearchPublicLineSets(data.Query)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    data.LineSets = sets
+
+    t, err := template.ParseFiles("./web/templates/browse.html")
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    t.Execute(w, data)
+}
+
+// Shows the lines of a line set, given either its id or the token from
+// a share link.
+func serveScript(w http.ResponseWriter, r *http.Request) {
+    session, err := ActiveSession(w, r)
+    if err != nil {
+        redirectLogin(w, r)
+        return
+    }
+
+    type ScriptPage struct {
+        LineSet SharedLineSet
+        Acts []linefile.Act
+        ShareToken string
+        // Set when the viewer owns the line set
+        Owned *LineSet
+        ShareURL string
+        // The voices roles are read in, if text to speech is set up
+        Voices []RoleVoice
+    }
+    data := ScriptPage{ShareToken: r.FormValue("token")}
+    if r.FormValue("set") != "" {
+        id, err := strconv.Atoi(r.FormValue("set"))
+        if err != nil {
+            http.Error(w, "Invalid line set", http.StatusBadRequest)
+            return
+        }
+        data.LineSet, err = GetVisibleLineSet(session.id, LineSetId(id), data.ShareToken)
+    } else {
+        data.LineSet, err = GetLineSetByShareToken(data.ShareToken)
+    }
+    if err == sql.ErrNoRows {
+        http.Error(w, "Line set not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    lines, err := GetVisibleLines(session.id, data.LineSet.Id, data.ShareToken)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    data.Acts = linefile.Outline(lines)
+    if set, err := GetLineSet(session.id, data.LineSet.Id); err == nil {
+        data.Owned = &set
+        if set.ShareToken != "" && set.Visibility != VisibilityPrivate {
+            data.ShareURL = "/script?token=" + url.QueryEscape(set.ShareToken)
+        }
+        if Synthesizer != nil {
+            data.Voices, err = GetVoices(set.Id)
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusInternalServerError)
+                return
+            }
+        }
+    }
+
+    t, err := template.ParseFiles("./web/templates/script.html")
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    t.Execute(w, data)
+}
+
+// Exports a line set the user can see, as on the script page.
+func serveExport(w http.ResponseWriter, r *http.Request) {
+    session, err := ActiveSession(w, r)
+    if err != nil {
+        redirectLogin(w, r)
+        return
+    }
+    id, err := strconv.Atoi(r.FormValue("set"))
+    if err != nil {
+        http.Error(w, "Invalid line set", http.StatusBadRequest)
+        return
+    }
+    shareToken := r.FormValue("token")
+    set, err := GetVisibleLineSet(session.id, LineSetId(id), shareToken)
+    if err == sql.ErrNoRows {
+        http.Error(w, "Line set not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    lines, err := GetVisibleLines(session.id, set.Id, shareToken)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    writeExport(w, r.FormValue("format"), set.Title, lines, exportOptions(r))
+}
+
+// Lists the revisions of one of the user's line sets, with the changes
+// made by the selected revision.
+func serveHistory(w http.ResponseWriter, r *http.Request) {
+    session, err := ActiveSession(w, r)
+    if err != nil {
+        redirectLogin(w, r)
+        return
+    }
+
+    type HistoryPage struct {
+        LineSet LineSet
+        Revisions []Revision
+        // The revision whose changes are shown, if one was picked
+        Selected *RevisionDiff
+    }
+    id, err := strconv.Atoi(r.FormValue("set"))
+    if err != nil {
+        http.Error(w, "Invalid line set", http.StatusBadRequest)
+        return
+    }
+    var data HistoryPage
+    data.LineSet, err = GetLineSet(session.id, LineSetId(id))
+    if err == sql.ErrNoRows {
+        http.Error(w, "Line set not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    data.Revisions, err = GetRevisions(data.LineSet.Id)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if r.FormValue("revision") != "" {
+        revision, err := strconv.Atoi(r.FormValue("revision"))
+        if err != nil {
+            http.Error(w, "Invalid revision", http.StatusBadRequest)
+            return
+        }
+        diff, err := GetRevisionDiff(data.LineSet.Id, RevisionId(revision))
+        if err == sql.ErrNoRows {
+            http.Error(w, "Revision not found", http.StatusNotFound)
+            return
+        } else if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        data.Selected = &diff
+    }
+
+    t, err := template.ParseFiles("./web/templates/history.html")
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    t.Execute(w, data)
+}
+
+func handleRestoreRevision(w http.ResponseWriter, r *http.Request) {
+    userId, err := CheckAuth(w, r)
+    if err != nil {
+        redirectLogin(w, r)
+        return
+    }
+    set, err := strconv.Atoi(r.FormValue("set"))
+    if err != nil {
+        http.Error(w, "Invalid line set", http.StatusBadRequest)
+        return
+    }
+    revision, err := strconv.Atoi(r.FormValue("revision"))
+    if err != nil {
+        http.Error(w, "Invalid revision", http.StatusBadRequest)
+        return
+    }
+    if _, err := GetLineSet(userId, LineSetId(set)); err == sql.ErrNoRows {
+        http.Error(w, "Line set not found", http.StatusNotFound)
+        return
+    }
+    err = RestoreRevision(userId, LineSetId(set), RevisionId(revision))
+    if err == sql.ErrNoRows {
+        http.Error(w, "Revision not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    http.Redirect(w, r, "/history?set=" + strconv.Itoa(set), http.StatusFound)
+}
+
+func handleCopyLineSet(w http.ResponseWriter, r *http.Request) {
+    userId, err := CheckAuth(w, r)
+    if err != nil {
+        http.Error(w, "Not logged in", http.StatusUnauthorized)
+        return
+    }
+    id, err := strconv.Atoi(r.FormValue("set"))
+    if err != nil {
+        http.Error(w, "Invalid line set", http.StatusBadRequest)
+        return
+    }
+    set, err := CopyToLibrary(userId, LineSetId(id), r.FormValue("token"))
+    if err == sql.ErrNoRows {
+        http.Error(w, "Line set not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    http.Redirect(w, r, "/script?set=" + strconv.Itoa(int(set.Id)), http.StatusFound)
+}
+
+func handleLineSetVisibility(w http.ResponseWriter, r *http.Request) {
+    userId, err := CheckAuth(w, r)
+    if err != nil {
+        http.Error(w, "Not logged in", http.StatusUnauthorized)
+        return
+    }
+    id, err := strconv.Atoi(r.FormValue("set"))
+    if err != nil {
+        http.Error(w, "Invalid line set", http.StatusBadRequest)
+        return
+    }
+    _, err = ChangeVisibility(userId, LineSetId(id), r.FormValue("visibility"))
+    if err == ErrInvalidVisibility {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    } else if err == sql.ErrNoRows {
+        http.Error(w, "Line set not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    http.Redirect(w, r, "/script?set=" + strconv.Itoa(id), http.StatusFound)
+}
+
+// Lists the user's productions and invitations.
+func serveProductions(w http.ResponseWriter, r *http.Request) {
+    session, err := ActiveSession(w, r)
+    if err != nil {
+        redirectLogin(w, r)
+        return
+    }
+
+    type ProductionsPage struct {
+        Productions []Production
+        ErrorMsg string
+    }
+    data := ProductionsPage{ErrorMsg: r.FormValue("error")}
+    data.Productions, err = GetProductions(session.id)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    t, err := template.ParseFiles("./web/templates/productions.html")
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    t.Execute(w, data)
+}
+
+// Shows the members and scripts of a production. Directors and stage
+// managers also see each actor's progress.
+func serveProduction(w http.ResponseWriter, r *http.Request) {
+    session, err := ActiveSession(w, r)
+    if err != nil {
+        redirectLogin(w, r)
+        return
+    }
+    id, err := strconv.Atoi(r.FormValue("id"))
+    if err != nil {
+        http.Error(w, "Invalid production", http.StatusBadRequest)
+        return
+    }
+
+    type ProductionPage struct {
+        Production Production
+        Members []ProductionMember
+        Scripts []LineSet
+        // The director's own line sets that can be added as scripts
+        LineSets []LineSet
+        Progress []ScriptProgress
+        ErrorMsg string
+    }
+    data := ProductionPage{ErrorMsg: r.FormValue("error")}
+    data.Production, err = GetProduction(session.id, ProductionId(id))
+    if err == sql.ErrNoRows {
+        http.Error(w, "Production not found", http.StatusNotFound)
+        return
+    } else if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if data.Members, err = GetProductionMembers(data.Production.Id); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if data.Scripts, err = GetProductionScripts(data.Production.Id); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if data.Production.isDirector() {
+        if data.LineSets, err = GetLineSets(session.id); err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+    }
+    if data.Production.canManage() {
+        if data.Progress, err = GetProductionProgress(session.id, data.Production.Id); err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+    }
+
+    t, err := template.New("production.html").Funcs(template.FuncMap{
+        "percent": func(x float64) string { return strconv.Itoa(int(x * 100 + 0.5)) + "%" },
+    }).ParseFiles("./web/templates/production.html")
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    t.Execute(w, data)
+}
+
+// Handles the forms on the production pages. Client errors are shown
+// on the page the form came from.
+func handleProduction(w http.ResponseWriter, r *http.Request) {
+    userId, err := CheckAuth(w, r)
+    if err != nil {
+        redirectLogin(w, r)
+        return
+    }
+
+    id, _ := strconv.Atoi(r.FormValue("production"))
+    production := ProductionId(id)
+    switch r.PathValue("action") {
+    case "create":
+        production, err = CreateProduction(userId, r.FormValue("name"))
+    case "invite":
+        err = InviteToProduction(userId, production, r.FormValue("username"), r.FormValue("role"))
+    case "accept":
+        err = RespondToInvitation(userId, production, true)
+    case "decline":
+        err = RespondToInvitation(userId, production, false)
+    case "addscript":
+        var set int
+        set, err = strconv.Atoi(r.FormValue("set"))
+        if err == nil {
+            err = AddScriptToProduction(userId, production, LineSetId(set))
+        }
+    default:
+        http.NotFound(w, r)
+        return
+    }
+
+    location := "/production?id=" + strconv.Itoa(int(production))
+    if r.PathValue("action") == "decline" || (r.PathValue("action") == "create" && err != nil) {
+        location = "/productions"
+    }
+    if err != nil {
+        if err == sql.ErrNoRows {
+            err = errors.New("Not found.")
+        } else if !isProductionClientError(err) {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        separator := "?"
+        if strings.Contains(location, "?") {
+            separator = "&"
+        }
+        location += separator + "error=" + url.QueryEscape(err.Error())
+    }
+    http.Redirect(w, r, location, http.StatusFound)
+}
+
+func isProductionClientError(err error) bool {
+    for _, target := range []error{ErrInvalidProductionName, ErrInvalidRole, ErrNotDirector, ErrAlreadyMember, ErrNoSuchUser, ErrDuplicateScript} {
+        if errors.Is(err, target) {
+            return true
+        }
+    }
+    return false
 }
 
 func sessionUpdatePage(w http.ResponseWriter, r *http.Request) {
@@ -410,17 +1532,18 @@ func sessionPage(w http.ResponseWriter, r *http.Request) {
         redirectLogin(w, r)
         return
     }
-    
-    sendPage(w, session)
+
+    location, page := session.currentPage()
+    sendPage(w, location, page)
     return
 }
 
-func sendPage(w http.ResponseWriter, session *Session) {
-    t, err := template.ParseFiles("web/templates/" + session.location + ".html")
+func sendPage(w http.ResponseWriter, location string, page interface{}) {
+    t, err := template.ParseFiles("web/templates/" + location + ".html")
     if err != nil {
         debug.Println("Error parsing template file")
         http.Error(w, err.Error(), http.StatusInternalServerError)
         return
     }
-    t.Execute(w, session.page)
+    t.Execute(w, page)
 }
@@ -0,0 +1,180 @@
+package feline
+
+import (
+    "database/sql"
+    "database/sql/driver"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// A database/sql driver that accepts every statement as changing one
+// row, so handlers that only write to the database can run without
+// MySQL. Queries fail.
+type fakeDriver struct {
+    mutex sync.Mutex
+    execs []string
+}
+
+type fakeConn struct{ driver *fakeDriver }
+type fakeStmt struct{ conn fakeConn; query string }
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{d}, nil }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c, query}, nil }
+func (c fakeConn) Close() error { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("fake database has no transactions") }
+
+func (s fakeStmt) Close() error { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+    s.conn.driver.mutex.Lock()
+    defer s.conn.driver.mutex.Unlock()
+    s.conn.driver.execs = append(s.conn.driver.execs, fmt.Sprint(strings.Fields(s.query)[0], args))
+    return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+    return nil, errors.New("fake database has no rows")
+}
+
+var testDriver = &fakeDriver{}
+
+func init() {
+    sql.Register("feline-test", testDriver)
+}
+
+// Points the package at a fake database and an in-memory session store
+// for the length of a test.
+func useTestStores(t *testing.T) {
+    oldDb, oldStore := db, sessionStore
+    fake, err := sql.Open("feline-test", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    db, sessionStore = fake, NewMemorySessionStore()
+    t.Cleanup(func() {
+        fake.Close()
+        db, sessionStore = oldDb, oldStore
+    })
+
+    // Templates are found relative to the repository root
+    wd, err := os.Getwd()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Chdir(".."); err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// Logs a user in, returning the cookie to send with their requests.
+func testLogin(t *testing.T, user User) *http.Cookie {
+    lynxSessions.mutex.Lock()
+    delete(lynxSessions.sessions, user.Id)
+    lynxSessions.mutex.Unlock()
+    lynxSessions.getOrCreate(user)
+
+    w := httptest.NewRecorder()
+    if err := Login(w, &user); err != nil {
+        t.Fatalf("Login: %v", err)
+    }
+    cookies := w.Result().Cookies()
+    if len(cookies) != 1 {
+        t.Fatalf("Login set %d cookies, want 1", len(cookies))
+    }
+    return cookies[0]
+}
+
+// Handlers for one user can run at the same time, from a phone and a
+// laptop. Run with -race.
+func TestSessionHandlersConcurrently(t *testing.T) {
+    useTestStores(t)
+    user := User{Id: 9001, Name: "poco"}
+    cookie := testLogin(t, user)
+
+    files := []LineSet{{Id: 1, Title: "Act One"}, {Id: 2, Title: "Act Two"}}
+    session, err := lynxSessions.get(user.Id)
+    if err != nil {
+        t.Fatalf("get session: %v", err)
+    }
+    session.mutex.Lock()
+    session.location = "fileselect"
+    session.page = FileSelectPage{Files: files}
+    session.lineSet = files[0]
+    session.mutex.Unlock()
+
+    request := func(method string, target string, contentType string, body string) *http.Request {
+        r := httptest.NewRequest(method, target, strings.NewReader(body))
+        r.Header.Set("Content-Type", contentType)
+        r.AddCookie(cookie)
+        return r
+    }
+
+    const rounds = 50
+    var wg sync.WaitGroup
+    for i := 0; i < rounds; i++ {
+        wg.Add(3)
+        go func() {
+            defer wg.Done()
+            w := httptest.NewRecorder()
+            body := fmt.Sprintf(`{"line": %d, "starred": %t}`, i, i % 2 == 0)
+            handleStarLine(w, request("POST", "/feline/starline", "application/json", body))
+            if w.Code != http.StatusOK {
+                t.Errorf("handleStarLine: %d %s", w.Code, w.Body)
+            }
+        }()
+        go func() {
+            defer wg.Done()
+            // Put the file select page back so every request selects
+            session.mutex.Lock()
+            session.location = "fileselect"
+            session.page = FileSelectPage{Files: files}
+            session.mutex.Unlock()
+            w := httptest.NewRecorder()
+            form := url.Values{"file": {fmt.Sprint(i % len(files))}}.Encode()
+            handleFileSelect(w, request("POST", "/feline/fileselect", "application/x-www-form-urlencoded", form))
+            // Redirects to the settings page, or shows it again if
+            // another request got there first
+            if w.Code != http.StatusFound && w.Code != http.StatusOK {
+                t.Errorf("handleFileSelect: %d %s", w.Code, w.Body)
+            }
+        }()
+        go func() {
+            defer wg.Done()
+            w := httptest.NewRecorder()
+            body := fmt.Sprintf(`{"title": "Draft %d", "text": "RUFUS: Hi.\nPOCO: Hello.\n", "mode": "scene", "roles": ["POCO"]}`, i)
+            handleUpdateBuilder(w, request("POST", "/feline/updatebuilder", "application/json", body))
+            if w.Code != http.StatusOK {
+                t.Errorf("handleUpdateBuilder: %d %s", w.Code, w.Body)
+            }
+        }()
+    }
+    wg.Wait()
+
+    session.mutex.Lock()
+    defer session.mutex.Unlock()
+    if session.lineSet != files[0] && session.lineSet != files[1] {
+        t.Errorf("line set = %+v, want one of the files", session.lineSet)
+    }
+    if session.location != "settings" {
+        t.Errorf("location = %q, want settings", session.location)
+    }
+    if !strings.HasPrefix(session.builderPage.Title, "Draft ") || session.builderPage.Mode != "scene" {
+        t.Errorf("builder page = %+v, want one of the drafts", session.builderPage)
+    }
+
+    testDriver.mutex.Lock()
+    defer testDriver.mutex.Unlock()
+    if len(testDriver.execs) < rounds {
+        t.Errorf("%d statements run, want at least %d", len(testDriver.execs), rounds)
+    }
+}
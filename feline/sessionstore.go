@@ -0,0 +1,179 @@
+package feline
+
+import (
+    "crypto/sha256"
+    "database/sql"
+    "errors"
+    "sync"
+    "time"
+)
+
+// Login sessions expire after sessionIdleTimeout without any requests,
+// and always after sessionMaxAge.
+const (
+    sessionIdleTimeout = 7 * 24 * time.Hour
+    sessionMaxAge = 30 * 24 * time.Hour
+    sessionSweepInterval = time.Hour
+)
+
+var ErrInvalidToken = errors.New("Invalid token")
+
+// SessionStore keeps track of which login token belongs to which user.
+type SessionStore interface {
+    // Create starts a new login session for the user.
+    Create(user UserId) (SessionToken, error)
+    // Lookup returns the user a token belongs to and marks the session
+    // as used. Returns ErrInvalidToken for unknown or expired tokens.
+    Lookup(token SessionToken) (UserId, error)
+    // Delete ends a login session.
+    Delete(token SessionToken) error
+    // Sweep removes every expired session.
+    Sweep() error
+}
+
+var sessionStore SessionStore = NewMemorySessionStore()
+
+type loginSession struct {
+    user UserId
+    created time.Time
+    lastSeen time.Time
+}
+
+func (s loginSession) expired(now time.Time) bool {
+    return now.Sub(s.lastSeen) > sessionIdleTimeout || now.Sub(s.created) > sessionMaxAge
+}
+
+// MemorySessionStore keeps sessions in memory, so every session is lost
+// when the server restarts. Useful for tests. Like SQLSessionStore, it
+// only keeps a hash of each token.
+type MemorySessionStore struct {
+    mutex sync.Mutex
+    sessions map[string]loginSession
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+    return &MemorySessionStore{sessions: map[string]loginSession{}}
+}
+
+func (m *MemorySessionStore) Create(user UserId) (SessionToken, error) {
+    token := generateSessionToken()
+    now := time.Now()
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    m.sessions[string(hashSessionToken(token))] = loginSession{user: user, created: now, lastSeen: now}
+    return token, nil
+}
+
+func (m *MemorySessionStore) Lookup(token SessionToken) (UserId, error) {
+    hash := string(hashSessionToken(token))
+    now := time.Now()
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    session, exists := m.sessions[hash]
+    if !exists || session.expired(now) {
+        delete(m.sessions, hash)
+        return -1, ErrInvalidToken
+    }
+    session.lastSeen = now
+    m.sessions[hash] = session
+    return session.user, nil
+}
+
+func (m *MemorySessionStore) Delete(token SessionToken) error {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    delete(m.sessions, string(hashSessionToken(token)))
+    return nil
+}
+
+func (m *MemorySessionStore) Sweep() error {
+    now := time.Now()
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    for hash, session := range m.sessions {
+        if session.expired(now) {
+            delete(m.sessions, hash)
+        }
+    }
+    return nil
+}
+
+// SQLSessionStore keeps sessions in the login_sessions table so they
+// survive restarts. Only a SHA-256 hash of each token is stored.
+type SQLSessionStore struct {
+    db *sql.DB
+}
+
+func NewSQLSessionStore(db *sql.DB) *SQLSessionStore {
+    return &SQLSessionStore{db: db}
+}
+
+func hashSessionToken(token SessionToken) []byte {
+    sum := sha256.Sum256([]byte(token))
+    return sum[:]
+}
+
+func (s *SQLSessionStore) Create(user UserId) (SessionToken, error) {
+    token := generateSessionToken()
+    now := time.Now().UTC()
+    q := `
+    INSERT INTO login_sessions (token_hash, user_id, created_at, last_seen)
+    VALUES (?, ?, ?, ?)
+    `
+    _, err := s.db.Exec(q, hashSessionToken(token), user, now, now)
+    if err != nil {
+        return "", err
+    }
+    return token, nil
+}
+
+func (s *SQLSessionStore) Lookup(token SessionToken) (UserId, error) {
+    hash := hashSessionToken(token)
+    q := `
+    SELECT user_id, created_at, last_seen FROM login_sessions WHERE token_hash = ?
+    `
+    var session loginSession
+    err := s.db.QueryRow(q, hash).Scan(&session.user, &session.created, &session.lastSeen)
+    if err == sql.ErrNoRows {
+        return -1, ErrInvalidToken
+    } else if err != nil {
+        return -1, err
+    }
+
+    now := time.Now().UTC()
+    if session.expired(now) {
+        s.Delete(token)
+        return -1, ErrInvalidToken
+    }
+
+    // Avoid a write on every single request
+    if now.Sub(session.lastSeen) > time.Minute {
+        q = `UPDATE login_sessions SET last_seen = ? WHERE token_hash = ?`
+        if _, err := s.db.Exec(q, now, hash); err != nil {
+            return -1, err
+        }
+    }
+    return session.user, nil
+}
+
+func (s *SQLSessionStore) Delete(token SessionToken) error {
+    q := `DELETE FROM login_sessions WHERE token_hash = ?`
+    _, err := s.db.Exec(q, hashSessionToken(token))
+    return err
+}
+
+func (s *SQLSessionStore) Sweep() error {
+    now := time.Now().UTC()
+    q := `DELETE FROM login_sessions WHERE last_seen < ? OR created_at < ?`
+    _, err := s.db.Exec(q, now.Add(-sessionIdleTimeout), now.Add(-sessionMaxAge))
+    return err
+}
+
+// Periodically removes expired sessions from the store.
+func sweepSessions(store SessionStore, interval time.Duration) {
+    for range time.Tick(interval) {
+        if err := store.Sweep(); err != nil {
+            debug.Println("[auth] Error sweeping sessions:", err)
+        }
+    }
+}
@@ -0,0 +1,145 @@
+package feline
+
+import (
+    "testing"
+    "time"
+)
+
+func TestMemorySessionStore(t *testing.T) {
+    store := NewMemorySessionStore()
+    token, err := store.Create(42)
+    if err != nil {
+        t.Fatalf("Create: %v", err)
+    }
+    other, err := store.Create(7)
+    if err != nil {
+        t.Fatalf("Create: %v", err)
+    }
+    if token == other {
+        t.Fatalf("Create gave the same token twice: %q", token)
+    }
+
+    if user, err := store.Lookup(token); err != nil || user != 42 {
+        t.Errorf("Lookup = %v, %v, want 42", user, err)
+    }
+    if user, err := store.Lookup(other); err != nil || user != 7 {
+        t.Errorf("Lookup = %v, %v, want 7", user, err)
+    }
+    if _, err := store.Lookup("not a token"); err != ErrInvalidToken {
+        t.Errorf("Lookup of an unknown token = %v, want ErrInvalidToken", err)
+    }
+
+    if err := store.Delete(token); err != nil {
+        t.Fatalf("Delete: %v", err)
+    }
+    if _, err := store.Lookup(token); err != ErrInvalidToken {
+        t.Errorf("Lookup after Delete = %v, want ErrInvalidToken", err)
+    }
+    if user, err := store.Lookup(other); err != nil || user != 7 {
+        t.Errorf("Lookup of the other session after Delete = %v, %v, want 7", user, err)
+    }
+}
+
+// Only a hash of each token is kept, so the stored keys can't be used
+// to log in.
+func TestMemorySessionStoreHashesTokens(t *testing.T) {
+    store := NewMemorySessionStore()
+    token, err := store.Create(42)
+    if err != nil {
+        t.Fatalf("Create: %v", err)
+    }
+    hash := string(hashSessionToken(token))
+    if _, exists := store.sessions[string(token)]; exists {
+        t.Errorf("the raw token is stored")
+    }
+    if _, exists := store.sessions[hash]; !exists {
+        t.Errorf("the token hash is not stored")
+    }
+    if _, err := store.Lookup(SessionToken(hash)); err != ErrInvalidToken {
+        t.Errorf("Lookup with the hash = %v, want ErrInvalidToken", err)
+    }
+    if user, err := store.Lookup(token); err != nil || user != 42 {
+        t.Errorf("Lookup with the token = %v, %v, want 42", user, err)
+    }
+}
+
+// Moves a session back in time.
+func ageSession(store *MemorySessionStore, token SessionToken, created time.Duration, lastSeen time.Duration) {
+    hash := string(hashSessionToken(token))
+    session := store.sessions[hash]
+    now := time.Now()
+    session.created = now.Add(-created)
+    session.lastSeen = now.Add(-lastSeen)
+    store.sessions[hash] = session
+}
+
+func TestMemorySessionStoreExpiry(t *testing.T) {
+    tests := []struct {
+        name string
+        created time.Duration
+        lastSeen time.Duration
+        valid bool
+    }{
+        {"new", 0, 0, true},
+        {"recently used", sessionMaxAge - time.Hour, time.Hour, true},
+        {"idle", 2 * sessionIdleTimeout, sessionIdleTimeout + time.Minute, false},
+        {"too old", sessionMaxAge + time.Minute, time.Minute, false},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            store := NewMemorySessionStore()
+            token, err := store.Create(42)
+            if err != nil {
+                t.Fatalf("Create: %v", err)
+            }
+            ageSession(store, token, test.created, test.lastSeen)
+            user, err := store.Lookup(token)
+            if test.valid && (err != nil || user != 42) {
+                t.Errorf("Lookup = %v, %v, want 42", user, err)
+            }
+            if !test.valid {
+                if err != ErrInvalidToken {
+                    t.Errorf("Lookup = %v, %v, want ErrInvalidToken", user, err)
+                }
+                if len(store.sessions) != 0 {
+                    t.Errorf("the expired session was not removed")
+                }
+            }
+        })
+    }
+}
+
+func TestMemorySessionStoreLookupRenews(t *testing.T) {
+    store := NewMemorySessionStore()
+    token, err := store.Create(42)
+    if err != nil {
+        t.Fatalf("Create: %v", err)
+    }
+    ageSession(store, token, sessionIdleTimeout, sessionIdleTimeout - time.Minute)
+    if _, err := store.Lookup(token); err != nil {
+        t.Fatalf("Lookup: %v", err)
+    }
+    session := store.sessions[string(hashSessionToken(token))]
+    if time.Since(session.lastSeen) > time.Minute {
+        t.Errorf("Lookup did not mark the session as used: last seen %v ago", time.Since(session.lastSeen))
+    }
+}
+
+func TestMemorySessionStoreSweep(t *testing.T) {
+    store := NewMemorySessionStore()
+    idle, _ := store.Create(1)
+    old, _ := store.Create(2)
+    fresh, _ := store.Create(3)
+    ageSession(store, idle, 2 * sessionIdleTimeout, sessionIdleTimeout + time.Minute)
+    ageSession(store, old, sessionMaxAge + time.Minute, time.Minute)
+
+    if err := store.Sweep(); err != nil {
+        t.Fatalf("Sweep: %v", err)
+    }
+    if len(store.sessions) != 1 {
+        t.Errorf("%d sessions left after Sweep, want 1", len(store.sessions))
+    }
+    if user, err := store.Lookup(fresh); err != nil || user != 3 {
+        t.Errorf("Lookup of the fresh session = %v, %v, want 3", user, err)
+    }
+}
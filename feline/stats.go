@@ -0,0 +1,175 @@
+package feline
+
+import (
+    "sort"
+    "time"
+)
+
+// Attempt is a single reveal of a line in the reviewer.
+type Attempt struct {
+    Line int `json:"line"`
+    AttemptedAt time.Time `json:"attempted_at"`
+    ReviewMethod string `json:"review_method"`
+    // Whether the actor graded their recall
+    Graded bool `json:"graded"`
+    Grade Grade `json:"grade"`
+    // Time from showing the cue until the line was revealed
+    RevealMs int `json:"reveal_ms"`
+    // Number of hints shown before revealing the line
+    HintsUsed int `json:"hints_used"`
+}
+
+// Recalls graded hard or better count as successful.
+func (attempt Attempt) Succeeded() bool {
+    return attempt.Graded && attempt.Grade != GradeAgain
+}
+
+type LineStats struct {
+    Line LineData `json:"line"`
+    Attempts int `json:"attempts"`
+    Graded int `json:"graded"`
+    Successes int `json:"successes"`
+    Misses int `json:"misses"`
+    // Successes out of graded attempts, from 0 to 1
+    SuccessRate float64 `json:"success_rate"`
+    PracticeSeconds float64 `json:"practice_seconds"`
+    LastAttempt *time.Time `json:"last_attempt"`
+}
+
+type LineSetStats struct {
+    LineSet LineSet `json:"line_set"`
+    Lines []LineStats `json:"lines"`
+    MostMissed []LineStats `json:"most_missed"`
+    Attempts int `json:"attempts"`
+    SuccessRate float64 `json:"success_rate"`
+    PracticeSeconds float64 `json:"practice_seconds"`
+    // Consecutive days with at least one attempt
+    CurrentStreak int `json:"current_streak"`
+    LongestStreak int `json:"longest_streak"`
+}
+
+const mostMissedCount = 5
+
+// Logs an attempt at a line. Graded attempts also reschedule the line
+// for spaced repetition, in which case the new schedule is returned.
+// The grade is lowered for any hints used.
+func RecordReview(user UserId, set LineSetId, attempt Attempt) (*ReviewState, error) {
+    attempt.AttemptedAt = time.Now().UTC()
+    attempt.RevealMs = max(attempt.RevealMs, 0)
+    attempt.HintsUsed = max(attempt.HintsUsed, 0)
+    if attempt.Graded {
+        attempt.Grade = attempt.Grade.WithHints(attempt.HintsUsed)
+    }
+    if err := RecordAttempt(user, set, attempt); err != nil {
+        return nil, err
+    }
+    if !attempt.Graded {
+        return nil, nil
+    }
+    state, err := GradeLine(set, attempt.Line, attempt.Grade)
+    if err != nil {
+        return nil, err
+    }
+    return &state, nil
+}
+
+// Summarizes a user's review history of a line set.
+func GetLineSetStats(user UserId, set LineSet) (LineSetStats, error) {
+    lines, err := GetLines(set.Id)
+    if err != nil {
+        return LineSetStats{}, err
+    }
+    attempts, err := GetAttempts(user, set.Id)
+    if err != nil {
+        return LineSetStats{}, err
+    }
+    return computeStats(set, lines, attempts, time.Now()), nil
+}
+
+func computeStats(set LineSet, lines []LineData, attempts []Attempt, now time.Time) LineSetStats {
+    stats := LineSetStats{LineSet: set, Lines: make([]LineStats, len(lines))}
+    byNumber := map[int]*LineStats{}
+    for i, line := range lines {
+        stats.Lines[i].Line = line
+        byNumber[line.Id] = &stats.Lines[i]
+    }
+
+    graded, successes := 0, 0
+    var days []string
+    for _, attempt := range attempts {
+        line, exists := byNumber[attempt.Line]
+        if !exists {
+            continue
+        }
+        seconds := float64(attempt.RevealMs) / 1000
+        line.Attempts++
+        line.PracticeSeconds += seconds
+        attemptedAt := attempt.AttemptedAt
+        line.LastAttempt = &attemptedAt
+        if attempt.Graded {
+            line.Graded++
+            graded++
+            if attempt.Succeeded() {
+                line.Successes++
+                successes++
+            } else {
+                line.Misses++
+            }
+        }
+
+        stats.Attempts++
+        stats.PracticeSeconds += seconds
+        days = append(days, attempt.AttemptedAt.Local().Format(time.DateOnly))
+    }
+
+    for i := range stats.Lines {
+        if stats.Lines[i].Graded > 0 {
+            stats.Lines[i].SuccessRate = float64(stats.Lines[i].Successes) / float64(stats.Lines[i].Graded)
+        }
+    }
+    if graded > 0 {
+        stats.SuccessRate = float64(successes) / float64(graded)
+    }
+
+    for _, line := range stats.Lines {
+        if line.Misses > 0 {
+            stats.MostMissed = append(stats.MostMissed, line)
+        }
+    }
+    sort.SliceStable(stats.MostMissed, func(i, j int) bool {
+        return stats.MostMissed[i].Misses > stats.MostMissed[j].Misses
+    })
+    if len(stats.MostMissed) > mostMissedCount {
+        stats.MostMissed = stats.MostMissed[:mostMissedCount]
+    }
+
+    stats.CurrentStreak, stats.LongestStreak = streaks(days, now)
+    return stats
+}
+
+// Computes the current and longest runs of consecutive days from a
+// sorted list of dates. The current streak still counts if the last
+// practice was yesterday.
+func streaks(days []string, now time.Time) (int, int) {
+    current, longest := 0, 0
+    var previous time.Time
+    for _, day := range days {
+        date, err := time.Parse(time.DateOnly, day)
+        if err != nil || date.Equal(previous) {
+            continue
+        }
+        if !previous.IsZero() && date.Equal(previous.AddDate(0, 0, 1)) {
+            current++
+        } else {
+            current = 1
+        }
+        previous = date
+        longest = max(longest, current)
+    }
+
+    today, _ := time.Parse(time.DateOnly, now.Local().Format(time.DateOnly))
+    if previous.IsZero() || previous.Before(today.AddDate(0, 0, -1)) {
+        current = 0
+    }
+    return current, longest
+}
@@ -0,0 +1,248 @@
+package feline
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/ruuzia/lynx/linefile"
+)
+
+// Cues and lines without a recording can be read aloud by a text to
+// speech engine, so that actors can rehearse without looking at the
+// screen. Each role in a line set can be given its own voice. Speech is
+// generated the first time it is asked for and cached, keyed by the
+// text and voice, so editing a line or changing a voice makes it
+// generate again.
+
+// A SpeechSynthesizer turns text into audio.
+type SpeechSynthesizer interface {
+    // Speaks the text in a voice, or in the default voice if empty.
+    Synthesize(ctx context.Context, text string, voice string) ([]byte, error)
+    // The type of audio Synthesize returns.
+    ContentType() string
+    // Identifies the engine and its settings, so that speech from one
+    // is not taken from the cache for another.
+    Name() string
+}
+
+var (
+    ErrNoSynthesizer = errors.New("Text to speech is not set up on this server.")
+    ErrInvalidVoice = errors.New("Voice names can only contain letters, digits and -_+.:/ and cannot start with '-'.")
+)
+
+// The synthesizer used to read cues aloud, or nil if there is none.
+// Set from the -tts flag.
+var Synthesizer SpeechSynthesizer
+
+// Where generated speech is kept.
+var SpeechCache AudioStore = DiskAudioStore{Dir: filepath.Join("audio", "speech")}
+
+// How long the synthesizer may take to speak one cue or line.
+const synthesizeTimeout = 30 * time.Second
+
+// CommandSynthesizer runs a program such as espeak-ng or piper for each
+// piece of text, writing the text to its standard input and reading the
+// audio from its standard output.
+type CommandSynthesizer struct {
+    // The program and its arguments, where "{voice}" stands for the voice
+    Command []string
+    DefaultVoice string
+    // The type of audio the program writes
+    AudioType string
+}
+
+// Runs a program with the given input and returns its output. Tests can
+// replace it to avoid needing a speech engine installed.
+var runCommand = func(ctx context.Context, name string, args []string, stdin io.Reader) ([]byte, error) {
+    cmd := exec.CommandContext(ctx, name, args...)
+    cmd.Stdin = stdin
+    var stderr bytes.Buffer
+    cmd.Stderr = &stderr
+    out, err := cmd.Output()
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+    }
+    return out, nil
+}
+
+// NewCommandSynthesizer reads a command line such as
+// "espeak-ng --stdout -v {voice}". Arguments are split at spaces.
+func NewCommandSynthesizer(command string, defaultVoice string) (*CommandSynthesizer, error) {
+    fields := strings.Fields(command)
+    if len(fields) == 0 {
+        return nil, errors.New("empty text to speech command")
+    }
+    if err := checkVoice(defaultVoice); err != nil {
+        return nil, err
+    }
+    if defaultVoice == "" && strings.Contains(command, "{voice}") {
+        return nil, errors.New("the text to speech command takes a {voice}, so it needs a default voice")
+    }
+    return &CommandSynthesizer{Command: fields, DefaultVoice: defaultVoice, AudioType: "audio/wav"}, nil
+}
+
+func (s *CommandSynthesizer) Synthesize(ctx context.Context, text string, voice string) ([]byte, error) {
+    if voice == "" {
+        voice = s.DefaultVoice
+    }
+    args := make([]string, len(s.Command) - 1)
+    for i, arg := range s.Command[1:] {
+        args[i] = strings.ReplaceAll(arg, "{voice}", voice)
+    }
+    audio, err := runCommand(ctx, s.Command[0], args, strings.NewReader(text))
+    if err == nil && len(audio) == 0 {
+        err = fmt.Errorf("%s wrote no audio", s.Command[0])
+    }
+    return audio, err
+}
+
+func (s *CommandSynthesizer) ContentType() string {
+    return s.AudioType
+}
+
+func (s *CommandSynthesizer) Name() string {
+    return strings.Join(s.Command, " ")
+}
+
+// Voices are passed to the synthesizer as arguments, so they are kept
+// to names that cannot be mistaken for options.
+func checkVoice(voice string) error {
+    if len(voice) > 255 || strings.HasPrefix(voice, "-") {
+        return ErrInvalidVoice
+    }
+    for _, c := range voice {
+        isAlnum := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+        if !isAlnum && !strings.ContainsRune("-_+.:/", c) {
+            return ErrInvalidVoice
+        }
+    }
+    return nil
+}
+
+// A role in a line set and the voice it is read in, empty for the
+// default.
+type RoleVoice struct {
+    Role string `json:"role"`
+    Voice string `json:"voice"`
+}
+
+// Lists the roles speaking in a line set's cues and lines, in order of
+// appearance, with their voices.
+func GetVoices(set LineSetId) ([]RoleVoice, error) {
+    lines, err := GetLines(set)
+    if err != nil {
+        return nil, err
+    }
+    voices, err := GetRoleVoices(set)
+    if err != nil {
+        return nil, err
+    }
+    roles := []RoleVoice{}
+    seen := map[string]bool{}
+    for _, line := range lines {
+        for _, text := range []string{line.Cue, line.Line} {
+            role, _ := splitRole(text)
+            role = strings.ToUpper(role)
+            if role != "" && !seen[role] && text != linefile.SceneStartCue {
+                seen[role] = true
+                roles = append(roles, RoleVoice{Role: role, Voice: voices[role]})
+            }
+        }
+    }
+    return roles, nil
+}
+
+// Picks the voices roles are read in. Roles left out or given an empty
+// voice use the default.
+func SetVoices(set LineSetId, voices []RoleVoice) error {
+    picked := map[string]string{}
+    for _, voice := range voices {
+        voice.Voice = strings.TrimSpace(voice.Voice)
+        if err := checkVoice(voice.Voice); err != nil {
+            return err
+        }
+        if role := strings.ToUpper(strings.TrimSpace(voice.Role)); role != "" && voice.Voice != "" {
+            picked[role] = voice.Voice
+        }
+    }
+    return SetRoleVoices(set, picked)
+}
+
+func speechKey(text string, voice string) string {
+    sum := sha256.Sum256([]byte(Synthesizer.Name() + "\x00" + voice + "\x00" + text))
+    return hex.EncodeToString(sum[:16])
+}
+
+// Returns the spoken cue or line, generating it if it is not cached.
+func LineSpeech(set LineSetId, line LineData, part string) ([]byte, error) {
+    if Synthesizer == nil {
+        return nil, ErrNoSynthesizer
+    }
+    text := line.Line
+    if part == AudioCue {
+        text = line.Cue
+    }
+    role, words := splitRole(text)
+    if text == linefile.SceneStartCue || strings.TrimSpace(words) == "" {
+        return nil, ErrNoAudio
+    }
+    voices, err := GetRoleVoices(set)
+    if err != nil {
+        return nil, err
+    }
+    return cachedSpeech(words, voices[strings.ToUpper(role)])
+}
+
+// Returns the text spoken in a voice, from the cache if it has been
+// spoken before.
+func cachedSpeech(text string, voice string) ([]byte, error) {
+    key := speechKey(text, voice)
+    if cached, err := SpeechCache.Open(key); err == nil {
+        defer cached.Close()
+        return io.ReadAll(cached)
+    } else if !errors.Is(err, os.ErrNotExist) {
+        return nil, err
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), synthesizeTimeout)
+    defer cancel()
+    audio, err := Synthesizer.Synthesize(ctx, text, voice)
+    if err != nil {
+        return nil, err
+    }
+    if err := SpeechCache.Save(key, audio); err != nil {
+        debug.Println("[tts] Error caching speech:", err)
+    }
+    return audio, nil
+}
+
+// Responds with the recording of a cue or line if there is one, or else
+// with it read aloud by the synthesizer.
+func serveLineSpeech(w http.ResponseWriter, r *http.Request, set LineSetId, lineNumber int, part string) error {
+    err := serveLineAudio(w, r, set, lineNumber, part)
+    if !errors.Is(err, ErrNoAudio) {
+        return err
+    }
+    line, err := GetLine(set, lineNumber)
+    if err != nil {
+        return err
+    }
+    audio, err := LineSpeech(set, line, part)
+    if err != nil {
+        return err
+    }
+    w.Header().Set("Content-Type", Synthesizer.ContentType())
+    w.Header().Set("Cache-Control", "private, no-cache")
+    http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(audio))
+    return nil
+}
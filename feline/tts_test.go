@@ -0,0 +1,151 @@
+package feline
+
+import (
+    "context"
+    "errors"
+    "io"
+    "reflect"
+    "strings"
+    "sync"
+    "testing"
+)
+
+type commandRun struct {
+    name string
+    args []string
+    stdin string
+}
+
+// Replaces runCommand for the length of a test. Each run is recorded
+// and answered with output and err.
+func stubRunCommand(t *testing.T, output string, err error) *[]commandRun {
+    var mutex sync.Mutex
+    runs := []commandRun{}
+    old := runCommand
+    runCommand = func(ctx context.Context, name string, args []string, stdin io.Reader) ([]byte, error) {
+        text, _ := io.ReadAll(stdin)
+        mutex.Lock()
+        defer mutex.Unlock()
+        runs = append(runs, commandRun{name, args, string(text)})
+        return []byte(output), err
+    }
+    t.Cleanup(func() { runCommand = old })
+    return &runs
+}
+
+func TestCommandSynthesizer(t *testing.T) {
+    tests := []struct {
+        name string
+        voice string
+        args []string
+    }{
+        {"voice", "en-gb", []string{"--stdout", "-v", "en-gb", "--voice={en-gb}"}},
+        {"default voice", "", []string{"--stdout", "-v", "en-us", "--voice={en-us}"}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            runs := stubRunCommand(t, "RIFF", nil)
+            synth, err := NewCommandSynthesizer("espeak-ng --stdout -v {voice} --voice={{voice}}", "en-us")
+            if err != nil {
+                t.Fatalf("NewCommandSynthesizer: %v", err)
+            }
+            audio, err := synth.Synthesize(context.Background(), "Aaaagh! I am slain.", test.voice)
+            if err != nil || string(audio) != "RIFF" {
+                t.Fatalf("Synthesize = %q, %v, want RIFF", audio, err)
+            }
+            want := []commandRun{{"espeak-ng", test.args, "Aaaagh! I am slain."}}
+            if !reflect.DeepEqual(*runs, want) {
+                t.Errorf("ran %+v, want %+v", *runs, want)
+            }
+        })
+    }
+}
+
+func TestCommandSynthesizerErrors(t *testing.T) {
+    stubRunCommand(t, "", nil)
+    synth, err := NewCommandSynthesizer("espeak-ng --stdout", "")
+    if err != nil {
+        t.Fatalf("NewCommandSynthesizer: %v", err)
+    }
+    if _, err := synth.Synthesize(context.Background(), "Hello.", ""); err == nil {
+        t.Errorf("Synthesize with no output succeeded")
+    }
+
+    failure := errors.New("no such voice")
+    stubRunCommand(t, "RIFF", failure)
+    if _, err := synth.Synthesize(context.Background(), "Hello.", ""); !errors.Is(err, failure) {
+        t.Errorf("Synthesize = %v, want %v", err, failure)
+    }
+}
+
+func TestNewCommandSynthesizer(t *testing.T) {
+    tests := []struct {
+        command string
+        voice string
+        ok bool
+    }{
+        {"espeak-ng --stdout", "", true},
+        {"espeak-ng --stdout -v {voice}", "en-us", true},
+        {"espeak-ng --stdout -v {voice}", "", false},
+        {"   ", "en-us", false},
+        {"espeak-ng --stdout -v {voice}", "--help", false},
+        {"espeak-ng --stdout -v {voice}", "en us", false},
+    }
+    for _, test := range tests {
+        _, err := NewCommandSynthesizer(test.command, test.voice)
+        if (err == nil) != test.ok {
+            t.Errorf("NewCommandSynthesizer(%q, %q) = %v, want ok %t", test.command, test.voice, err, test.ok)
+        }
+    }
+}
+
+func TestSpeechCache(t *testing.T) {
+    runs := stubRunCommand(t, "RIFF", nil)
+    synth, err := NewCommandSynthesizer("espeak-ng --stdout -v {voice}", "en-us")
+    if err != nil {
+        t.Fatalf("NewCommandSynthesizer: %v", err)
+    }
+    oldSynth, oldCache := Synthesizer, SpeechCache
+    Synthesizer, SpeechCache = synth, DiskAudioStore{Dir: t.TempDir()}
+    t.Cleanup(func() { Synthesizer, SpeechCache = oldSynth, oldCache })
+
+    speak := func(text string, voice string) {
+        audio, err := cachedSpeech(text, voice)
+        if err != nil || string(audio) != "RIFF" {
+            t.Fatalf("cachedSpeech(%q, %q) = %q, %v, want RIFF", text, voice, audio, err)
+        }
+    }
+    speak("Oh no! Poco!", "")
+    speak("Oh no! Poco!", "")
+    speak("Oh no! Poco!", "en-gb")
+    speak("Oh no! Poco!", "en-gb")
+    speak("Aaaagh! I am slain.", "")
+    speak("Oh no! Poco!", "")
+    if len(*runs) != 3 {
+        t.Errorf("synthesized %d times, want once for each text and voice: %+v", len(*runs), *runs)
+    }
+
+    keys, err := SpeechCache.Keys()
+    if err != nil {
+        t.Fatalf("Keys: %v", err)
+    }
+    if len(keys) != 3 {
+        t.Errorf("%d clips cached, want 3: %v", len(keys), keys)
+    }
+    for _, key := range keys {
+        if strings.Contains(key, "Poco") {
+            t.Errorf("cache key %q contains the text", key)
+        }
+    }
+
+    // Speech from another engine is not reused
+    other, err := NewCommandSynthesizer("piper --output-raw", "")
+    if err != nil {
+        t.Fatalf("NewCommandSynthesizer: %v", err)
+    }
+    Synthesizer = other
+    speak("Oh no! Poco!", "")
+    if len(*runs) != 4 {
+        t.Errorf("synthesized %d times, want 4 after changing engine", len(*runs))
+    }
+}
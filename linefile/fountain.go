@@ -0,0 +1,158 @@
+package linefile
+
+import (
+    "strings"
+)
+
+// Fountain (https://fountain.io) is plain text screenplay markup. Only
+// what matters for learning lines is read: character names, their
+// dialogue, and sections and scene headings, which become acts and
+// scenes. Action, transitions, parentheticals, notes and the title
+// page are left out.
+//
+//     INT. KITCHEN - NIGHT
+//
+//     RUFUS
+//     (quietly)
+//     What a lovely day it is.
+//
+//     @Poco
+//     I couldn't agree more.
+
+var titlePageKeys = []string{"title", "credit", "author", "authors", "source", "draft date", "contact", "copyright", "notes", "revision"}
+
+// Whether the script starts with a Fountain title page, like
+// "Title: Lynx".
+func hasTitlePage(lines []string) bool {
+    for _, line := range lines {
+        if strings.TrimSpace(line) == "" {
+            continue
+        }
+        key, _, found := strings.Cut(line, ":")
+        if !found {
+            return false
+        }
+        for _, titleKey := range titlePageKeys {
+            if strings.EqualFold(strings.TrimSpace(key), titleKey) {
+                return true
+            }
+        }
+        return false
+    }
+    return false
+}
+
+// Blanks out boneyard /* comments */ and [[notes]], keeping newlines
+// so that line numbers stay the same.
+func stripFountainComments(text string) string {
+    var sb strings.Builder
+    for len(text) > 0 {
+        start, end := "/*", "*/"
+        i := strings.Index(text, "/*")
+        if j := strings.Index(text, "[["); j >= 0 && (i < 0 || j < i) {
+            i, start, end = j, "[[", "]]"
+        }
+        if i < 0 {
+            sb.WriteString(text)
+            break
+        }
+        sb.WriteString(text[:i])
+        k := strings.Index(text[i + len(start):], end)
+        if k < 0 {
+            k = len(text) - i - len(start)
+        } else {
+            k += len(end)
+        }
+        removed := text[i:i + len(start) + k]
+        sb.WriteString(strings.Repeat("\n", strings.Count(removed, "\n")))
+        text = text[i + len(start) + k:]
+    }
+    return sb.String()
+}
+
+// Removes *emphasis* and _underline_ markers.
+func stripEmphasis(s string) string {
+    s = strings.ReplaceAll(s, "*", "")
+    return strings.TrimSpace(strings.ReplaceAll(s, "_", ""))
+}
+
+func importFountain(text string, options ImportOptions) (ImportResult, error) {
+    lines := splitLines(stripFountainComments(text))
+    start := 0
+    if hasTitlePage(lines) {
+        for start < len(lines) && strings.TrimSpace(lines[start]) == "" {
+            start++
+        }
+        for start < len(lines) && strings.TrimSpace(lines[start]) != "" {
+            start++
+        }
+    }
+
+    var drafts []draft
+    var act, scene string
+    for i := start; i < len(lines); i++ {
+        line := strings.TrimSpace(lines[i])
+        previousBlank := i == 0 || strings.TrimSpace(lines[i - 1]) == ""
+        nextBlank := i + 1 >= len(lines) || strings.TrimSpace(lines[i + 1]) == ""
+
+        switch {
+        case line == "" || strings.HasPrefix(line, "="):
+            // Page breaks and synopses
+            continue
+        case strings.HasPrefix(line, "#"):
+            level := len(line) - len(strings.TrimLeft(line, "#"))
+            title := strings.TrimSpace(line[level:])
+            if level == 1 {
+                act, scene = title, ""
+            } else if level == 2 {
+                scene = title
+            }
+            continue
+        case strings.HasPrefix(line, ".") && !strings.HasPrefix(line, ".."):
+            scene = strings.TrimSpace(line[1:])
+            continue
+        case isSceneHeading(line) && previousBlank:
+            scene = line
+            continue
+        case strings.HasPrefix(line, "!") || strings.HasPrefix(line, ">") || isTransition(line):
+            continue
+        }
+
+        name, isCue := cueName(line)
+        forced := strings.HasPrefix(line, "@")
+        if forced {
+            name = strings.TrimSpace(strings.TrimSuffix(line[1:], "^"))
+            if open := strings.Index(name, "("); open > 0 {
+                name = strings.TrimSpace(name[:open])
+            }
+            isCue = name != ""
+        }
+        if !isCue || !previousBlank || nextBlank {
+            // Action
+            continue
+        }
+
+        d := draft{
+            speech: Speech{Role: name, Act: act, Scene: scene},
+            line: i + 1,
+            source: line,
+            certain: forced,
+            alternative: readAsDirections,
+        }
+        var speech []string
+        for i + 1 < len(lines) && lines[i + 1] != "" {
+            i++
+            // A line of just spaces keeps the dialogue going
+            dialogue := strings.TrimSpace(lines[i])
+            if dialogue == "" || isParenthetical(dialogue) {
+                continue
+            }
+            speech = append(speech, stripEmphasis(strings.TrimPrefix(dialogue, "~")))
+        }
+        d.speech.Text = strings.Join(speech, " ")
+        if d.speech.Text != "" {
+            drafts = append(drafts, d)
+        }
+    }
+    return finishDrafts(drafts, options), nil
+}
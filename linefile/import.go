@@ -0,0 +1,313 @@
+package linefile
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "unicode"
+)
+
+// Scripts can be imported from formats other than `ROLE: text` scenes.
+// Each format has an Importer that reads the script into speeches.
+// Real scripts are messy, so importers guess when a line could be read
+// more than one way, and report those lines as ambiguous. The user can
+// then confirm each guess or ask for the other reading by importing
+// again with the line in ImportOptions.Alternatives.
+
+// An Ambiguity is a line the importer had to guess about.
+type Ambiguity struct {
+    // Line number in the script, starting at 1
+    Line int `json:"line"`
+    Text string `json:"text"`
+    // How the line was read, and how it would be read instead
+    Guess string `json:"guess"`
+    Alternative string `json:"alternative"`
+}
+
+type ImportOptions struct {
+    // Line numbers of ambiguous lines to read the other way
+    Alternatives map[int]bool
+}
+
+type ImportResult struct {
+    Speeches []Speech `json:"speeches"`
+    Ambiguous []Ambiguity `json:"ambiguous"`
+}
+
+type Importer interface {
+    Import(text string, options ImportOptions) (ImportResult, error)
+}
+
+// ImporterFunc lets an ordinary function be used as an Importer.
+type ImporterFunc func(text string, options ImportOptions) (ImportResult, error)
+
+func (f ImporterFunc) Import(text string, options ImportOptions) (ImportResult, error) {
+    return f(text, options)
+}
+
+// The format of `ROLE: text` scenes read by ParseScene.
+const FormatScene = "scene"
+
+// Picks the format by looking at the script.
+const FormatAuto = "auto"
+
+var importers = map[string]Importer{}
+
+var ErrUnknownFormat = errors.New("Unknown script format")
+
+// RegisterImporter makes an importer available under a format name.
+func RegisterImporter(format string, importer Importer) {
+    importers[format] = importer
+}
+
+// ImportFormats lists the registered formats by name.
+func ImportFormats() []string {
+    var formats []string
+    for format := range importers {
+        formats = append(formats, format)
+    }
+    sort.Strings(formats)
+    return formats
+}
+
+func init() {
+    RegisterImporter(FormatScene, ImporterFunc(func(text string, options ImportOptions) (ImportResult, error) {
+        speeches, err := ParseSceneString(text)
+        return ImportResult{Speeches: speeches}, err
+    }))
+    RegisterImporter("fountain", ImporterFunc(importFountain))
+    RegisterImporter("screenplay", ImporterFunc(importScreenplay))
+    RegisterImporter("plain", ImporterFunc(importPlainText))
+}
+
+// Import reads a script in the given format, or in the format
+// DetectFormat picks for FormatAuto. Returns the format used.
+func Import(text string, format string, options ImportOptions) (ImportResult, string, error) {
+    if format == "" || format == FormatAuto {
+        format = DetectFormat(text)
+    }
+    importer, exists := importers[format]
+    if !exists {
+        return ImportResult{}, format, fmt.Errorf("%w %q", ErrUnknownFormat, format)
+    }
+    result, err := importer.Import(text, options)
+    return result, format, err
+}
+
+// DetectFormat guesses the format of a script: Fountain if it starts
+// with a title page, which would otherwise read as a `ROLE: text`
+// speech, a scene if it parses as one, Fountain if it uses markup only
+// Fountain has, a screenplay if it has several character names on
+// lines of their own, and plain text otherwise.
+func DetectFormat(text string) string {
+    lines := splitLines(text)
+    if hasTitlePage(lines) {
+        return "fountain"
+    }
+    if speeches, err := ParseSceneString(text); err == nil && len(speeches) > 0 {
+        return FormatScene
+    }
+    // Names on their own line, and speeches starting with a name
+    cues, speeches := 0, 0
+    for i, line := range lines {
+        trimmed := strings.TrimSpace(line)
+        if strings.HasPrefix(trimmed, "[[") || strings.HasPrefix(trimmed, "/*") ||
+            (strings.HasPrefix(trimmed, "@") && len(trimmed) > 1) ||
+            (strings.HasPrefix(trimmed, ">") && strings.HasSuffix(trimmed, "<")) {
+            return "fountain"
+        }
+        if _, _, isHeading := plainHeading(trimmed); isHeading {
+            continue
+        }
+        if _, ok := cueName(trimmed); ok && i + 1 < len(lines) && strings.TrimSpace(lines[i + 1]) != "" {
+            cues++
+        } else if _, _, ok := splitSpeech(trimmed); ok {
+            speeches++
+        }
+    }
+    if cues >= 2 && cues > speeches {
+        return "screenplay"
+    }
+    return "plain"
+}
+
+// A speech or block of text as first read by an importer, before
+// ambiguous lines are settled.
+type draft struct {
+    speech Speech
+    line int
+    // The text as written in the script
+    source string
+    // Set for speeches the format marks unmistakably, like a forced
+    // Fountain character
+    certain bool
+    // How a speech would be read if it is not a speech: either
+    // readAsDirections or readAsContinuation. Text that is not a
+    // speech, with an empty role, is read as directions unless its
+    // alternative is picked, making it part of the speech before it.
+    alternative string
+}
+
+const (
+    readAsDirections = "stage directions"
+    readAsContinuation = "part of the speech before it"
+)
+
+var digitNames = []string{"ZERO", "ONE", "TWO", "THREE", "FOUR", "FIVE", "SIX", "SEVEN", "EIGHT", "NINE"}
+
+// RoleName turns a character name from a script into a role name that
+// line files accept, which has only letters and '/'. Spaces and
+// punctuation are dropped and digits are spelled out, so "OLD MAN"
+// becomes "OLDMAN" and "GUARD 2" becomes "GUARDTWO". Names with no
+// letters left become "SOMEONE".
+func RoleName(name string) string {
+    var sb strings.Builder
+    for _, c := range name {
+        if isAlpha(c) || c == '/' {
+            sb.WriteRune(c)
+        } else if '0' <= c && c <= '9' {
+            digit := digitNames[c - '0']
+            if !isUpperCase(name) {
+                digit = digit[:1] + strings.ToLower(digit[1:])
+            }
+            sb.WriteString(digit)
+        }
+    }
+    role := strings.Trim(sb.String(), "/")
+    if role == "" {
+        return "SOMEONE"
+    }
+    return role
+}
+
+// Turns drafts into speeches. Speeches by a role that speaks only once
+// are reported as ambiguous, since they are often action or other text
+// that happens to look like a speech, as is text between speeches.
+// Role names are made into ones line files accept.
+func finishDrafts(drafts []draft, options ImportOptions) ImportResult {
+    speaks := map[string]int{}
+    for i := range drafts {
+        if drafts[i].speech.Role != "" {
+            drafts[i].speech.Role = RoleName(drafts[i].speech.Role)
+        }
+    }
+    for _, d := range drafts {
+        if d.speech.Role != "" {
+            speaks[strings.ToUpper(d.speech.Role)]++
+        }
+    }
+
+    var result ImportResult
+    for _, d := range drafts {
+        var previous *Speech
+        if n := len(result.Speeches); n > 0 {
+            last := &result.Speeches[n - 1]
+            if last.Act == d.speech.Act && last.Scene == d.speech.Scene {
+                previous = last
+            }
+        }
+        other := options.Alternatives[d.line]
+
+        if d.speech.Role == "" {
+            if previous == nil {
+                continue
+            }
+            result.Ambiguous = append(result.Ambiguous, Ambiguity{
+                Line: d.line,
+                Text: d.source,
+                Guess: readAsDirections,
+                Alternative: readAsContinuation,
+            })
+            if other {
+                previous.Text = strings.TrimSpace(previous.Text + " " + d.speech.Text)
+            }
+            continue
+        }
+
+        if !d.certain && speaks[strings.ToUpper(d.speech.Role)] == 1 &&
+            (d.alternative == readAsDirections || previous != nil) {
+            result.Ambiguous = append(result.Ambiguous, Ambiguity{
+                Line: d.line,
+                Text: d.source,
+                Guess: "a speech by " + d.speech.Role,
+                Alternative: d.alternative,
+            })
+            if other {
+                if d.alternative == readAsContinuation {
+                    previous.Text = strings.TrimSpace(previous.Text + " " + d.source)
+                }
+                continue
+            }
+        }
+        result.Speeches = append(result.Speeches, d.speech)
+    }
+    return result
+}
+
+// Reads a character name written on a line of its own, as in
+// screenplays: upper case, at most a few words, and optionally followed
+// by an extension like "(V.O.)" or "(CONT'D)".
+func cueName(s string) (string, bool) {
+    name := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "^"))
+    for strings.HasSuffix(name, ")") {
+        open := strings.LastIndex(name, "(")
+        if open < 0 {
+            return "", false
+        }
+        name = strings.TrimSpace(name[:open])
+    }
+    if name == "" || len([]rune(name)) > 40 || len(strings.Fields(name)) > 5 {
+        return "", false
+    }
+    if !unicode.IsLetter([]rune(name)[0]) || !isUpperCase(name) {
+        return "", false
+    }
+    if isSceneHeading(name) || isTransition(name) || strings.HasSuffix(name, ":") {
+        return "", false
+    }
+    return name, true
+}
+
+// Whether s has letters and none of them are lower case.
+func isUpperCase(s string) bool {
+    hasLetter := false
+    for _, c := range s {
+        if unicode.IsLower(c) {
+            return false
+        }
+        hasLetter = hasLetter || unicode.IsLetter(c)
+    }
+    return hasLetter
+}
+
+// Scene headings like "INT. KITCHEN - NIGHT".
+func isSceneHeading(s string) bool {
+    upper := strings.ToUpper(strings.TrimSpace(s))
+    for _, prefix := range []string{"INT", "EXT", "EST", "INT./EXT", "INT/EXT", "I/E"} {
+        if rest, found := strings.CutPrefix(upper, prefix); found && (strings.HasPrefix(rest, ".") || strings.HasPrefix(rest, " ")) {
+            return true
+        }
+    }
+    return false
+}
+
+// Transitions like "CUT TO:" or "FADE OUT."
+func isTransition(s string) bool {
+    s = strings.TrimSpace(s)
+    if !isUpperCase(s) {
+        return false
+    }
+    return strings.HasSuffix(s, "TO:") || strings.HasPrefix(s, "FADE ") || s == "FADE IN:"
+}
+
+// Parentheticals like "(beat)" written on their own line.
+func isParenthetical(s string) bool {
+    s = strings.TrimSpace(s)
+    return strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+}
+
+// Splits text into lines with line endings removed.
+func splitLines(text string) []string {
+    return strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
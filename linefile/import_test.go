@@ -0,0 +1,301 @@
+package linefile
+
+import (
+    "errors"
+    "reflect"
+    "testing"
+)
+
+func TestRoleName(t *testing.T) {
+    tests := []struct {
+        name string
+        want string
+    }{
+        {"RUFUS", "RUFUS"},
+        {"ALL/RUFUS", "ALL/RUFUS"},
+        {"OLD MAN", "OLDMAN"},
+        {"MRS. SMITH", "MRSSMITH"},
+        {"Mrs. Smith", "MrsSmith"},
+        {"O'BRIEN", "OBRIEN"},
+        {"GUARD 2", "GUARDTWO"},
+        {"Guard 2", "GuardTwo"},
+        {"JOSÉ", "JOS"},
+        {"/", "SOMEONE"},
+        {"李", "SOMEONE"},
+    }
+    for _, test := range tests {
+        got := RoleName(test.name)
+        if got != test.want {
+            t.Errorf("RoleName(%q) = %q, want %q", test.name, got, test.want)
+        }
+        if !HasLineFormat(got + ": text") {
+            t.Errorf("RoleName(%q) = %q, which line files do not accept", test.name, got)
+        }
+        if again := RoleName(got); again != got {
+            t.Errorf("RoleName(%q) = %q, want it unchanged", got, again)
+        }
+    }
+}
+
+// Lines made from any importer can be written to a line file and read
+// back the same.
+func TestImportRoundTrip(t *testing.T) {
+    tests := []struct {
+        format string
+        text string
+        roles []string
+        want []string
+    }{
+        {
+            format: "screenplay",
+            text: "INT. HUT - NIGHT\n\n" +
+                "          OLD MAN\n     Who goes there?\n\n" +
+                "          MRS. SMITH (V.O.)\n     Only me.\n\n" +
+                "          GUARD 2\n     (whispering)\n     Halt!\n\n" +
+                "          OLD MAN\n     Fine.\n",
+            roles: []string{"MRS. SMITH", "GUARDTWO"},
+            want: []string{"MRSSMITH: Only me.", "GUARDTWO: Halt!"},
+        },
+        {
+            format: "plain",
+            text: "OLD MAN: Who goes there?\nMrs. Smith: Only me.\nGUARD 2: Halt!\nOLD MAN: Fine.\n",
+            roles: []string{"mrssmith", "Guard 2"},
+            want: []string{"MrsSmith: Only me.", "GUARDTWO: Halt!"},
+        },
+        {
+            format: "fountain",
+            text: "Title: The Hut\n\nINT. HUT - NIGHT\n\n" +
+                "OLD MAN\nWho goes there?\n\n" +
+                "@Mrs. Smith\nOnly me.\n\n" +
+                "GUARD 2\n(whispering)\nHalt!\n\n" +
+                "OLD MAN ^\nFine.\n",
+            roles: []string{"OLDMAN"},
+            want: []string{"OLDMAN: Who goes there?", "OLDMAN: Fine."},
+        },
+        {
+            format: FormatScene,
+            text: "# Act One\nOLD/MAN: Who goes there?\nSMITH: Only me.\n",
+            roles: []string{"SMITH"},
+            want: []string{"SMITH: Only me."},
+        },
+    }
+    for _, test := range tests {
+        t.Run(test.format, func(t *testing.T) {
+            result, format, err := Import(test.text, test.format, ImportOptions{})
+            if err != nil || format != test.format {
+                t.Fatalf("Import = %q, %v", format, err)
+            }
+            lines := PairsForRoles(result.Speeches, test.roles)
+            var got []string
+            for _, line := range lines {
+                got = append(got, line.Line)
+            }
+            if !reflect.DeepEqual(got, test.want) {
+                t.Errorf("lines = %q, want %q", got, test.want)
+            }
+
+            text := Format(lines)
+            parsed, err := ParseString(text)
+            if err != nil {
+                t.Fatalf("ParseString(Format(lines)): %v\n%s", err, text)
+            }
+            if !reflect.DeepEqual(parsed, lines) {
+                t.Errorf("round trip =\n%#v\nwant\n%#v", parsed, lines)
+            }
+        })
+    }
+}
+
+func TestImport(t *testing.T) {
+    tests := []struct {
+        name string
+        format string
+        text string
+        alternatives []int
+        want []Speech
+        ambiguous []Ambiguity
+    }{
+        {
+            name: "screenplay multi-word cues and parentheticals",
+            format: "screenplay",
+            text: "INT. HUT - NIGHT\n\n" +
+                "          OLD MAN\n     Who goes there?\n\n" +
+                "          MRS. SMITH (V.O.)\n     (whispering)\n     Only me.\n\n" +
+                "          OLD MAN\n     (turning to\n     the door)\n     Come in,\n     then.\n" +
+                "2.\n" +
+                "          MRS. SMITH (CONT'D)\n     Thank you.\n",
+            want: []Speech{
+                {Role: "OLDMAN", Text: "Who goes there?", Scene: "INT. HUT - NIGHT"},
+                {Role: "MRSSMITH", Text: "Only me.", Scene: "INT. HUT - NIGHT"},
+                {Role: "OLDMAN", Text: "Come in, then.", Scene: "INT. HUT - NIGHT"},
+                {Role: "MRSSMITH", Text: "Thank you.", Scene: "INT. HUT - NIGHT"},
+            },
+        },
+        {
+            name: "screenplay action after dialogue",
+            format: "screenplay",
+            text: "          RUFUS\n     Hi.\nRufus waves.\n          RUFUS\n     (MORE)\n     Bye.\n\nFADE OUT.\n",
+            want: []Speech{{Role: "RUFUS", Text: "Hi."}, {Role: "RUFUS", Text: "Bye."}},
+        },
+        {
+            name: "screenplay ambiguous cue",
+            format: "screenplay",
+            text: "          RUFUS\n     Hi.\n\n          SIGN\n     Keep out.\n\n          RUFUS\n     Bye.\n",
+            want: []Speech{{Role: "RUFUS", Text: "Hi."}, {Role: "SIGN", Text: "Keep out."}, {Role: "RUFUS", Text: "Bye."}},
+            ambiguous: []Ambiguity{{Line: 4, Text: "SIGN", Guess: "a speech by SIGN", Alternative: readAsDirections}},
+        },
+        {
+            name: "screenplay ambiguous cue read as directions",
+            format: "screenplay",
+            text: "          RUFUS\n     Hi.\n\n          SIGN\n     Keep out.\n\n          RUFUS\n     Bye.\n",
+            alternatives: []int{4},
+            want: []Speech{{Role: "RUFUS", Text: "Hi."}, {Role: "RUFUS", Text: "Bye."}},
+            ambiguous: []Ambiguity{{Line: 4, Text: "SIGN", Guess: "a speech by SIGN", Alternative: readAsDirections}},
+        },
+        {
+            name: "plain layouts",
+            format: "plain",
+            text: "# Act One\n## The Garden\n" +
+                "RUFUS: What a lovely day.\nPOCO. Indeed it is.\n(They sit.)\n" +
+                "RUFUS Shall we go\non a walk?\nPOCO\nLet's.\n",
+            want: []Speech{
+                {Role: "RUFUS", Text: "What a lovely day.", Act: "Act One", Scene: "The Garden"},
+                {Role: "POCO", Text: "Indeed it is.", Act: "Act One", Scene: "The Garden"},
+                {Role: "RUFUS", Text: "Shall we go on a walk?", Act: "Act One", Scene: "The Garden"},
+                {Role: "POCO", Text: "Let's.", Act: "Act One", Scene: "The Garden"},
+            },
+        },
+        {
+            name: "plain multi-word roles and headings",
+            format: "plain",
+            text: "ACT II\nScene 3: The Hut\n" +
+                "OLD MAN: Hi.\nGUARD 2: Halt!\nMrs. Smith: Hello.\n" +
+                "OLD MAN: Bye.\nGUARD 2: Stop.\nMrs. Smith: Fine.\n",
+            want: []Speech{
+                {Role: "OLDMAN", Text: "Hi.", Act: "ACT II", Scene: "Scene 3: The Hut"},
+                {Role: "GUARDTWO", Text: "Halt!", Act: "ACT II", Scene: "Scene 3: The Hut"},
+                {Role: "MrsSmith", Text: "Hello.", Act: "ACT II", Scene: "Scene 3: The Hut"},
+                {Role: "OLDMAN", Text: "Bye.", Act: "ACT II", Scene: "Scene 3: The Hut"},
+                {Role: "GUARDTWO", Text: "Stop.", Act: "ACT II", Scene: "Scene 3: The Hut"},
+                {Role: "MrsSmith", Text: "Fine.", Act: "ACT II", Scene: "Scene 3: The Hut"},
+            },
+        },
+        {
+            name: "plain text after a blank line",
+            format: "plain",
+            text: "RUFUS: Hi.\nPOCO: Hello.\n\nThe lights fade.\nRUFUS: Bye.\nPOCO: Farewell.\n",
+            want: []Speech{
+                {Role: "RUFUS", Text: "Hi."}, {Role: "POCO", Text: "Hello."},
+                {Role: "RUFUS", Text: "Bye."}, {Role: "POCO", Text: "Farewell."},
+            },
+            ambiguous: []Ambiguity{{Line: 4, Text: "The lights fade.", Guess: readAsDirections, Alternative: readAsContinuation}},
+        },
+        {
+            name: "plain text after a blank line read as speech",
+            format: "plain",
+            text: "RUFUS: Hi.\nPOCO: Hello.\n\nThe lights fade.\nRUFUS: Bye.\nPOCO: Farewell.\n",
+            alternatives: []int{4},
+            want: []Speech{
+                {Role: "RUFUS", Text: "Hi."}, {Role: "POCO", Text: "Hello. The lights fade."},
+                {Role: "RUFUS", Text: "Bye."}, {Role: "POCO", Text: "Farewell."},
+            },
+            ambiguous: []Ambiguity{{Line: 4, Text: "The lights fade.", Guess: readAsDirections, Alternative: readAsContinuation}},
+        },
+        {
+            name: "plain ambiguous role",
+            format: "plain",
+            text: "RUFUS: Hi.\nPOCO: Go left.\nExit: stage left.\nRUFUS: Bye.\nPOCO: Farewell.\n",
+            want: []Speech{
+                {Role: "RUFUS", Text: "Hi."}, {Role: "POCO", Text: "Go left."}, {Role: "Exit", Text: "stage left."},
+                {Role: "RUFUS", Text: "Bye."}, {Role: "POCO", Text: "Farewell."},
+            },
+            ambiguous: []Ambiguity{{Line: 3, Text: "Exit: stage left.", Guess: "a speech by Exit", Alternative: readAsContinuation}},
+        },
+        {
+            name: "plain ambiguous role read as speech",
+            format: "plain",
+            text: "RUFUS: Hi.\nPOCO: Go left.\nExit: stage left.\nRUFUS: Bye.\nPOCO: Farewell.\n",
+            alternatives: []int{3},
+            want: []Speech{
+                {Role: "RUFUS", Text: "Hi."}, {Role: "POCO", Text: "Go left. Exit: stage left."},
+                {Role: "RUFUS", Text: "Bye."}, {Role: "POCO", Text: "Farewell."},
+            },
+            ambiguous: []Ambiguity{{Line: 3, Text: "Exit: stage left.", Guess: "a speech by Exit", Alternative: readAsContinuation}},
+        },
+        {
+            name: "fountain",
+            format: "fountain",
+            text: "Title: The Hut\nAuthor: Someone\n\n# Act One\n\nINT. HUT - NIGHT\n\n" +
+                "OLD MAN\n(whispering)\nWho *goes* there?\n\n" +
+                "@McCoy (V.O.)\nOnly _me_.\n\n" +
+                "OLD MAN ^\nCome in. [[a note]]\n\n" +
+                "CUT TO:\n\n.GARDEN\n\n" +
+                "@McCoy\n~Tra la la.\n\n" +
+                "/* RUFUS\nCut line. */\n",
+            want: []Speech{
+                {Role: "OLDMAN", Text: "Who goes there?", Act: "Act One", Scene: "INT. HUT - NIGHT"},
+                {Role: "McCoy", Text: "Only me.", Act: "Act One", Scene: "INT. HUT - NIGHT"},
+                {Role: "OLDMAN", Text: "Come in.", Act: "Act One", Scene: "INT. HUT - NIGHT"},
+                {Role: "McCoy", Text: "Tra la la.", Act: "Act One", Scene: "GARDEN"},
+            },
+        },
+        {
+            name: "fountain ambiguous cue",
+            format: "fountain",
+            text: "OLD MAN\nHi.\n\nSIGN\nKeep out.\n\n@Voice\nPsst.\n\nOLD MAN\nBye.\n",
+            want: []Speech{
+                {Role: "OLDMAN", Text: "Hi."}, {Role: "SIGN", Text: "Keep out."},
+                {Role: "Voice", Text: "Psst."}, {Role: "OLDMAN", Text: "Bye."},
+            },
+            ambiguous: []Ambiguity{{Line: 4, Text: "SIGN", Guess: "a speech by SIGN", Alternative: readAsDirections}},
+        },
+        {
+            name: "fountain ambiguous cue read as directions",
+            format: "fountain",
+            text: "OLD MAN\nHi.\n\nSIGN\nKeep out.\n\n@Voice\nPsst.\n\nOLD MAN\nBye.\n",
+            alternatives: []int{4},
+            want: []Speech{{Role: "OLDMAN", Text: "Hi."}, {Role: "Voice", Text: "Psst."}, {Role: "OLDMAN", Text: "Bye."}},
+            ambiguous: []Ambiguity{{Line: 4, Text: "SIGN", Guess: "a speech by SIGN", Alternative: readAsDirections}},
+        },
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            options := ImportOptions{Alternatives: map[int]bool{}}
+            for _, line := range test.alternatives {
+                options.Alternatives[line] = true
+            }
+            result, _, err := Import(test.text, test.format, options)
+            if err != nil {
+                t.Fatalf("Import: %v", err)
+            }
+            if !reflect.DeepEqual(result.Speeches, test.want) {
+                t.Errorf("speeches =\n%#v\nwant\n%#v", result.Speeches, test.want)
+            }
+            if !reflect.DeepEqual(result.Ambiguous, test.ambiguous) {
+                t.Errorf("ambiguous =\n%#v\nwant\n%#v", result.Ambiguous, test.ambiguous)
+            }
+        })
+    }
+}
+
+func TestDetectFormat(t *testing.T) {
+    tests := []struct {
+        text string
+        want string
+    }{
+        {"RUFUS: Hi.\nPOCO: Hello.\n", FormatScene},
+        {"Title: The Hut\n\nRUFUS\nHi.\n", "fountain"},
+        {"RUFUS\nHi.\n\n@Poco\nHello.\n", "fountain"},
+        {"RUFUS\nHi.\n\n[[A note.]]\n", "fountain"},
+        {"      RUFUS\n   Hi.\n\n      POCO\n   Hello.\n", "screenplay"},
+        {"RUFUS. Hi.\nPOCO. Hello.\n", "plain"},
+    }
+    for _, test := range tests {
+        if got := DetectFormat(test.text); got != test.want {
+            t.Errorf("DetectFormat(%q) = %q, want %q", test.text, got, test.want)
+        }
+    }
+    if _, _, err := Import("RUFUS: Hi.", "pdf", ImportOptions{}); !errors.Is(err, ErrUnknownFormat) {
+        t.Errorf("Import(pdf) = %v, want ErrUnknownFormat", err)
+    }
+}
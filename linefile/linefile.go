@@ -0,0 +1,469 @@
+// Package linefile reads and writes the plain text line format used by
+// Lynx to store an actor's cues and lines.
+//
+// A line file is a sequence of entries separated by blank lines. Each
+// entry is a cue followed by the line itself, both written as
+// `ROLE: text`. An entry may be preceded by a bracketed metadata line:
+//
+//     [flagged, notes="Breathe before this one"]
+//     RUFUS: Oh no! Poco!
+//     POCO: Aaaagh! I am slain.
+//
+// Besides `flagged` and `notes`, the metadata can hold `blocking`,
+// stage `directions`, the script `page`, comma separated `tags` and a
+// `pronunciation` guide. Any other key is kept as a custom field.
+// Values are quoted, except for numbers and single words. Metadata may
+// be split over several bracketed lines:
+//
+//     [flagged, page=12, tags="act2, tricky"]
+//     [blocking="Cross to the window", director="Say it slower"]
+//
+// Entries can be grouped with `# Act` and `## Scene` headings, which
+// apply to every entry after them. A new act starts without a scene.
+//
+//     # Act One
+//     ## The Garden
+//
+//     RUFUS: What a lovely day it is.
+//     POCO: I couldn't agree more.
+//
+package linefile
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "sort"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+)
+
+// LineData is a single cue/line pair along with the metadata
+// the actor has attached to it.
+type LineData struct {
+    Id int `json:"id"`
+    Cue string `json:"cue"`
+    Line string `json:"line"`
+    Starred bool `json:"starred"`
+    Notes string `json:"notes"`
+    // The headings the entry is under, if any
+    Act string `json:"act,omitempty"`
+    Scene string `json:"scene,omitempty"`
+    Metadata
+    // The kinds of audio recorded for the entry, such as "cue" and
+    // "line". Recordings are kept by the server, not in line files.
+    Audio []string `json:"audio,omitempty"`
+}
+
+// Metadata holds the annotations on an entry beyond stars and notes.
+type Metadata struct {
+    Blocking string `json:"blocking,omitempty"`
+    Directions string `json:"directions,omitempty"`
+    // Page of the script the line is on, or 0 if unknown
+    Page int `json:"page,omitempty"`
+    Tags []string `json:"tags,omitempty"`
+    Pronunciation string `json:"pronunciation,omitempty"`
+    // Any other keys, which are kept as they are
+    Custom map[string]string `json:"custom,omitempty"`
+}
+
+// IsZero reports whether there is no metadata.
+func (m Metadata) IsZero() bool {
+    return m.Blocking == "" && m.Directions == "" && m.Page == 0 &&
+        len(m.Tags) == 0 && m.Pronunciation == "" && len(m.Custom) == 0
+}
+
+// HasTag reports whether the entry has a tag, ignoring case.
+func (m Metadata) HasTag(tag string) bool {
+    for _, t := range m.Tags {
+        if strings.EqualFold(t, tag) {
+            return true
+        }
+    }
+    return false
+}
+
+// CheckMetadata reports metadata that could not be written out and
+// read back the same, such as tags containing commas.
+func CheckMetadata(m Metadata) error {
+    if m.Page < 0 {
+        return fmt.Errorf("page should not be negative")
+    }
+    for _, tag := range m.Tags {
+        if tag == "" || strings.Contains(tag, ",") || strings.TrimSpace(tag) != tag {
+            return fmt.Errorf("tag %q should be non-empty without commas or surrounding spaces", tag)
+        }
+    }
+    for key := range m.Custom {
+        if !isCustomKey(key) {
+            return fmt.Errorf("custom key %q should start with a letter and contain only letters, digits, '_' and '-'", key)
+        }
+    }
+    return nil
+}
+
+func isCustomKey(key string) bool {
+    switch key {
+    case "", "flagged", "notes", "blocking", "directions", "page", "tags", "pronunciation":
+        return false
+    }
+    if !isAlpha(rune(key[0])) {
+        return false
+    }
+    return strings.IndexFunc(key, func(c rune) bool { return !isKeyRune(c) }) < 0
+}
+
+// ParseTags splits a comma separated list of tags, dropping empty ones.
+func ParseTags(s string) []string {
+    var tags []string
+    for _, tag := range strings.Split(s, ",") {
+        if tag = strings.TrimSpace(tag); tag != "" {
+            tags = append(tags, tag)
+        }
+    }
+    return tags
+}
+
+// ParseError reports a problem at a specific line of the input.
+// Line and column numbers start at 1. Column is 0 when the problem is
+// with the line as a whole.
+type ParseError struct {
+    Line int
+    Column int
+    Msg string
+}
+
+func (e *ParseError) Error() string {
+    if e.Column > 0 {
+        return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+    }
+    return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// metadataError is a problem found partway through a metadata line.
+type metadataError struct {
+    // Bytes into the line, counting from 0
+    offset int
+    msg string
+}
+
+func (e *metadataError) Error() string {
+    return e.msg
+}
+
+// HasLineFormat reports whether s starts with a role name followed by
+// a colon, e.g. "POCO: My line". Role names may contain letters and '/'.
+func HasLineFormat(s string) bool {
+    role, _, found := strings.Cut(s, ":")
+    if !found || role == "" {
+        return false
+    }
+    for _, c := range role {
+        if !isAlpha(c) && c != '/' {
+            return false
+        }
+    }
+    return true
+}
+
+// Parse reads every entry in r. Stray blank lines between entries are
+// ignored. The first malformed entry stops parsing and is returned as
+// a *ParseError.
+func Parse(r io.Reader) ([]LineData, error) {
+    p := parser{scanner: bufio.NewScanner(r)}
+    var lines []LineData
+    var act, scene string
+    for {
+        text, ok := p.next()
+        if !ok {
+            break
+        }
+        if text == "" {
+            continue
+        }
+        if strings.HasPrefix(text, "#") {
+            level, title, err := parseHeading(text)
+            if err != nil {
+                return nil, p.errorf("%s", err.Error())
+            }
+            if level == 1 {
+                act, scene = title, ""
+            } else {
+                scene = title
+            }
+            continue
+        }
+
+        item := LineData{Id: len(lines), Act: act, Scene: scene}
+        for strings.HasPrefix(text, "[") {
+            metadata, err := parseMetadata(text)
+            if err == nil {
+                err = item.setMetadata(metadata)
+            }
+            if metaErr, ok := err.(*metadataError); ok {
+                parseErr := p.errorf("%s", metaErr.msg)
+                parseErr.Column = utf8.RuneCountInString(text[:metaErr.offset]) + 1
+                return nil, parseErr
+            } else if err != nil {
+                return nil, p.errorf("%s", err.Error())
+            }
+            if text, ok = p.next(); !ok || text == "" {
+                return nil, p.errorf("expected cue after line metadata")
+            }
+        }
+
+        if !HasLineFormat(text) {
+            return nil, p.errorf("cue %q has invalid format, should have format `ROLE: the line`", text)
+        }
+        item.Cue = text
+
+        if text, ok = p.next(); !ok || text == "" {
+            return nil, p.errorf("expected line after cue but got empty string")
+        }
+        if !HasLineFormat(text) {
+            return nil, p.errorf("line %q has invalid format, should have format `ROLE: the line`", text)
+        }
+        item.Line = text
+
+        if text, ok = p.next(); ok && text != "" {
+            return nil, p.errorf("expected empty line separating lines but got %q", text)
+        }
+
+        lines = append(lines, item)
+    }
+    if err := p.scanner.Err(); err != nil {
+        return nil, err
+    }
+    return lines, nil
+}
+
+// ParseString is a convenience wrapper around Parse.
+func ParseString(s string) ([]LineData, error) {
+    return Parse(strings.NewReader(s))
+}
+
+// Write serializes lines in the format understood by Parse.
+func Write(w io.Writer, lines []LineData) error {
+    bw := bufio.NewWriter(w)
+    var act, scene string
+    for _, line := range lines {
+        // Repeating the act heading is the only way to leave a scene
+        if line.Act != act || (line.Scene == "" && scene != "") {
+            if line.Act != "" {
+                fmt.Fprintf(bw, "# %s\n\n", line.Act)
+            }
+            act, scene = line.Act, ""
+        }
+        if line.Scene != scene {
+            scene = line.Scene
+            fmt.Fprintf(bw, "## %s\n\n", scene)
+        }
+        if metadata := line.metadataFields(); len(metadata) > 0 {
+            fmt.Fprintf(bw, "[%s]\n", strings.Join(metadata, ", "))
+        }
+        fmt.Fprintf(bw, "%s\n%s\n\n", line.Cue, line.Line)
+    }
+    return bw.Flush()
+}
+
+// Format returns the serialized form of lines as a string.
+func Format(lines []LineData) string {
+    var sb strings.Builder
+    Write(&sb, lines)
+    return sb.String()
+}
+
+type parser struct {
+    scanner *bufio.Scanner
+    lineNumber int
+}
+
+func (p *parser) next() (string, bool) {
+    if !p.scanner.Scan() {
+        return "", false
+    }
+    p.lineNumber++
+    return strings.TrimSuffix(p.scanner.Text(), "\r"), true
+}
+
+func (p *parser) errorf(format string, args ...any) *ParseError {
+    return &ParseError{Line: p.lineNumber, Msg: fmt.Sprintf(format, args...)}
+}
+
+// parseHeading parses `# Act` or `## Scene` into its level and title.
+func parseHeading(s string) (int, string, error) {
+    level := len(s) - len(strings.TrimLeft(s, "#"))
+    title := strings.TrimSpace(s[level:])
+    if level > 2 {
+        return 0, "", fmt.Errorf("heading %q should start with `#` for an act or `##` for a scene", s)
+    }
+    if title == "" {
+        return 0, "", fmt.Errorf("heading %q is missing a title", s)
+    }
+    return level, title, nil
+}
+
+type field struct {
+    key string
+    value string
+}
+
+func (line *LineData) setMetadata(fields []field) error {
+    for _, f := range fields {
+        switch f.key {
+        case "flagged":
+            line.Starred = true
+        case "notes":
+            line.Notes = f.value
+        case "blocking":
+            line.Blocking = f.value
+        case "directions":
+            line.Directions = f.value
+        case "page":
+            page, err := strconv.Atoi(f.value)
+            if err != nil || page < 0 {
+                return fmt.Errorf("line metadata: page should be a number but got %q", f.value)
+            }
+            line.Page = page
+        case "tags":
+            line.Tags = append(line.Tags, ParseTags(f.value)...)
+        case "pronunciation":
+            line.Pronunciation = f.value
+        default:
+            if line.Custom == nil {
+                line.Custom = map[string]string{}
+            }
+            line.Custom[f.key] = f.value
+        }
+    }
+    return nil
+}
+
+// Lists the metadata of a line in the order it is written. Custom keys
+// are sorted so that the output is stable.
+func (line *LineData) metadataFields() []string {
+    var fields []string
+    if line.Starred {
+        fields = append(fields, "flagged")
+    }
+    add := func(key string, value string) {
+        if value != "" {
+            fields = append(fields, key + "=" + quote(value))
+        }
+    }
+    add("notes", line.Notes)
+    add("blocking", line.Blocking)
+    add("directions", line.Directions)
+    if line.Page > 0 {
+        fields = append(fields, "page=" + strconv.Itoa(line.Page))
+    }
+    add("tags", strings.Join(line.Tags, ", "))
+    add("pronunciation", line.Pronunciation)
+
+    keys := make([]string, 0, len(line.Custom))
+    for key := range line.Custom {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+        if line.Custom[key] == "" {
+            fields = append(fields, key)
+        } else {
+            add(key, line.Custom[key])
+        }
+    }
+    return fields
+}
+
+// parseMetadata parses a line of the form
+//     [key, key="value", key=value, ...]
+// Quoted values may escape '"', '\' and newlines with a backslash.
+// Unquoted values end at the next space, ',' or ']'.
+func parseMetadata(line string) ([]field, error) {
+    s := strings.TrimPrefix(line, "[")
+    errorf := func(format string, args ...any) error {
+        return &metadataError{offset: len(line) - len(s), msg: fmt.Sprintf(format, args...)}
+    }
+    var fields []field
+    for {
+        s = strings.TrimLeft(s, " \t")
+        if s == "" || !isAlpha(rune(s[0])) {
+            break
+        }
+
+        var f field
+        end := strings.IndexFunc(s, func(c rune) bool { return !isKeyRune(c) })
+        if end < 0 {
+            end = len(s)
+        }
+        f.key, s = s[:end], strings.TrimLeft(s[end:], " \t")
+
+        if strings.HasPrefix(s, "=") {
+            s = strings.TrimLeft(s[1:], " \t")
+            if strings.HasPrefix(s, "\"") {
+                value, rest, err := unquote(s)
+                if err != nil {
+                    return nil, errorf("%s", err.Error())
+                }
+                f.value, s = value, strings.TrimLeft(rest, " \t")
+            } else {
+                end := strings.IndexAny(s, ", \t]")
+                if end <= 0 {
+                    return nil, errorf("line metadata: expected a value after `%s=`. Did you include quotes around the value?", f.key)
+                }
+                f.value, s = s[:end], strings.TrimLeft(s[end:], " \t")
+            }
+        }
+        fields = append(fields, f)
+
+        if strings.HasPrefix(s, ",") {
+            s = s[1:]
+        } else if !strings.HasPrefix(s, "]") {
+            return nil, errorf("line metadata: expected ',' or ']' but got %q", s)
+        }
+    }
+    if !strings.HasPrefix(s, "]") {
+        return nil, errorf("line metadata: expected ']' but got %q", s)
+    }
+    return fields, nil
+}
+
+// unquote reads a quoted value from the start of s and returns it
+// along with the remainder of s.
+func unquote(s string) (string, string, error) {
+    var sb strings.Builder
+    for i := 1; i < len(s); i++ {
+        switch s[i] {
+        case '"':
+            return sb.String(), s[i+1:], nil
+        case '\\':
+            i++
+            if i == len(s) {
+                break
+            }
+            if s[i] == 'n' {
+                sb.WriteByte('\n')
+            } else {
+                sb.WriteByte(s[i])
+            }
+        default:
+            sb.WriteByte(s[i])
+        }
+    }
+    return "", "", fmt.Errorf("line metadata: missing closing '\"'")
+}
+
+func quote(s string) string {
+    r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", "")
+    return `"` + r.Replace(s) + `"`
+}
+
+// Keys start with a letter and may also contain digits, '_' and '-'.
+func isKeyRune(c rune) bool {
+    return isAlpha(c) || ('0' <= c && c <= '9') || c == '_' || c == '-'
+}
+
+func isAlpha(c rune) bool {
+    return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
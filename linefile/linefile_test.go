@@ -0,0 +1,218 @@
+package linefile
+
+import (
+    "errors"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestParse(t *testing.T) {
+    tests := []struct {
+        name string
+        text string
+        want []LineData
+    }{
+        {
+            name: "entries",
+            text: "RUFUS: Oh no! Poco!\nPOCO: Aaaagh! I am slain.\n\nRUFUS: Are you?\nPOCO: No.\n",
+            want: []LineData{
+                {Id: 0, Cue: "RUFUS: Oh no! Poco!", Line: "POCO: Aaaagh! I am slain."},
+                {Id: 1, Cue: "RUFUS: Are you?", Line: "POCO: No."},
+            },
+        },
+        {
+            name: "stray blank lines and CRLF",
+            text: "\r\n\r\nRUFUS: Hi.\r\nPOCO: Hello.\r\n\r\n\r\n\r\nRUFUS: Bye.\r\nPOCO: Farewell.",
+            want: []LineData{
+                {Id: 0, Cue: "RUFUS: Hi.", Line: "POCO: Hello."},
+                {Id: 1, Cue: "RUFUS: Bye.", Line: "POCO: Farewell."},
+            },
+        },
+        {
+            name: "role names with slashes",
+            text: "ALL/RUFUS: Now!\nPOCO: Now.\n",
+            want: []LineData{{Id: 0, Cue: "ALL/RUFUS: Now!", Line: "POCO: Now."}},
+        },
+        {
+            name: "metadata",
+            text: `[flagged, notes="Breathe \"first\"\nthen go", page=12, tags="act2, tricky"]` + "\n" +
+                `[blocking="Cross to the window", directions=Kneels, pronunciation="AH-gh", director="Say it slower", aside]` + "\n" +
+                "RUFUS: Oh no! Poco!\nPOCO: Aaaagh! I am slain.\n",
+            want: []LineData{{
+                Id: 0,
+                Cue: "RUFUS: Oh no! Poco!",
+                Line: "POCO: Aaaagh! I am slain.",
+                Starred: true,
+                Notes: "Breathe \"first\"\nthen go",
+                Metadata: Metadata{
+                    Blocking: "Cross to the window",
+                    Directions: "Kneels",
+                    Page: 12,
+                    Tags: []string{"act2", "tricky"},
+                    Pronunciation: "AH-gh",
+                    Custom: map[string]string{"director": "Say it slower", "aside": ""},
+                },
+            }},
+        },
+        {
+            name: "headings",
+            text: "RUFUS: Before.\nPOCO: Anything.\n\n# Act One\n## The Garden\n\nRUFUS: What a lovely day.\nPOCO: Indeed.\n\n" +
+                "## The House\nRUFUS: Come in.\nPOCO: Thanks.\n\n# Act Two\n\nRUFUS: Later.\nPOCO: Much.\n",
+            want: []LineData{
+                {Id: 0, Cue: "RUFUS: Before.", Line: "POCO: Anything."},
+                {Id: 1, Cue: "RUFUS: What a lovely day.", Line: "POCO: Indeed.", Act: "Act One", Scene: "The Garden"},
+                {Id: 2, Cue: "RUFUS: Come in.", Line: "POCO: Thanks.", Act: "Act One", Scene: "The House"},
+                {Id: 3, Cue: "RUFUS: Later.", Line: "POCO: Much.", Act: "Act Two"},
+            },
+        },
+        {
+            name: "empty",
+            text: "\n\n",
+            want: nil,
+        },
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            got, err := ParseString(test.text)
+            if err != nil {
+                t.Fatalf("ParseString: %v", err)
+            }
+            if !reflect.DeepEqual(got, test.want) {
+                t.Errorf("ParseString =\n%#v\nwant\n%#v", got, test.want)
+            }
+        })
+    }
+}
+
+func TestWriteParseRoundTrip(t *testing.T) {
+    tests := []struct {
+        name string
+        lines []LineData
+    }{
+        {
+            name: "plain",
+            lines: []LineData{
+                {Id: 0, Cue: "RUFUS: Oh no! Poco!", Line: "POCO: Aaaagh! I am slain."},
+                {Id: 1, Cue: "RUFUS: Are you?", Line: "POCO: No."},
+            },
+        },
+        {
+            name: "stars and notes",
+            lines: []LineData{
+                {Id: 0, Cue: "RUFUS: One.", Line: "POCO: Two.", Starred: true},
+                {Id: 1, Cue: "RUFUS: Three.", Line: "POCO: Four.", Notes: "Slowly"},
+                {Id: 2, Cue: "RUFUS: Five.", Line: "POCO: Six.", Starred: true, Notes: `Say "six" like a question`},
+            },
+        },
+        {
+            name: "multi-line text",
+            lines: []LineData{{
+                Id: 0,
+                Cue: "RUFUS: Ready?",
+                Line: "POCO: Always.",
+                Notes: "First breath here.\nThen look at Rufus.\n\nHold for the laugh. \\o/",
+                Metadata: Metadata{
+                    Blocking: "Enter left\nCross to the table",
+                    Directions: "Quietly,\nthen loud",
+                },
+            }},
+        },
+        {
+            name: "metadata",
+            lines: []LineData{{
+                Id: 0,
+                Cue: "RUFUS: Oh no! Poco!",
+                Line: "POCO: Aaaagh! I am slain.",
+                Metadata: Metadata{
+                    Blocking: "Fall",
+                    Directions: "Dramatically",
+                    Page: 7,
+                    Tags: []string{"death", "physical comedy"},
+                    Pronunciation: "AAH-gh",
+                    Custom: map[string]string{"director": "Bigger", "zeta": "last", "aside": ""},
+                },
+            }},
+        },
+        {
+            name: "headings",
+            lines: []LineData{
+                {Id: 0, Cue: "RUFUS: Before.", Line: "POCO: Anything."},
+                {Id: 1, Cue: "RUFUS: A.", Line: "POCO: B.", Act: "Act One", Scene: "The Garden"},
+                {Id: 2, Cue: "RUFUS: C.", Line: "POCO: D.", Act: "Act One"},
+                {Id: 3, Cue: "RUFUS: E.", Line: "POCO: F.", Act: "Act One", Scene: "The House"},
+                {Id: 4, Cue: "RUFUS: G.", Line: "POCO: H.", Act: "Act Two", Scene: "The House"},
+            },
+        },
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            text := Format(test.lines)
+            got, err := ParseString(text)
+            if err != nil {
+                t.Fatalf("ParseString(Format(lines)): %v\n%s", err, text)
+            }
+            if !reflect.DeepEqual(got, test.lines) {
+                t.Errorf("round trip =\n%#v\nwant\n%#v\ntext:\n%s", got, test.lines, text)
+            }
+            if again := Format(got); again != text {
+                t.Errorf("Format is not stable:\n%s\nthen\n%s", text, again)
+            }
+        })
+    }
+}
+
+func TestParseError(t *testing.T) {
+    tests := []struct {
+        name string
+        text string
+        line int
+        column int
+        msg string
+    }{
+        {"cue without role", "No role here\nPOCO: Hi.\n", 1, 0, "cue"},
+        {"line without role", "RUFUS: Hi.\nno role\n", 2, 0, "line"},
+        {"missing line", "\n\nRUFUS: Hi.\n\nPOCO: Hello.\n", 4, 0, "expected line after cue"},
+        {"missing blank line", "RUFUS: Hi.\nPOCO: Hello.\nRUFUS: Bye.\n", 3, 0, "expected empty line"},
+        {"metadata without cue", "[flagged]\n\nRUFUS: Hi.\nPOCO: Hello.\n", 2, 0, "expected cue after line metadata"},
+        {"unclosed quote", "RUFUS: A.\nPOCO: B.\n\n[notes=\"Never ends]\nRUFUS: C.\nPOCO: D.\n", 4, 8, "closing"},
+        {"missing value", "[page=]\nRUFUS: Hi.\nPOCO: Hello.\n", 1, 7, "expected a value after `page=`"},
+        {"missing comma", "[flagged notes]\nRUFUS: Hi.\nPOCO: Hello.\n", 1, 10, "expected ',' or ']'"},
+        {"columns count characters", "[notes=\"ünï\" x]\nRUFUS: Hi.\nPOCO: Hello.\n", 1, 14, "expected ',' or ']'"},
+        {"missing bracket", "[flagged, notes=\"x\"\nRUFUS: Hi.\nPOCO: Hello.\n", 1, 20, "expected ',' or ']'"},
+        {"bad page", "[page=twelve]\nRUFUS: Hi.\nPOCO: Hello.\n", 1, 0, "page should be a number"},
+        {"heading too deep", "### Too deep\n", 1, 0, "heading"},
+        {"heading without title", "RUFUS: Hi.\nPOCO: Hello.\n\n##\n", 4, 0, "missing a title"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            _, err := ParseString(test.text)
+            var parseErr *ParseError
+            if !errors.As(err, &parseErr) {
+                t.Fatalf("ParseString error = %v, want a *ParseError", err)
+            }
+            if parseErr.Line != test.line || parseErr.Column != test.column {
+                t.Errorf("error at line %d, column %d, want line %d, column %d: %v",
+                    parseErr.Line, parseErr.Column, test.line, test.column, err)
+            }
+            if !strings.Contains(parseErr.Msg, test.msg) {
+                t.Errorf("error %q does not mention %q", parseErr.Msg, test.msg)
+            }
+        })
+    }
+}
+
+func TestParseErrorMessage(t *testing.T) {
+    tests := []struct {
+        err ParseError
+        want string
+    }{
+        {ParseError{Line: 3, Msg: "bad"}, "line 3: bad"},
+        {ParseError{Line: 3, Column: 9, Msg: "bad"}, "line 3, column 9: bad"},
+    }
+    for _, test := range tests {
+        if got := test.err.Error(); got != test.want {
+            t.Errorf("Error() = %q, want %q", got, test.want)
+        }
+    }
+}
@@ -0,0 +1,35 @@
+package linefile
+
+// Act is a group of scenes under a `# Act` heading. Entries before the
+// first act heading belong to an act with an empty title.
+type Act struct {
+    Title string `json:"title"`
+    Scenes []Scene `json:"scenes"`
+}
+
+// Scene is a run of entries under a `## Scene` heading. Entries before
+// the first scene heading of an act belong to a scene with an empty
+// title.
+type Scene struct {
+    Title string `json:"title"`
+    Lines []LineData `json:"lines"`
+}
+
+// Outline groups lines by act and scene, keeping their order. A new
+// group is started whenever the act or scene changes from one line to
+// the next.
+func Outline(lines []LineData) []Act {
+    var acts []Act
+    for i, line := range lines {
+        if i == 0 || line.Act != lines[i - 1].Act {
+            acts = append(acts, Act{Title: line.Act})
+        }
+        act := &acts[len(acts) - 1]
+        if len(act.Scenes) == 0 || line.Scene != lines[i - 1].Scene {
+            act.Scenes = append(act.Scenes, Scene{Title: line.Scene})
+        }
+        scene := &act.Scenes[len(act.Scenes) - 1]
+        scene.Lines = append(scene.Lines, line)
+    }
+    return acts
+}
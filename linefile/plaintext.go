@@ -0,0 +1,143 @@
+package linefile
+
+import (
+    "strings"
+    "unicode"
+)
+
+// Plain text scripts come in many layouts. The importer accepts any
+// of these ways of starting a speech:
+//
+//     RUFUS: What a lovely day it is.
+//     Rufus: What a lovely day it is.
+//     RUFUS. What a lovely day it is.
+//     RUFUS What a lovely day it is.
+//     RUFUS
+//     What a lovely day it is.
+//
+// Lines in parentheses or brackets are stage directions, `# Act` and
+// `## Scene` headings work as in line files, and lines like "ACT II"
+// or "Scene 3" are read as headings too. Other lines continue the
+// speech above them, except after a blank line, where they are taken
+// to be stage directions and reported as ambiguous.
+
+// Whether s could be a role name written before a colon: one to four
+// words starting with capital letters, or numbers after the first, as
+// in "GUARD 2".
+func isRoleName(s string) bool {
+    words := strings.Fields(s)
+    if len(words) == 0 || len(words) > 4 || len([]rune(s)) > 30 {
+        return false
+    }
+    for i, word := range words {
+        if i > 0 && strings.IndexFunc(word, func(c rune) bool { return !unicode.IsDigit(c) }) < 0 {
+            continue
+        }
+        first := []rune(word)[0]
+        if !unicode.IsUpper(first) {
+            return false
+        }
+        for _, c := range word {
+            if !unicode.IsLetter(c) && c != '.' && c != '\'' && c != '-' {
+                return false
+            }
+        }
+    }
+    return true
+}
+
+// Splits "ROLE: text", "ROLE. text" or "ROLE text" into role and text.
+func splitSpeech(line string) (string, string, bool) {
+    if role, text, found := strings.Cut(line, ":"); found && isRoleName(role) && strings.TrimSpace(text) != "" {
+        return strings.TrimSpace(role), strings.TrimSpace(text), true
+    }
+    if role, text, found := strings.Cut(line, ". "); found && isUpperCase(role) && isRoleName(role) {
+        return strings.TrimSpace(role), strings.TrimSpace(text), true
+    }
+    // Leading words in capitals, as long as the rest is not
+    words := strings.Fields(line)
+    n := 0
+    for n < len(words) && n < 3 && len([]rune(words[n])) >= 2 && isUpperCase(words[n]) && isRoleName(words[n]) {
+        n++
+    }
+    if n > 0 && n < len(words) && !isUpperCase(strings.Join(words[n:], " ")) {
+        return strings.Join(words[:n], " "), strings.Join(words[n:], " "), true
+    }
+    return "", "", false
+}
+
+// Reads lines like "ACT II" or "Scene 3: The Garden" as headings.
+func plainHeading(line string) (int, string, bool) {
+    if strings.HasPrefix(line, "#") {
+        level, title, err := parseHeading(line)
+        return level, title, err == nil
+    }
+    word, _, _ := strings.Cut(line, " ")
+    if len([]rune(line)) > 40 || len(strings.Fields(line)) < 2 {
+        return 0, "", false
+    }
+    switch strings.ToUpper(word) {
+    case "ACT":
+        return 1, line, true
+    case "SCENE":
+        return 2, line, true
+    }
+    return 0, "", false
+}
+
+func isDirection(line string) bool {
+    return (strings.HasPrefix(line, "(") && strings.HasSuffix(line, ")")) ||
+        (strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"))
+}
+
+func importPlainText(text string, options ImportOptions) (ImportResult, error) {
+    lines := splitLines(text)
+    var drafts []draft
+    var act, scene string
+    // Whether the last line was part of a speech or of other text, so
+    // the next line without a role continues it
+    continues := false
+    for i := 0; i < len(lines); i++ {
+        line := strings.TrimSpace(lines[i])
+        if line == "" || isDirection(line) {
+            continues = false
+            continue
+        }
+        if level, title, ok := plainHeading(line); ok {
+            if level == 1 {
+                act, scene = title, ""
+            } else {
+                scene = title
+            }
+            continues = false
+            continue
+        }
+
+        d := draft{line: i + 1, source: line, speech: Speech{Act: act, Scene: scene}}
+        if role, speech, ok := splitSpeech(line); ok {
+            d.speech.Role, d.speech.Text = role, speech
+            d.alternative = readAsContinuation
+        } else if name, ok := cueName(line); ok && i + 1 < len(lines) && strings.TrimSpace(lines[i + 1]) != "" && !isDirection(strings.TrimSpace(lines[i + 1])) {
+            // The speech starts on the next line
+            d.speech.Role = name
+            d.alternative = readAsDirections
+        } else if continues {
+            last := &drafts[len(drafts) - 1]
+            last.speech.Text = strings.TrimSpace(last.speech.Text + " " + line)
+            continue
+        } else {
+            d.speech.Text = line
+        }
+        drafts = append(drafts, d)
+        continues = true
+    }
+
+    // Drop role names that never got any text
+    var kept []draft
+    for _, d := range drafts {
+        if d.speech.Text != "" {
+            kept = append(kept, d)
+        }
+    }
+    return finishDrafts(kept, options), nil
+}
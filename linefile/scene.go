@@ -0,0 +1,131 @@
+package linefile
+
+import (
+    "bufio"
+    "io"
+    "strings"
+)
+
+// A scene is written as a sequence of speeches, each starting with
+// the speaker's role:
+//
+//     RUFUS: What a lovely day it is.
+//     POCO: I couldn't agree more.
+//     RUFUS: Oh no! Poco!
+//
+// Blank lines are ignored and any other line continues the previous
+// speech. `# Act` and `## Scene` headings work as in line files. Once
+// an actor picks their roles, the scene can be turned into cue/line
+// pairs with the previous speech as each cue.
+
+// Speech is everything one role says before the next role speaks.
+type Speech struct {
+    Role string `json:"role"`
+    Text string `json:"text"`
+    Act string `json:"act,omitempty"`
+    Scene string `json:"scene,omitempty"`
+}
+
+// The speech in `ROLE: text` form.
+func (s Speech) String() string {
+    return s.Role + ": " + s.Text
+}
+
+// Used as the cue when the scene opens with one of the actor's speeches.
+const SceneStartCue = "SCENE: (start of scene)"
+
+func ParseScene(r io.Reader) ([]Speech, error) {
+    p := parser{scanner: bufio.NewScanner(r)}
+    var speeches []Speech
+    var act, scene string
+    // Whether the last line was a heading, so there is no speech to continue
+    afterHeading := false
+    for {
+        text, ok := p.next()
+        if !ok {
+            break
+        }
+        text = strings.TrimSpace(text)
+        if text == "" {
+            continue
+        }
+        if strings.HasPrefix(text, "#") {
+            level, title, err := parseHeading(text)
+            if err != nil {
+                return nil, p.errorf("%s", err.Error())
+            }
+            if level == 1 {
+                act, scene = title, ""
+            } else {
+                scene = title
+            }
+            afterHeading = true
+            continue
+        }
+        if HasLineFormat(text) {
+            role, speech, _ := strings.Cut(text, ":")
+            speeches = append(speeches, Speech{Role: role, Text: strings.TrimSpace(speech), Act: act, Scene: scene})
+            afterHeading = false
+        } else if len(speeches) > 0 && !afterHeading {
+            last := &speeches[len(speeches) - 1]
+            last.Text = strings.TrimSpace(last.Text + " " + text)
+        } else {
+            return nil, p.errorf("%q has invalid format, the scene should start with `ROLE: the line`", text)
+        }
+    }
+    if err := p.scanner.Err(); err != nil {
+        return nil, err
+    }
+    return speeches, nil
+}
+
+func ParseSceneString(s string) ([]Speech, error) {
+    return ParseScene(strings.NewReader(s))
+}
+
+// Roles lists each role with a speech in order of first appearance.
+func Roles(speeches []Speech) []string {
+    var roles []string
+    seen := map[string]bool{}
+    for _, speech := range speeches {
+        key := strings.ToUpper(speech.Role)
+        if !seen[key] {
+            seen[key] = true
+            roles = append(roles, speech.Role)
+        }
+    }
+    return roles
+}
+
+// PairsForRoles makes a cue/line pair for each speech by one of the
+// given roles, using the speech before it in the same scene as the
+// cue. Roles are matched case insensitively, and as RoleName writes
+// them, so "Old Man" picks the speeches of OLDMAN.
+func PairsForRoles(speeches []Speech, roles []string) []LineData {
+    mine := map[string]bool{}
+    for _, role := range roles {
+        if role = strings.TrimSpace(role); role != "" {
+            mine[strings.ToUpper(role)] = true
+            mine[strings.ToUpper(RoleName(role))] = true
+        }
+    }
+
+    var lines []LineData
+    for i, speech := range speeches {
+        if !mine[strings.ToUpper(speech.Role)] {
+            continue
+        }
+        cue := SceneStartCue
+        if i > 0 && speeches[i - 1].Act == speech.Act && speeches[i - 1].Scene == speech.Scene {
+            cue = speeches[i - 1].String()
+        }
+        lines = append(lines, LineData{
+            Id: len(lines),
+            Cue: cue,
+            Line: speech.String(),
+            Act: speech.Act,
+            Scene: speech.Scene,
+        })
+    }
+    return lines
+}
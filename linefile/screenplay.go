@@ -0,0 +1,104 @@
+package linefile
+
+import (
+    "strings"
+    "unicode"
+)
+
+// Screenplays, including text copied out of a PDF, put each character
+// name on a line of its own with their dialogue below it:
+//
+//                     RUFUS
+//           What a lovely day it is.
+//
+// Text taken from a PDF often loses its blank lines and picks up page
+// numbers and "(MORE)"/"CONTINUED:" markers, so those are skipped, and
+// when the indentation survives it is used to tell dialogue apart from
+// the action after it.
+
+// Whether a line is left over from the pages of a PDF.
+func isPageArtifact(s string) bool {
+    s = strings.TrimSpace(s)
+    switch strings.ToUpper(s) {
+    case "(MORE)", "(CONTINUED)", "CONTINUED:", "CONTINUED", "(CONT'D)":
+        return true
+    }
+    digits := strings.TrimSuffix(s, ".")
+    return digits != "" && strings.IndexFunc(digits, func(c rune) bool { return !unicode.IsDigit(c) }) < 0
+}
+
+func indentation(s string) int {
+    return len(s) - len(strings.TrimLeft(s, " \t"))
+}
+
+func importScreenplay(text string, options ImportOptions) (ImportResult, error) {
+    var lines []string
+    var lineNumbers []int
+    for i, line := range splitLines(text) {
+        if !isPageArtifact(line) {
+            lines = append(lines, strings.ReplaceAll(line, "\t", "    "))
+            lineNumbers = append(lineNumbers, i + 1)
+        }
+    }
+
+    var drafts []draft
+    var scene string
+    for i := 0; i < len(lines); i++ {
+        line := strings.TrimSpace(lines[i])
+        if line == "" || isTransition(line) {
+            continue
+        }
+        if isSceneHeading(line) {
+            scene = line
+            continue
+        }
+        name, isCue := cueName(line)
+        if !isCue || i + 1 >= len(lines) {
+            continue
+        }
+        if next := strings.TrimSpace(lines[i + 1]); next == "" || isSceneHeading(next) {
+            continue
+        } else if _, nextIsCue := cueName(next); nextIsCue {
+            continue
+        }
+
+        d := draft{
+            speech: Speech{Role: name, Scene: scene},
+            line: lineNumbers[i],
+            source: line,
+            alternative: readAsDirections,
+        }
+        cueIndent := indentation(lines[i])
+        dialogueIndent := -1
+        var speech []string
+        inParenthetical := false
+        for i + 1 < len(lines) {
+            next := lines[i + 1]
+            dialogue := strings.TrimSpace(next)
+            if dialogue == "" || isSceneHeading(dialogue) {
+                break
+            }
+            if _, isCue := cueName(dialogue); isCue && !inParenthetical && len(speech) > 0 {
+                break
+            }
+            // Action below the dialogue is less indented than it
+            if cueIndent > 0 && dialogueIndent >= 0 && indentation(next) < dialogueIndent {
+                break
+            }
+            i++
+            if inParenthetical || strings.HasPrefix(dialogue, "(") {
+                inParenthetical = !strings.HasSuffix(dialogue, ")")
+                continue
+            }
+            if dialogueIndent < 0 {
+                dialogueIndent = indentation(next)
+            }
+            speech = append(speech, dialogue)
+        }
+        d.speech.Text = strings.Join(speech, " ")
+        if d.speech.Text != "" {
+            drafts = append(drafts, d)
+        }
+    }
+    return finishDrafts(drafts, options), nil
+}
@@ -1,8 +1,38 @@
 package main
 
-import "github.com/ruuzia/lynx/feline"
+import (
+    "flag"
+    "log"
+    "path/filepath"
+
+    "github.com/ruuzia/lynx/feline"
+)
 
 func main() {
+    importData := flag.String("import-data", "", "import line sets from a Lynx data directory and exit")
+    dataDir := flag.String("data", "", "Lynx data directory to keep in step when line sets are renamed or deleted")
+    audioDir := flag.String("audio", "audio", "directory to keep audio recorded for lines in")
+    ttsCommand := flag.String("tts", "", "text to speech command reading text from stdin and writing WAV audio to stdout, with {voice} for the voice, e.g. \"espeak-ng --stdout -v {voice}\"")
+    ttsVoice := flag.String("tts-voice", "", "voice for roles that have not been given one")
+    flag.Parse()
+    feline.LineFileDir = *dataDir
+    feline.AudioStorage = feline.DiskAudioStore{Dir: *audioDir}
+    feline.SpeechCache = feline.DiskAudioStore{Dir: filepath.Join(*audioDir, "speech")}
+    if *ttsCommand != "" {
+        synthesizer, err := feline.NewCommandSynthesizer(*ttsCommand, *ttsVoice)
+        if err != nil {
+            log.Fatal(err)
+        }
+        feline.Synthesizer = synthesizer
+    }
+
+    if *importData != "" {
+        feline.OpenDatabase()
+        if err := feline.ImportLineFiles(*importData); err != nil {
+            log.Fatal(err)
+        }
+        return
+    }
+
     feline.OpenServer(":2323")
 }
-
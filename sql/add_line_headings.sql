@@ -0,0 +1,3 @@
+ALTER TABLE line_data
+    ADD COLUMN act varchar(255) NOT NULL DEFAULT '',
+    ADD COLUMN scene varchar(255) NOT NULL DEFAULT '';
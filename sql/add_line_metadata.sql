@@ -0,0 +1,2 @@
+ALTER TABLE line_data
+    ADD COLUMN metadata TEXT(65000) NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE line_sets
+    ADD COLUMN deleted_at DATETIME NULL;
@@ -0,0 +1,5 @@
+ALTER TABLE line_sets
+    ADD COLUMN visibility varchar(16) NOT NULL DEFAULT 'private',
+    ADD COLUMN share_token varchar(32) NULL UNIQUE,
+    ADD COLUMN forked_from int NULL,
+    ADD FOREIGN KEY (forked_from) REFERENCES line_sets(id) ON DELETE SET NULL;
@@ -0,0 +1,5 @@
+ALTER TABLE line_data
+    ADD COLUMN tags TEXT(65000) NULL;
+UPDATE line_data
+    SET tags = JSON_EXTRACT(metadata, '$.tags'), metadata = JSON_REMOVE(metadata, '$.tags')
+    WHERE JSON_EXTRACT(metadata, '$.tags') IS NOT NULL;
@@ -0,0 +1,14 @@
+CREATE TABLE line_attempts (
+    id int NOT NULL AUTO_INCREMENT,
+    user_id int NOT NULL,
+    line_id int NOT NULL,
+    attempted_at DATETIME NOT NULL,
+    review_method varchar(32) NOT NULL,
+    grade tinyint,
+    reveal_ms int NOT NULL,
+    hints_used int NOT NULL DEFAULT 0,
+    PRIMARY KEY(id),
+    INDEX (line_id, attempted_at),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (line_id) REFERENCES line_data(id) ON DELETE CASCADE
+);
@@ -0,0 +1,9 @@
+CREATE TABLE line_audio (
+    line_id int NOT NULL,
+    kind ENUM('cue', 'line') NOT NULL,
+    content_type varchar(64) NOT NULL,
+    size int NOT NULL,
+    uploaded_at DATETIME NOT NULL,
+    PRIMARY KEY(line_id, kind),
+    FOREIGN KEY (line_id) REFERENCES line_data(id) ON DELETE CASCADE
+);
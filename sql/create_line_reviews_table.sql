@@ -0,0 +1,9 @@
+CREATE TABLE line_reviews (
+    line_id int NOT NULL,
+    ease DOUBLE NOT NULL,
+    interval_days int NOT NULL,
+    repetitions int NOT NULL,
+    due DATETIME NOT NULL,
+    PRIMARY KEY(line_id),
+    FOREIGN KEY (line_id) REFERENCES line_data(id) ON DELETE CASCADE
+);
@@ -0,0 +1,16 @@
+CREATE TABLE line_set_revisions (
+    id int NOT NULL AUTO_INCREMENT,
+    line_set_id int NOT NULL,
+    author_id int NOT NULL,
+    created_at DATETIME NOT NULL,
+    text MEDIUMTEXT NOT NULL,
+    lines_added int NOT NULL,
+    lines_removed int NOT NULL,
+    lines_changed int NOT NULL,
+    restored_from int NULL,
+    PRIMARY KEY(id),
+    INDEX (line_set_id, id),
+    FOREIGN KEY (line_set_id) REFERENCES line_sets(id) ON DELETE CASCADE,
+    FOREIGN KEY (author_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (restored_from) REFERENCES line_set_revisions(id) ON DELETE SET NULL
+);
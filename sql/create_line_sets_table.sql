@@ -2,6 +2,11 @@ CREATE TABLE line_sets (
     id int NOT NULL AUTO_INCREMENT,
     user_id int,
     title varchar(1024),
+    visibility varchar(16) NOT NULL DEFAULT 'private',
+    share_token varchar(32) NULL UNIQUE,
+    forked_from int NULL,
+    deleted_at DATETIME NULL,
     PRIMARY KEY(id),
-    FOREIGN KEY (user_id) REFERENCES users(id)
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (forked_from) REFERENCES line_sets(id) ON DELETE SET NULL
 );
@@ -1,9 +1,16 @@
 CREATE TABLE line_data (
     id int NOT NULL AUTO_INCREMENT,
-    user_id int,
-    line_number int,
-    cue TEXT(65000),
-    line TEXT(65000),
+    line_set_id int NOT NULL,
+    line_number int NOT NULL,
+    cue TEXT(65000) NOT NULL,
+    line TEXT(65000) NOT NULL,
+    flagged BOOLEAN NOT NULL DEFAULT FALSE,
+    notes TEXT(65000) NOT NULL,
+    act varchar(255) NOT NULL DEFAULT '',
+    scene varchar(255) NOT NULL DEFAULT '',
+    metadata TEXT(65000) NULL,
+    tags TEXT(65000) NULL,
     PRIMARY KEY(id),
-    FOREIGN KEY (user_id) REFERENCES users(id)
+    UNIQUE (line_set_id, line_number),
+    FOREIGN KEY (line_set_id) REFERENCES line_sets(id) ON DELETE CASCADE
 );
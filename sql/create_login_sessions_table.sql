@@ -0,0 +1,8 @@
+CREATE TABLE login_sessions (
+    token_hash BINARY(32) NOT NULL,
+    user_id int NOT NULL,
+    created_at DATETIME NOT NULL,
+    last_seen DATETIME NOT NULL,
+    PRIMARY KEY(token_hash),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
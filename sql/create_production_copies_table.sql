@@ -0,0 +1,12 @@
+CREATE TABLE production_copies (
+    production_id int NOT NULL,
+    script_id int NOT NULL,
+    user_id int NOT NULL,
+    line_set_id int NOT NULL,
+    PRIMARY KEY(production_id, script_id, user_id),
+    UNIQUE (line_set_id),
+    FOREIGN KEY (production_id) REFERENCES productions(id) ON DELETE CASCADE,
+    FOREIGN KEY (script_id) REFERENCES line_sets(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (line_set_id) REFERENCES line_sets(id) ON DELETE CASCADE
+);
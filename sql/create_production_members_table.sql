@@ -0,0 +1,9 @@
+CREATE TABLE production_members (
+    production_id int NOT NULL,
+    user_id int NOT NULL,
+    role varchar(32) NOT NULL,
+    status varchar(16) NOT NULL DEFAULT 'invited',
+    PRIMARY KEY(production_id, user_id),
+    FOREIGN KEY (production_id) REFERENCES productions(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,7 @@
+CREATE TABLE production_scripts (
+    production_id int NOT NULL,
+    line_set_id int NOT NULL,
+    PRIMARY KEY(production_id, line_set_id),
+    FOREIGN KEY (production_id) REFERENCES productions(id) ON DELETE CASCADE,
+    FOREIGN KEY (line_set_id) REFERENCES line_sets(id) ON DELETE CASCADE
+);
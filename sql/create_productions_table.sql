@@ -0,0 +1,6 @@
+CREATE TABLE productions (
+    id int NOT NULL AUTO_INCREMENT,
+    name varchar(1024) NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY(id)
+);
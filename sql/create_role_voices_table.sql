@@ -0,0 +1,7 @@
+CREATE TABLE role_voices (
+    line_set_id int NOT NULL,
+    role varchar(255) NOT NULL,
+    voice varchar(255) NOT NULL,
+    PRIMARY KEY(line_set_id, role),
+    FOREIGN KEY (line_set_id) REFERENCES line_sets(id) ON DELETE CASCADE
+);
@@ -1,6 +1,6 @@
 #include "Line.h"
 
-using std::string;
+using std::string, std::make_pair;
 
 Line::Line(string cue, string line, int id) :
     cue(cue),
@@ -37,18 +37,60 @@ void Line::set_notes(string s) {
     this->notes = s;
 }
 
+const vector<pair<string, string>>& Line::get_metadata() const {
+    return metadata;
+}
+
+void Line::add_metadata(string key, string value) {
+    metadata.push_back(make_pair(key, value));
+}
+
+string Line::get_act() const {
+    return act;
+}
+
+string Line::get_scene() const {
+    return scene;
+}
+
+void Line::set_heading(string act, string scene) {
+    this->act = act;
+    this->scene = scene;
+}
+
+/**
+ * Quotes a metadata value, escaping '"', '\\' and newlines.
+ */
+static string quote(const string& s) {
+    string quoted = "\"";
+    for (char c : s) {
+        if (c == '"' || c == '\\') {
+            quoted.push_back('\\');
+            quoted.push_back(c);
+        } else if (c == '\n') {
+            quoted += "\\n";
+        } else if (c != '\r') {
+            quoted.push_back(c);
+        }
+    }
+    return quoted + "\"";
+}
+
 ostream& operator<<(ostream& out, const Line& line) {
-    bool show_metadata = line.is_flagged || line.notes != "";
-    if (show_metadata) {
+    vector<string> fields;
+    if (line.is_flagged) {
+        fields.push_back("flagged");
+    }
+    if (line.notes != "") {
+        fields.push_back("notes=" + quote(line.notes));
+    }
+    for (auto [key, value] : line.metadata) {
+        fields.push_back(value == "" ? key : key + "=" + quote(value));
+    }
+    if (!fields.empty()) {
         out << string("[");
-        if (line.is_flagged) {
-            out << string("flagged");
-            if (line.notes != "") {
-                out << string(", ");
-            }
-        }
-        if (line.notes != "") {
-            out << string("notes=") << string("\"") << line.notes << string("\"");
+        for (size_t i = 0; i < fields.size(); i++) {
+            out << string(i > 0 ? ", " : "") << fields[i];
         }
         out << string("]\n");
     }
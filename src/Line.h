@@ -1,8 +1,10 @@
 #ifndef LINE_H
 #define LINE_H
 #include <string>
+#include <utility>
+#include <vector>
 
-using std::istream, std::ostream, std::string;
+using std::istream, std::ostream, std::string, std::pair, std::vector;
 
 /**
  * A Line object represents an actor's lines within
@@ -23,10 +25,22 @@ public:
     int get_id() const;
     bool get_is_flagged() const;
     string get_notes() const;
+    /**
+     * Metadata other than flagged and notes, such as blocking or
+     * tags, in the order it was read. It is written back unchanged.
+     */
+    const vector<pair<string, string>>& get_metadata() const;
+    /**
+     * The act and scene headings the line is under, or empty.
+     */
+    string get_act() const;
+    string get_scene() const;
 
     // Setters
     void set_flagged(bool is_flagged);
     void set_notes(string notes);
+    void add_metadata(string key, string value);
+    void set_heading(string act, string scene);
 
     // Output
     friend ostream& operator<<(ostream& out, const Line& line);
@@ -37,5 +51,8 @@ private:
     int id;
     bool is_flagged;
     string notes;
+    vector<pair<string, string>> metadata;
+    string act;
+    string scene;
 };
 #endif // LINE_H
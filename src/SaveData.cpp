@@ -61,6 +61,24 @@ static bool has_line_format(stringstream line) {
     return role != "" && line.peek() == ':';
 }
 
+/**
+ * Parse an act heading ("# Act One") or scene heading ("## The Garden").
+ * Returns: the level, 1 for an act or 2 for a scene, and the title, or
+ * nullopt if the heading is malformed.
+ */
+static optional<pair<int, string>> parse_heading(const string& text) {
+    size_t level = text.find_first_not_of('#');
+    if (level == string::npos || level > 2) {
+        return nullopt;
+    }
+    size_t start = text.find_first_not_of(" \t\r", level);
+    size_t end = text.find_last_not_of(" \t\r");
+    if (start == string::npos) {
+        return nullopt;
+    }
+    return make_pair((int)level, text.substr(start, end - start + 1));
+}
+
 static bool file_load_line_data(const string& path, vector<Line>& lines) {
     ifstream line_file;
     line_file.open(path);
@@ -72,15 +90,41 @@ static bool file_load_line_data(const string& path, vector<Line>& lines) {
 
     int line_id = 0;
     int line_count = 0;
+    // Headings apply to every line after them
+    string act, scene;
     while (line_file.peek() != EOF) {
         string cue, line, empty;
-        optional<vector<pair<string, string>>> metadata;
-        if (line_file.peek() == '[') {
-            metadata = parse_metadata(line_file);
-            if (!metadata) {
+        if (line_file.peek() == '\n' || line_file.peek() == '#') {
+            string text;
+            getline(line_file, text);
+            ++line_count;
+            if (text == "" || text == "\r") {
+                continue;
+            }
+            auto heading = parse_heading(text);
+            if (!heading) {
+                cerr << "Heading at line " << line_count << ": \"" << text << "\" has invalid format." << endl;
+                cerr << "Should start with # for an act or ## for a scene." << endl;
+                return false;
+            }
+            if (heading->first == 1) {
+                act = heading->second;
+                scene = "";
+            } else {
+                scene = heading->second;
+            }
+            continue;
+        }
+        // Metadata may be split over several bracketed lines
+        vector<pair<string, string>> metadata;
+        while (line_file.peek() == '[') {
+            auto fields = parse_metadata(line_file);
+            if (!fields) {
                 cout << "Failed to parse line file." << endl;
                 return false;
             }
+            metadata.insert(metadata.end(), fields->begin(), fields->end());
+            ++line_count;
         }
 
         getline(line_file, cue);
@@ -117,16 +161,16 @@ static bool file_load_line_data(const string& path, vector<Line>& lines) {
         }
 
         Line item = Line(cue, line, line_id++);
+        item.set_heading(act, scene);
 
-        for (auto [key, value] : metadata.value_or(vector<pair<string, string>>{})) {
+        for (auto [key, value] : metadata) {
             if (key == "flagged") {
                 item.set_flagged(true);
             } else if (key == "notes") {
                 item.set_notes(value);
             } else {
-                cout << "Line metadata: unknown field `" << key << "`" << endl;
+                item.add_metadata(key, value);
             }
-
         }
 
         lines.push_back(item);
@@ -148,7 +192,21 @@ bool SaveData::save_line_data(const string& name, const vector<Line>& lines) {
         cout << "Error: could not open file " << name << endl;
         return false;
     }
+    // Written the same way as by the web server's linefile package
+    string act, scene;
     for (const auto& line: lines) {
+        // Repeating the act heading is the only way to leave a scene
+        if (line.get_act() != act || (line.get_scene() == "" && scene != "")) {
+            if (line.get_act() != "") {
+                file << "# " << line.get_act() << "\n\n";
+            }
+            act = line.get_act();
+            scene = "";
+        }
+        if (line.get_scene() != scene) {
+            scene = line.get_scene();
+            file << "## " << scene << "\n\n";
+        }
         file << line << endl;
     }
     file.close();
@@ -216,7 +274,7 @@ static optional<vector<pair<string, string>>> parse_metadata(ifstream &file) {
         if (!isalpha(file.peek())) break;
 
         /* Get key */
-        while (isalpha(file.peek())) {
+        while (isalnum(file.peek()) || file.peek() == '_' || file.peek() == '-') {
             key.push_back(file.get());
         }
         
@@ -226,20 +284,35 @@ static optional<vector<pair<string, string>>> parse_metadata(ifstream &file) {
             file.get(); // '='
             while (isspace(file.peek())) file.get();
             if (file.peek() != '"') {
-                cout << "Line metadata: expected '\"' after `=`. Did you include quotes around the value?" << endl;
-                return nullopt;
-            }
-            file.get(); // '"'
-
-            /* Get value */
-            while (file.peek() != '"') {
-                if (file.peek() == '\n') {
-                    cout << "Line metadata: missing closing '\"'" << endl;
+                /* Unquoted values such as page=12 */
+                while (file.peek() != EOF && !isspace(file.peek())
+                        && file.peek() != ',' && file.peek() != ']') {
+                    value.push_back(file.get());
+                }
+                if (value == "") {
+                    cout << "Line metadata: expected a value after `=`. Did you include quotes around the value?" << endl;
                     return nullopt;
                 }
-                value.push_back(file.get());
+                while (file.peek() == ' ' || file.peek() == '\t') file.get();
+            } else {
+                file.get(); // '"'
+
+                /* Get value */
+                while (file.peek() != '"') {
+                    if (file.peek() == '\n' || file.peek() == EOF) {
+                        cout << "Line metadata: missing closing '\"'" << endl;
+                        return nullopt;
+                    }
+                    char c = file.get();
+                    if (c == '\\' && file.peek() != '\n' && file.peek() != EOF) {
+                        c = file.get();
+                        if (c == 'n') c = '\n';
+                    }
+                    value.push_back(c);
+                }
+                file.get(); // '"'
+                while (file.peek() == ' ' || file.peek() == '\t') file.get();
             }
-            file.get(); // '"'
         }
 
         data.push_back(make_pair(key, value));
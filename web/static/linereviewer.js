@@ -6,20 +6,253 @@ const frontInputs = document.getElementById("front_inputs");
 const backInputs = document.getElementById("back_inputs");
 const starredCheck = document.getElementById("starred");
 const notesText = document.getElementById("linenotes");
+const tagsText = document.getElementById("linetags");
+const answerInputs = document.getElementById("answer_inputs");
+const answerText = document.getElementById("answer");
+const checkButton = document.getElementById("checkbtn");
+const scoreText = document.getElementById("score");
+const grades = document.getElementById("grades");
+const hintButton = document.getElementById("hintbtn");
+const hintText = document.getElementById("hint");
+const playCueButton = document.getElementById("playcuebtn");
+const audioRows = document.querySelectorAll(".audio-row");
+const audioStatus = document.getElementById("audio_status");
+const audioPlayer = new Audio();
+const listenButton = document.getElementById("listenbtn");
+const speechPlayer = new Audio();
+
+// Each click shows the next, stronger hint. Hints used are sent with
+// the attempt and lower its grade.
+hintButton.addEventListener("click", () => {
+    if (hintsUsed < lineHints[i].length) {
+        ++hintsUsed;
+        display();
+    }
+});
 
 revealButton.addEventListener("click", () => {
     show_back = true;
+    revealMs = Date.now() - shownAt;
     display()
 });
 
+// Typed answers are graded by the server, so the line is revealed
+// with the score and the grade buttons are hidden.
+checkButton.addEventListener("click", async () => {
+    revealMs = Date.now() - shownAt;
+    const payload = {
+        "line": lineData[i].id,
+        "answer": answerText.value,
+        "review_method": reviewMethod,
+        "reveal_ms": revealMs,
+        "hints_used": hintsUsed,
+    };
+    const response = await fetch("/feline/checkline", {
+        method: "POST",
+        body: JSON.stringify(payload)
+    });
+    if (!response.ok) return;
+    const score = await response.json();
+    showScore(score);
+    checked = true;
+    show_back = true;
+    display();
+});
+
+function showScore(score) {
+    scoreText.innerText = "";
+    for (const word of score.diff) {
+        const span = document.createElement("span");
+        span.className = word.op;
+        span.innerText = word.text;
+        if (word.op == "typo") span.title = "You typed: " + word.typed;
+        scoreText.append(span, " ");
+    }
+    const accuracy = document.createElement("div");
+    accuracy.innerText = "Accuracy: " + Math.round(score.accuracy * 100) + "%";
+    scoreText.append(accuracy);
+}
+
+function hasAudio(line, kind) {
+    return (line.audio || []).includes(kind);
+}
+
+// The version changes when a clip is replaced so the old one is not
+// played from the cache.
+function playAudio(line, kind) {
+    audioPlayer.src = "/feline/lineaudio?line=" + line.id + "&kind=" + kind + "&v=" + (line.audioVersion || 0);
+    audioPlayer.play().catch(() => {});
+}
+
+async function uploadAudio(line, kind, clip) {
+    audioStatus.innerText = "Saving...";
+    const response = await fetch("/feline/lineaudio?line=" + line.id + "&kind=" + kind, {
+        method: "PUT",
+        headers: { "Content-Type": clip.type },
+        body: clip
+    });
+    audioStatus.innerText = response.ok ? "" : await response.text();
+    if (response.ok) {
+        line.audio = (line.audio || []).filter((k) => k != kind).concat([kind]);
+        line.audioVersion = Date.now();
+    }
+    display();
+}
+
+async function removeAudio(line, kind) {
+    const response = await fetch("/feline/lineaudio?line=" + line.id + "&kind=" + kind, { method: "DELETE" });
+    audioStatus.innerText = response.ok ? "" : await response.text();
+    if (response.ok) {
+        line.audio = (line.audio || []).filter((k) => k != kind);
+    }
+    display();
+}
+
+// Records from the microphone until the button is pressed again.
+let recorder = null;
+async function toggleRecording(line, kind) {
+    if (recorder) {
+        recorder.stop();
+        return;
+    }
+    let stream;
+    try {
+        stream = await navigator.mediaDevices.getUserMedia({ audio: true });
+    } catch (e) {
+        audioStatus.innerText = "Could not use the microphone.";
+        return;
+    }
+    const chunks = [];
+    recorder = new MediaRecorder(stream);
+    recorder.recordingKind = kind;
+    recorder.ondataavailable = (e) => chunks.push(e.data);
+    recorder.onstop = () => {
+        stream.getTracks().forEach((track) => track.stop());
+        const clip = new Blob(chunks, { type: recorder.mimeType });
+        recorder = null;
+        uploadAudio(line, kind, clip);
+    };
+    recorder.start();
+    display();
+}
+
+for (const row of audioRows) {
+    const kind = row.dataset.kind;
+    row.querySelector(".play").onclick = () => playAudio(lineData[i], kind);
+    row.querySelector(".record").onclick = () => toggleRecording(lineData[i], kind);
+    row.querySelector(".remove").onclick = () => removeAudio(lineData[i], kind);
+    const file = row.querySelector("input[type=file]");
+    file.onchange = () => {
+        if (file.files.length > 0) uploadAudio(lineData[i], kind, file.files[0]);
+        file.value = "";
+    };
+}
+
+playCueButton.addEventListener("click", () => playAudio(lineData[i], "cue"));
+
+// In the audio cue review, cues with a recording are heard rather than
+// read until the line is revealed.
+function listeningToCue(line) {
+    return reviewMethod == "audio_cue" && hasAudio(line, "cue");
+}
+let cuePlayedFor = -1;
+
+// In listen mode each cue is played, as recorded or read aloud, then
+// there is a pause for the actor to say the line before it is shown,
+// and the next cue follows on its own.
+let listening = false;
+let listenTimer = null;
+let listenedTo = -1;
+
+// Time to say the line, allowing for the actor to think first.
+function pauseForLine(line) {
+    return 2000 + 450 * line.line.split(/\s+/).length;
+}
+
+function listen() {
+    const at = i;
+    const line = lineData[at];
+    listenedTo = at;
+    const afterCue = () => {
+        if (i != at || !listening) return;
+        listenTimer = setTimeout(() => {
+            if (i != at || !listening) return;
+            show_back = true;
+            revealMs = Date.now() - shownAt;
+            display();
+            if (hasAudio(line, "line")) playAudio(line, "line");
+            listenTimer = setTimeout(() => {
+                if (i == at && listening) nextLine();
+            }, 3000);
+        }, pauseForLine(line));
+    };
+    // Cues that cannot be played are read from the screen instead
+    speechPlayer.onended = afterCue;
+    speechPlayer.onerror = afterCue;
+    speechPlayer.src = "/feline/linespeech?line=" + line.id + "&part=cue";
+    speechPlayer.play().catch(afterCue);
+}
+
+function stopListening() {
+    clearTimeout(listenTimer);
+    speechPlayer.pause();
+    speechPlayer.onended = speechPlayer.onerror = null;
+}
+
+listenButton.addEventListener("click", () => {
+    listening = !listening;
+    stopListening();
+    listenedTo = -1;
+    display();
+});
+
 let i = 0;
+let hintsUsed = 0;
+let checked = false;
 let show_back = false;
 let is_starred = false;
+// When the current cue was shown and how long it took to reveal the line
+let shownAt = Date.now();
+let revealMs = 0;
+
+// Logs the attempt at the current line. The grade is left empty when
+// the line is skipped without grading.
+async function recordAttempt(grade) {
+    const payload = {
+        "line": lineData[i].id,
+        "grade": grade,
+        "review_method": reviewMethod,
+        "reveal_ms": revealMs,
+        "hints_used": hintsUsed,
+    };
+    await fetch("/feline/gradeline", {
+        method: "POST",
+        body: JSON.stringify(payload)
+    });
+}
+
+async function gradeLine(grade) {
+    await recordAttempt(grade);
+    advance();
+}
 
 function nextLine() {
+    if (show_back && !checked && i < lineData.length) {
+        recordAttempt("");
+    }
+    advance();
+}
+
+function advance() {
     if (i < lineData.length) {
         ++i;
         show_back = false;
+        checked = false;
+        hintsUsed = 0;
+        answerText.value = "";
+        shownAt = Date.now();
+        audioPlayer.pause();
+        stopListening();
         display()
     }
 }
@@ -28,19 +261,61 @@ function previousLine() {
     if (i > 0) {
         --i;
         show_back = false;
+        checked = false;
+        hintsUsed = 0;
+        answerText.value = "";
+        shownAt = Date.now();
+        audioPlayer.pause();
+        stopListening();
         display()
     }
 }
 
 function display() {
-    frontText.innerText = lineData[i].cue
+    if (i >= lineData.length) {
+        headerText.innerText = "All done!"
+        frontText.innerText = lineData.length == 0 ? "There are no lines to review right now." : ""
+        revealText.hidden = true;
+        frontInputs.hidden = true;
+        backInputs.hidden = true;
+        return;
+    }
+
+    const hearingCue = listeningToCue(lineData[i]) && !show_back;
+    frontText.innerText = hearingCue ? "(Listen to the cue)" : lineData[i].cue
+    playCueButton.hidden = !hasAudio(lineData[i], "cue") || show_back || reviewMethod == "listen";
+    listenButton.hidden = reviewMethod != "listen";
+    listenButton.innerText = listening ? "Pause" : "Start listening";
+    if (listening && listenedTo != i) {
+        listen();
+    }
+    if (hearingCue && cuePlayedFor != i) {
+        cuePlayedFor = i;
+        playAudio(lineData[i], "cue");
+    }
+    for (const row of audioRows) {
+        const kind = row.dataset.kind;
+        const recording = recorder && recorder.recordingKind == kind;
+        row.querySelector(".play").hidden = !hasAudio(lineData[i], kind);
+        row.querySelector(".remove").hidden = !hasAudio(lineData[i], kind);
+        row.querySelector(".record").innerText = recording ? "Stop" : hasAudio(lineData[i], kind) ? "Re-record" : "Record";
+    }
     revealText.innerText = lineData[i].line
     headerText.innerText = "Line " + (lineData[i].id + 1)
     notesText.value = lineData[i].notes
+    tagsText.value = (lineData[i].tags || []).join(", ")
     starred.checked = lineData[i].starred;
 
     revealText.hidden = !show_back;
-    revealButton.hidden = show_back;
+    revealButton.hidden = show_back || typedAnswers;
+    answerInputs.hidden = show_back || !typedAnswers;
+    scoreText.hidden = !checked;
+    grades.hidden = checked;
+
+    const hints = lineHints[i] || [];
+    hintText.hidden = hintsUsed == 0 || show_back;
+    hintText.innerText = hintsUsed > 0 ? hints[hintsUsed - 1].text : "";
+    hintButton.hidden = show_back || hintsUsed >= hints.length;
     frontInputs.hidden = show_back;
     backInputs.hidden = !show_back;
     frontText.style.setProperty("opacity", show_back ? 0.5 : 1.0);
@@ -48,7 +323,7 @@ function display() {
     starred.onchange = async (e) => {
         console.log("starred.onchange");
         const payload = {
-            "line": i,
+            "line": lineData[i].id,
             "starred": e.target.checked,
         };
         const result = await fetch("/feline/starline", {
@@ -61,7 +336,7 @@ function display() {
     notesText.oninput = async (e) => {
         console.log("notesText.oninput")
         const payload = {
-            "line": i,
+            "line": lineData[i].id,
             "text": e.target.value,
         }
         await fetch("/feline/linenotes", {
@@ -70,6 +345,21 @@ function display() {
         })
         
     }
+
+    tagsText.onchange = async (e) => {
+        const line = lineData[i]
+        const payload = {
+            "line": line.id,
+            "tags": e.target.value,
+        }
+        const result = await fetch("/feline/linetags", {
+            method: "POST",
+            body: JSON.stringify(payload)
+        })
+        if (result.ok) {
+            line.tags = await result.json()
+        }
+    }
 }
 
 display()
@@ -1,11 +1,33 @@
 const title = document.getElementById("title");
 const data = document.getElementById("data");
 const submit = document.getElementById("submit")
+const modeScene = document.getElementById("mode-scene");
+const modePairs = document.getElementById("mode-pairs");
+const modeCards = document.getElementById("mode-cards");
+const pairsHelp = document.getElementById("pairs-help");
+const sceneHelp = document.getElementById("scene-help");
+const rolesList = document.getElementById("roles");
+const format = document.getElementById("format");
+const formatUsed = document.getElementById("format-used");
+const ambiguousList = document.getElementById("ambiguous");
+const cardsHelp = document.getElementById("cards-help");
+const cardsFile = document.getElementById("cards-file");
+const cards = document.getElementById("cards");
+const cardColumnsList = document.getElementById("card-columns");
+const cardProblem = document.getElementById("card-problem");
+const cardPreview = document.getElementById("card-preview");
+
+const mode = () => modeScene.checked ? "scene" : modeCards.checked ? "cards" : "pairs";
 
 const update = async () => {
     const payload = {
         title: title.value,
-        text: data.value
+        text: data.value,
+        mode: mode(),
+        roles: selectedRoles,
+        format: format.value,
+        alternatives: alternatives,
+        columns: cardColumns,
     };
 
     const response = await fetch('/feline/updatebuilder', {
@@ -14,5 +36,176 @@ const update = async () => {
     });
 };
 
+// Lists the roles in the scene as checkboxes so the actor can pick
+// which ones they are playing.
+const updateRoles = async () => {
+    const isScene = modeScene.checked;
+    pairsHelp.hidden = !modePairs.checked;
+    sceneHelp.hidden = !isScene;
+    rolesList.hidden = !isScene;
+    ambiguousList.hidden = true;
+    if (!isScene) return;
+
+    const response = await fetch('/api/v1/roles', {
+        method: "POST",
+        body: JSON.stringify({ text: data.value, format: format.value, alternatives: alternatives })
+    });
+    const result = await response.json();
+    if (!response.ok) {
+        rolesList.innerText = result.error;
+        formatUsed.innerText = "";
+        return;
+    }
+    formatUsed.innerText = format.value == "auto" && data.value.trim() != "" ? "(read as " + result.format + ")" : "";
+    showAmbiguous(result.ambiguous);
+
+    rolesList.innerText = "";
+    if (result.roles.length > 0) {
+        rolesList.append("I am playing: ");
+    }
+    for (const role of result.roles) {
+        const checkbox = document.createElement("input");
+        checkbox.type = "checkbox";
+        checkbox.id = "role-" + role;
+        checkbox.checked = selectedRoles.includes(role);
+        checkbox.onchange = () => {
+            selectedRoles = selectedRoles.filter((r) => r != role);
+            if (checkbox.checked) selectedRoles.push(role);
+            update();
+        };
+        const label = document.createElement("label");
+        label.htmlFor = checkbox.id;
+        label.innerText = role;
+        rolesList.append(checkbox, label);
+    }
+};
+
+// Lists the lines the importer had to guess about, each with a
+// checkbox to read it the other way instead.
+const showAmbiguous = (ambiguous) => {
+    ambiguousList.innerText = "";
+    ambiguousList.hidden = ambiguous.length == 0;
+    if (ambiguous.length == 0) return;
+
+    ambiguousList.append("Please check these lines were read correctly:");
+    const list = document.createElement("ul");
+    for (const line of ambiguous) {
+        const item = document.createElement("li");
+        const text = document.createElement("code");
+        text.innerText = line.text;
+        const checkbox = document.createElement("input");
+        checkbox.type = "checkbox";
+        checkbox.id = "alternative-" + line.line;
+        checkbox.checked = alternatives.includes(line.line);
+        checkbox.onchange = () => {
+            alternatives = alternatives.filter((l) => l != line.line);
+            if (checkbox.checked) alternatives.push(line.line);
+            update();
+            updateRoles();
+        };
+        const label = document.createElement("label");
+        label.htmlFor = checkbox.id;
+        label.innerText = "read as " + line.alternative;
+        item.append("Line " + line.line + ": ", text, " was read as " + line.guess + ". ", checkbox, label);
+        list.append(item);
+    }
+    ambiguousList.append(list);
+};
+
+// Shows how the deck will be read, with a choice of column for the
+// cue, line, notes and tags.
+const updateCards = async () => {
+    cardsHelp.hidden = cards.hidden = !modeCards.checked;
+    if (!modeCards.checked) return;
+
+    cardColumnsList.innerText = cardProblem.innerText = cardPreview.innerText = "";
+    if (data.value.trim() == "") return;
+    const response = await fetch('/api/v1/cards/preview', {
+        method: "POST",
+        body: JSON.stringify({ text: data.value, columns: cardColumns })
+    });
+    const result = await response.json();
+    if (!response.ok) {
+        cardProblem.innerText = result.error;
+        return;
+    }
+
+    const columns = result.columns;
+    for (const [field, name] of [["cue", "Cue"], ["line", "Line"], ["notes", "Notes"], ["tags", "Tags"]]) {
+        const select = document.createElement("select");
+        select.id = "column-" + field;
+        if (field == "notes" || field == "tags") select.append(new Option("none", -1));
+        for (let i = 0; i < result.width; i++) {
+            select.append(new Option("column " + (i + 1), i));
+        }
+        select.value = columns[field];
+        select.onchange = () => {
+            cardColumns = { ...columns, [field]: Number(select.value) };
+            update();
+            updateCards();
+        };
+        const label = document.createElement("label");
+        label.htmlFor = select.id;
+        label.innerText = name + ": ";
+        cardColumnsList.append(label, select, " ");
+    }
+    const header = document.createElement("input");
+    header.type = "checkbox";
+    header.id = "column-header";
+    header.checked = columns.header;
+    header.onchange = () => {
+        cardColumns = { ...columns, header: header.checked };
+        update();
+        updateCards();
+    };
+    const headerLabel = document.createElement("label");
+    headerLabel.htmlFor = header.id;
+    headerLabel.innerText = "First row names the columns";
+    cardColumnsList.append(header, headerLabel);
+
+    cardProblem.innerText = result.problem || "";
+    if (result.problem) return;
+    const heading = cardPreview.insertRow();
+    for (const name of ["Cue", "Line", "Notes", "Tags"]) {
+        const cell = document.createElement("th");
+        cell.innerText = name;
+        heading.append(cell);
+    }
+    for (const line of result.lines) {
+        const row = cardPreview.insertRow();
+        const tags = (line.starred ? ["starred"] : []).concat(line.tags || []);
+        for (const text of [line.cue, line.line, line.notes, tags.join(", ")]) {
+            row.insertCell().innerText = text;
+        }
+    }
+    if (result.count > result.lines.length) {
+        cardPreview.insertRow().insertCell().innerText = "... and " + (result.count - result.lines.length) + " more";
+    }
+};
+
+cardsFile.onchange = async () => {
+    if (cardsFile.files.length == 0) return;
+    data.value = await cardsFile.files[0].text();
+    cardColumns = null;
+    update();
+    updateCards();
+};
+
 title.oninput = update;
-data.oninput = update;
+format.onchange = () => {
+    alternatives = [];
+    update();
+    updateRoles();
+};
+data.oninput = () => {
+    update();
+    updateRoles();
+    updateCards();
+};
+modeScene.onchange = modePairs.onchange = modeCards.onchange = () => {
+    update();
+    updateRoles();
+    updateCards();
+};
+updateRoles();
+updateCards();
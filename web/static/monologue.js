@@ -0,0 +1,95 @@
+const headerText = document.getElementById("header")
+const stepText = document.getElementById("step")
+const contextText = document.getElementById("context")
+const hintText = document.getElementById("hint")
+const answerText = document.getElementById("answer")
+const frontInputs = document.getElementById("front_inputs");
+const backInputs = document.getElementById("back_inputs");
+const hintButton = document.getElementById("hintbtn");
+
+let line = 0;
+let step = 0;
+let show_answer = false;
+let show_hint = false;
+
+const stepDescriptions = {
+    "chunk": "Say the next phrase",
+    "cumulative": "Say everything so far",
+    "full": "Say the whole speech",
+};
+
+function reveal() {
+    show_answer = true;
+    display();
+}
+
+function showHint() {
+    show_hint = true;
+    display();
+}
+
+function nextStep() {
+    if (step + 1 < drills[line].steps.length) {
+        ++step;
+    } else {
+        ++line;
+        step = 0;
+    }
+    show_answer = false;
+    show_hint = false;
+    display();
+}
+
+function repeatStep() {
+    show_answer = false;
+    show_hint = false;
+    display();
+}
+
+function nextLine() {
+    if (line < drills.length) {
+        ++line;
+        step = 0;
+        show_answer = false;
+        show_hint = false;
+        display();
+    }
+}
+
+function previousLine() {
+    if (line > 0) {
+        --line;
+        step = 0;
+        show_answer = false;
+        show_hint = false;
+        display();
+    }
+}
+
+function display() {
+    if (line >= drills.length) {
+        headerText.innerText = "All done!";
+        stepText.innerText = "";
+        contextText.innerText = "";
+        hintText.innerText = "";
+        answerText.hidden = true;
+        frontInputs.hidden = true;
+        backInputs.hidden = true;
+        return;
+    }
+
+    const drill = drills[line];
+    const current = drill.steps[step];
+    headerText.innerText = "Line " + (drill.line.id + 1) + " (" + drill.role + ")";
+    stepText.innerText = stepDescriptions[current.kind] + " — step " + (step + 1) + " of " + drill.steps.length;
+    contextText.innerText = current.context;
+    hintText.innerText = show_hint || show_answer ? current.hint : "";
+    answerText.innerText = current.answer;
+
+    hintButton.hidden = current.hint == "" || show_hint;
+    answerText.hidden = !show_answer;
+    frontInputs.hidden = show_answer;
+    backInputs.hidden = !show_answer;
+}
+
+display()
@@ -0,0 +1,202 @@
+const headerText = document.getElementById("header")
+const statusText = document.getElementById("status")
+const cueText = document.getElementById("cue")
+const lineText = document.getElementById("line")
+const progressBar = document.getElementById("progress_bar")
+const playButton = document.getElementById("playbtn")
+const showButton = document.getElementById("showbtn")
+const player = document.getElementById("player")
+
+// The playlist comes from the server: each cue, a pause to say the line
+// in, and the line itself if the actor asked to check themselves. Pauses
+// are played as silent audio rather than timers so that the rehearsal
+// keeps going with the screen locked.
+
+let at = 0;
+let playing = false;
+let show_line = false;
+// Bumped whenever playback moves, so callbacks from an item that was
+// skipped are ignored
+let playback = 0;
+
+const lineCount = new Set(items.map(item => item.line)).size;
+
+const statusDescriptions = {
+    "cue": "Listen for your cue",
+    "pause": "Say your line",
+    "line": "Check your line",
+};
+
+// Cue and line text starts with the role, which is not read aloud.
+function withoutRole(text) {
+    return text.replace(/^[\p{L}\/]+:\s*/u, "");
+}
+
+function itemSource(item) {
+    if (item.kind == "pause") {
+        return "/feline/silence?ms=" + item.duration;
+    }
+    if (item.audio) {
+        return "/feline/linespeech?line=" + item.line + "&part=" + item.kind;
+    }
+    return null;
+}
+
+// Reads text the server cannot play with the browser's own voice, or
+// leaves time to read it from the screen.
+function speak(text, done) {
+    if (!window.speechSynthesis) {
+        setTimeout(done, 1500 + 300 * text.split(/\s+/).length);
+        return;
+    }
+    const utterance = new SpeechSynthesisUtterance(withoutRole(text));
+    utterance.onend = done;
+    utterance.onerror = done;
+    speechSynthesis.speak(utterance);
+}
+
+function playItem() {
+    const current = ++playback;
+    const done = () => {
+        if (current == playback && playing) {
+            ++at;
+            playItem();
+        }
+    };
+    display();
+    if (!playing || at >= items.length) {
+        if (at >= items.length) stop();
+        return;
+    }
+    const item = items[at];
+    const source = itemSource(item);
+    if (source) {
+        player.onended = done;
+        player.onerror = done;
+        player.src = source;
+        player.play().catch(done);
+    } else {
+        speak(item.text, done);
+    }
+}
+
+function stop() {
+    playing = false;
+    ++playback;
+    player.pause();
+    if (window.speechSynthesis) speechSynthesis.cancel();
+    display();
+}
+
+function start() {
+    if (at >= items.length) at = 0;
+    playing = true;
+    playItem();
+}
+
+// The index of the first item of the line at an index.
+function lineStart(index) {
+    while (index > 0 && index < items.length && items[index - 1].line == items[index].line) {
+        --index;
+    }
+    return index;
+}
+
+function moveTo(index) {
+    if (window.speechSynthesis) speechSynthesis.cancel();
+    player.pause();
+    at = Math.max(0, Math.min(index, items.length));
+    show_line = false;
+    if (playing) {
+        playItem();
+    } else {
+        ++playback;
+        display();
+    }
+}
+
+function previousLine() {
+    const start = lineStart(at);
+    moveTo(start == at || at >= items.length ? lineStart(start - 1) : start);
+}
+
+function repeatLine() {
+    moveTo(lineStart(at));
+}
+
+function nextLine() {
+    let index = at;
+    while (index < items.length && items[index].line == items[at].line) {
+        ++index;
+    }
+    moveTo(index);
+}
+
+function showLine() {
+    show_line = true;
+    display();
+}
+
+function display() {
+    playButton.innerText = playing ? "Pause" : (at > 0 && at < items.length ? "Resume" : "Start");
+    progressBar.style.width = (items.length ? 100 * at / items.length : 0) + "%";
+
+    if (items.length == 0) {
+        headerText.innerText = "There are no lines to rehearse.";
+        playButton.hidden = true;
+        showButton.hidden = true;
+        return;
+    }
+    if (at >= items.length) {
+        headerText.innerText = "End of the scene";
+        statusText.innerText = "";
+        cueText.innerText = "";
+        lineText.hidden = true;
+        showButton.hidden = true;
+        return;
+    }
+
+    const item = items[at];
+    const start = lineStart(at);
+    const number = new Set(items.slice(0, start).map(i => i.line)).size + 1;
+    headerText.innerText = "Line " + number + " of " + lineCount;
+    statusText.innerText = playing ? statusDescriptions[item.kind] : "Paused";
+
+    let end = start;
+    while (end < items.length && items[end].line == item.line) {
+        ++end;
+    }
+    const group = items.slice(start, end);
+    const cue = group.find(i => i.kind == "cue");
+    const said = group.find(i => i.kind == "pause" && i.text);
+    const checked = start + group.findIndex(i => i.kind == "line");
+    cueText.innerText = cue ? cue.text : "";
+    lineText.innerText = said ? said.text : "";
+    lineText.hidden = !said || !(show_line || (checked >= start && at >= checked));
+    showButton.hidden = !lineText.hidden || !said;
+
+    if ("mediaSession" in navigator) {
+        navigator.mediaSession.metadata = new MediaMetadata({
+            title: title,
+            artist: headerText.innerText,
+        });
+    }
+}
+
+playButton.addEventListener("click", () => {
+    if (playing) {
+        stop();
+    } else {
+        start();
+    }
+});
+
+// Headphone and car controls
+if ("mediaSession" in navigator) {
+    navigator.mediaSession.setActionHandler("play", start);
+    navigator.mediaSession.setActionHandler("pause", stop);
+    navigator.mediaSession.setActionHandler("previoustrack", previousLine);
+    navigator.mediaSession.setActionHandler("nexttrack", nextLine);
+}
+
+display()